@@ -2,16 +2,39 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/leonf08/metrics-yp.git/internal/app/agentapp"
 	"github.com/leonf08/metrics-yp.git/internal/config/agentconf"
+	"github.com/leonf08/metrics-yp.git/internal/config/configcheck"
 )
 
 var buildVersion, buildDate, buildCommit = "N/A", "N/A", "N/A"
 
 func main() {
+	config, err := agentconf.Load()
+	if configcheck.Requested() {
+		checkConfig(config.Settings(), err)
+		return
+	}
+
 	fmt.Printf("Build version: %s\nBuild date: %s\nBuild commit: %s\n", buildVersion, buildDate, buildCommit)
 
-	config := agentconf.MustLoadConfig()
+	if err != nil {
+		exit(fmt.Errorf("invalid configuration:\n%w", err))
+	}
+
 	agentapp.Run(config)
 }
+
+// checkConfig prints the effective configuration and exits with a non-zero code if it is invalid.
+func checkConfig(settings []configcheck.Setting, err error) {
+	if !configcheck.Report(os.Stdout, settings, err) {
+		os.Exit(1)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
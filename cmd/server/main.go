@@ -2,16 +2,49 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/leonf08/metrics-yp.git/internal/app/serverapp"
+	"github.com/leonf08/metrics-yp.git/internal/config/configcheck"
 	"github.com/leonf08/metrics-yp.git/internal/config/serverconf"
+	"github.com/spf13/pflag"
 )
 
 var buildVersion, buildDate, buildCommit = "N/A", "N/A", "N/A"
 
 func main() {
+	config, err := serverconf.Load()
+	if configcheck.Requested() {
+		checkConfig(config.Settings(), err)
+		return
+	}
+
+	if args := pflag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err != nil {
+			exit(fmt.Errorf("invalid configuration:\n%w", err))
+		}
+
+		migrateDB(config, args[1:])
+		return
+	}
+
 	fmt.Printf("Build version: %s\nBuild date: %s\nBuild commit: %s\n", buildVersion, buildDate, buildCommit)
 
-	config := serverconf.MustLoadConfig()
+	if err != nil {
+		exit(fmt.Errorf("invalid configuration:\n%w", err))
+	}
+
 	serverapp.Run(config)
 }
+
+// checkConfig prints the effective configuration and exits with a non-zero code if it is invalid.
+func checkConfig(settings []configcheck.Setting, err error) {
+	if !configcheck.Report(os.Stdout, settings, err) {
+		os.Exit(1)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/leonf08/metrics-yp.git/internal/config/serverconf"
+	"github.com/leonf08/metrics-yp.git/internal/database/migrations/postgres"
+)
+
+// migrateDB runs the migrate command: up, down [N], version or force VERSION.
+// It lets operators migrate the database explicitly when migrations at the start are disabled.
+func migrateDB(config serverconf.Config, args []string) {
+	if config.DatabaseAddr == "" {
+		exit(errors.New("migrate: database address is not set"))
+	}
+
+	cmd, err := postgres.ParseCommand(args)
+	if err != nil {
+		exit(fmt.Errorf("migrate: %w", err))
+	}
+
+	if err := cmd.Run(config.DatabaseAddr, os.Stdout); err != nil {
+		exit(fmt.Errorf("migrate %s: %w", cmd.Name, err))
+	}
+}
@@ -2,61 +2,348 @@ package agentapp
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	nethttp "net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/leonf08/metrics-yp.git/internal/client/auto"
 	"github.com/leonf08/metrics-yp.git/internal/client/grpc"
 	"github.com/leonf08/metrics-yp.git/internal/client/http"
 	"github.com/leonf08/metrics-yp.git/internal/config/agentconf"
 	"github.com/leonf08/metrics-yp.git/internal/logger"
 	"github.com/leonf08/metrics-yp.git/internal/services"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
-	"golang.org/x/sync/errgroup"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
 )
 
 // Run runs the agent.
+//
+// The configuration is read again on SIGHUP and when the configuration file changes.
+// Intervals, rate limit, mode, collectors and outputs of the new configuration are applied
+// by stopping the running poll loop and clients and starting new ones. Metrics are kept
+// in the storage shared by all of them, so metrics not yet reported are reported
+// by the new clients. If the new configuration is invalid, the agent keeps running
+// with the current one.
 func Run(cfg agentconf.Config) {
-	// Init logger, repo, agent, signer
+	// Init logger, repo
 	log := logger.NewLogger()
 	r := repo.NewStorage()
-	agent := services.NewAgentService(cfg.Mode, r)
-	signer := services.NewHashSigner(cfg.SignKey)
 
-	// Init crypto
-	var crypto services.Crypto
-	if cfg.CryptoKey != "" {
-		crypto = services.NewCryptoService(cfg.CryptoKey)
-	}
+	// Self-metrics of the agent are reported along with the other metrics
+	reg := telemetry.NewRegistry()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	defer cancel()
 
-	g, gtx := errgroup.WithContext(ctx)
+	var wg sync.WaitGroup
+
+	// Expose self-metrics locally
+	if cfg.MetricsAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveMetrics(ctx, cfg.MetricsAddr, reg, log)
+		}()
+	}
+
+	cs := &collectorSet{}
+	defer cs.close()
+
+	gen, err := start(ctx, cfg, r, reg, cs, log)
+	if err != nil {
+		log.Error().Err(err).Msg("app - Run - start")
+		return
+	}
+
+	updates := watchConfig(ctx, log)
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Wait for the clients to finish
+			gen.stop()
+			wg.Wait()
+
+			log.Info().Msg("app - Run - Shutdown the client")
+			return
+		case u := <-updates:
+			if u.err != nil {
+				log.Error().Err(u.err).Msg("app - Run - Reload, configuration rejected")
+				continue
+			}
 
-	// Create http client
-	httpclient := http.NewClient(resty.New(), agent, signer, crypto, log, cfg)
+			if reflect.DeepEqual(cfg, u.cfg) {
+				continue
+			}
 
-	// Create grpc client
-	grpcclient := grpc.NewClient(agent, log, cfg)
+			if cfg.MetricsAddr != u.cfg.MetricsAddr {
+				log.Warn().Str("setting", "metrics_address").
+					Msg("app - Run - Setting change requires restart, ignored")
+				u.cfg.MetricsAddr = cfg.MetricsAddr
+			}
 
-	// Start http client
-	log.Info().Str("mode", cfg.Mode).Msg("app - Run - HTTP client started")
-	g.Go(func() error {
-		return httpclient.Start(gtx)
-	})
+			log.Info().Msg("app - Run - Reload configuration")
+			gen.stop()
 
-	// Start grpc client
-	log.Info().Msg("app - Run - GRPC client started")
-	g.Go(func() error {
-		return grpcclient.Start(gtx)
-	})
+			next, err := start(ctx, u.cfg, r, reg, cs, log)
+			if err != nil {
+				log.Error().Err(err).Msg("app - Run - start, configuration rejected")
 
-	// Wait for the clients to finish
-	if err := g.Wait(); err != nil {
-		log.Err(err).Msg("app - Run - Error")
+				if gen, err = start(ctx, cfg, r, reg, cs, log); err != nil {
+					log.Error().Err(err).Msg("app - Run - start")
+					return
+				}
+				continue
+			}
+
+			gen, cfg = next, u.cfg
+			log.Info().Msg("app - Run - Configuration reloaded")
+		}
+	}
+}
+
+// update is a result of reading the configuration again.
+type update struct {
+	cfg agentconf.Config
+	err error
+}
+
+// watchConfig reads the configuration on SIGHUP and when the configuration file changes.
+// Both are handled by the same goroutine, so the configuration is never read concurrently.
+func watchConfig(ctx context.Context, log zerolog.Logger) <-chan update {
+	updates := make(chan update)
+	send := func(cfg agentconf.Config, err error) {
+		select {
+		case updates <- update{cfg: cfg, err: err}:
+		case <-ctx.Done():
+		}
+	}
+
+	changes, err := agentconf.Watch(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("app - watchConfig - Watch, configuration file changes are ignored")
 	}
 
-	log.Info().Msg("app - Run - Shutdown the client")
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				send(agentconf.Reload())
+			case <-changes:
+				send(agentconf.Reload())
+			}
+		}
+	}()
+
+	return updates
+}
+
+// generation is a poll loop and clients running with the same configuration.
+type generation struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// stop stops the poll loop and the clients and waits for them to finish.
+func (g *generation) stop() {
+	g.cancel()
+	g.wg.Wait()
+}
+
+// start starts gathering metrics and reporting them to the outputs of the configuration.
+func start(ctx context.Context, cfg agentconf.Config, r repo.Repository, reg *telemetry.Registry,
+	cs *collectorSet, log zerolog.Logger) (*generation, error) {
+	collectors, err := cs.build(cfg, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	agent := services.NewAgentService(cfg.Mode, r, collectors...)
+
+	ctx, cancel := context.WithCancel(ctx)
+	g := &generation{cancel: cancel}
+
+	// Gather metrics once for all outputs
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		poll(ctx, agent, cfg.PollInt, log)
+	}()
+
+	// Start a client for every output, failure of one output doesn't affect the others
+	for _, o := range cfg.EffectiveOutputs() {
+		client, err := newClient(agent, o, cfg, reg, log)
+		if err != nil {
+			log.Error().Err(err).Str("output", o.Name).Msg("app - start - newClient")
+			continue
+		}
+
+		log.Info().Str("output", o.Name).Str("transport", o.Transport).Str("address", o.Addr).
+			Str("mode", o.Mode).Msg("app - start - Client started")
+
+		g.wg.Add(1)
+		go func(name string) {
+			defer g.wg.Done()
+			if err := client.Start(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error().Err(err).Str("output", name).Msg("app - start - Client error")
+			}
+		}(o.Name)
+	}
+
+	return g, nil
+}
+
+// collectorSet builds collectors enabled in the configuration. The log tail collector
+// is kept while its rules are not changed, so positions in log files are not lost.
+type collectorSet struct {
+	logTail      *services.LogTailCollector
+	logRules     []agentconf.LogRule
+	logStateFile string
+}
+
+func (cs *collectorSet) build(cfg agentconf.Config, reg *telemetry.Registry) ([]services.Collector, error) {
+	collectors := []services.Collector{reg}
+	if len(cfg.ScrapeTargets) > 0 {
+		collectors = append(collectors,
+			services.NewInstrumentedCollector("scrape", services.NewScrapeCollector(cfg.ScrapeTargets), reg))
+	}
+	if len(cfg.ExecCommands) > 0 {
+		exec := services.NewExecCollector(cfg.ExecCommands, cfg.ExecTimeout)
+		collectors = append(collectors, services.NewInstrumentedCollector("exec", exec, reg))
+	}
+
+	if !reflect.DeepEqual(cs.logRules, cfg.LogRules) || cs.logStateFile != cfg.LogStateFile {
+		// The state of the old collector is saved before the new one loads it
+		cs.close()
+
+		if len(cfg.LogRules) > 0 {
+			rules := make([]services.LogRule, len(cfg.LogRules))
+			for i, lr := range cfg.LogRules {
+				rules[i] = services.LogRule(lr)
+			}
+
+			logTail, err := services.NewLogTailCollector(rules, cfg.LogStateFile)
+			if err != nil {
+				return nil, err
+			}
+
+			cs.logTail = logTail
+		}
+
+		cs.logRules, cs.logStateFile = cfg.LogRules, cfg.LogStateFile
+	}
+
+	if cs.logTail != nil {
+		collectors = append(collectors, services.NewInstrumentedCollector("logtail", cs.logTail, reg))
+	}
+
+	return collectors, nil
+}
+
+func (cs *collectorSet) close() {
+	if cs.logTail != nil {
+		cs.logTail.Close()
+		cs.logTail = nil
+	}
+	cs.logRules, cs.logStateFile = nil, ""
+}
+
+// client reports metrics to a single output.
+type client interface {
+	Start(context.Context) error
+}
+
+// newClient creates a client for the output. The client reports metrics
+// gathered by the agent which are selected by the filter of the output.
+func newClient(agent *services.AgentService, o agentconf.Output, cfg agentconf.Config,
+	reg *telemetry.Registry, log zerolog.Logger) (client, error) {
+	filter, err := services.NewMetricFilter(o.Include, o.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	a := agent.ForOutput(o.Mode, filter)
+	l := log.With().Str("output", o.Name).Logger()
+	ocfg := cfg.ForOutput(o)
+
+	var crypto services.Crypto
+	if o.CryptoKey != "" {
+		crypto = services.NewCryptoService(o.CryptoKey)
+	}
+
+	httpMetrics := telemetry.NewClientMetrics(reg, "http", o.Name)
+	grpcMetrics := telemetry.NewClientMetrics(reg, "grpc", o.Name)
+
+	switch o.Transport {
+	case "http":
+		return http.NewClient(resty.New(), a, services.NewHashSigner(o.SignKey), crypto, l, ocfg, httpMetrics), nil
+	case "grpc":
+		return grpc.NewClient(a, l, ocfg, grpcMetrics), nil
+	case "auto":
+		checker := grpc.NewClient(a, l, ocfg, grpcMetrics)
+		return auto.NewClient(
+			func() auto.Starter { return grpc.NewClient(a, l, ocfg, grpcMetrics) },
+			func() auto.Starter {
+				return http.NewClient(resty.New(), a, services.NewHashSigner(o.SignKey), crypto, l, ocfg, httpMetrics)
+			},
+			checker.Check, reg, o.Name, l), nil
+	default:
+		return nil, fmt.Errorf("invalid transport: %s", o.Transport)
+	}
+}
+
+// serveMetrics serves self-metrics on the /metrics endpoint until the context is done.
+func serveMetrics(ctx context.Context, addr string, reg *telemetry.Registry, log zerolog.Logger) {
+	mux := nethttp.NewServeMux()
+	mux.Handle("/metrics", reg.Handler())
+
+	srv := &nethttp.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			log.Error().Err(err).Msg("app - serveMetrics - Shutdown")
+		}
+	}()
+
+	log.Info().Str("address", addr).Msg("app - serveMetrics - Metrics endpoint started")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+		log.Error().Err(err).Msg("app - serveMetrics - ListenAndServe")
+	}
+}
+
+// poll gathers metrics every interval until the context is done.
+func poll(ctx context.Context, agent services.Agent, interval time.Duration, log zerolog.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			log.Info().Msg("app - poll - Gathering metrics")
+			if err := agent.GatherMetrics(ctx); err != nil {
+				log.Error().Err(err).Msg("app - poll - GatherMetrics")
+			}
+		}
+	}
 }
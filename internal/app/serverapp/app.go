@@ -1,6 +1,8 @@
 package serverapp
 
 import (
+	"context"
+	nethttp "net/http"
 	"net/netip"
 	"os"
 	"os/signal"
@@ -9,10 +11,15 @@ import (
 
 	"github.com/leonf08/metrics-yp.git/internal/config/serverconf"
 	"github.com/leonf08/metrics-yp.git/internal/logger"
+	"github.com/leonf08/metrics-yp.git/internal/proto"
 	"github.com/leonf08/metrics-yp.git/internal/server/grpc"
+	"github.com/leonf08/metrics-yp.git/internal/server/grpc/interceptors"
 	"github.com/leonf08/metrics-yp.git/internal/server/http"
 	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/query"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
 )
 
 // Run starts the application.
@@ -24,41 +31,55 @@ import (
 // If file storage is enabled, the metrics are restored from the file
 // when the server starts. The metrics are saved to the file every period
 // of time specified in the configuration.
+//
+// If the database is used, partitions of the history of metrics are created ahead of time
+// and dropped after the retention by a background maintenance job.
+// Rollups of the history at the configured resolutions are computed by another background job.
+//
+// On SIGHUP the configuration is read again. The signing keys and their replay protection
+// settings, the crypto keys, the trusted subnet, the log level and the store interval are applied
+// without a restart. If the new configuration is invalid, it is rejected
+// and the current configuration stays in effect.
 func Run(cfg serverconf.Config) {
 	var (
-		r  repo.Repository
-		fs services.FileStore
-		cr services.Crypto
-		ip services.IPChecker
+		r       repo.Repository
+		history repo.History
+		fs      *services.ScheduledFileStore
 	)
 
 	log := logger.NewLogger()
-	s := services.NewHashSigner(cfg.SignKey)
+	setLogLevel(cfg, log)
 
-	if cfg.CryptoKey != "" {
-		cr = services.NewCryptoService(cfg.CryptoKey)
-	}
+	// Self-metrics of the server
+	reg := telemetry.NewRegistry()
 
-	if cfg.TrustedSubnet != "" {
-		prefix, err := netip.ParsePrefix(cfg.TrustedSubnet)
-		if err != nil {
-			log.Error().Err(err).Msg("app - Run - ParsePrefix")
-			return
-		}
+	// Nonces of signed requests are remembered across reloads of the configuration
+	nonces := services.NewNonceCache()
 
-		ip = services.NewIPChecker(prefix)
+	c, err := newComponents(cfg, nonces)
+	if err != nil {
+		log.Error().Err(err).Msg("app - Run - newComponents")
+		return
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resolutions := cfg.Resolutions()
+
 	if cfg.IsInMemStorage() {
-		r = repo.NewStorage()
+		mem := repo.NewStorageWithHistory(services.RawRetention(resolutions))
+		r, history = mem, mem
 
 		if cfg.IsFileStorage() {
-			fileStorage, err := services.NewFileStorage(cfg.FileStoragePath)
+			storage, err := services.NewFileStorage(cfg.FileStoragePath)
 			if err != nil {
 				log.Error().Err(err).Msg("app - Run - NewFileStorage")
 				return
 			}
-			defer fileStorage.Close()
+			defer storage.Close()
+
+			fileStorage := services.NewInstrumentedFileStore(storage, reg)
 
 			if cfg.Restore {
 				log.Info().Msg("app - Run - Restore metrics from file")
@@ -69,52 +90,149 @@ func Run(cfg serverconf.Config) {
 				}
 			}
 
-			if cfg.StoreInt > 0 {
-				go func() {
-					for {
-						<-time.After(time.Duration(cfg.StoreInt) * time.Second)
-						log.Info().Msg("app - Run - Save metrics to file")
-						if err = fileStorage.Save(r); err != nil {
-							log.Error().Err(err).Msg("app - Run - fileStorage.Save")
-						}
-					}
-				}()
-			} else {
-				fs = fileStorage
-			}
+			// Metrics are saved on every change if the store interval is zero
+			fs = services.NewScheduledFileStore(fileStorage, cfg.StoreInt)
+			go fs.Run(ctx, r, func(err error) {
+				log.Error().Err(err).Msg("app - Run - fileStorage.Save")
+			})
 		}
 	} else {
-		db, err := repo.NewDB(cfg.DatabaseAddr)
+		db, err := repo.NewDB(cfg.DatabaseAddr, cfg.Pool(), cfg.DBAutoMigrate, log, reg)
 		if err != nil {
 			log.Error().Err(err).Msg("app - Run - NewDB")
 			return
 		}
 		defer db.Close()
 
-		r = db
+		// Partitions of the history are created before the servers accept samples
+		maintenance, err := repo.NewMaintenance(db, cfg.Retention(), log, reg)
+		if err != nil {
+			log.Error().Err(err).Msg("app - Run - NewMaintenance")
+			return
+		}
+		if err := maintenance.Maintain(ctx, time.Now()); err != nil {
+			log.Error().Err(err).Msg("app - Run - maintenance.Maintain")
+			return
+		}
+		go maintenance.Run(ctx)
+
+		r, history = db, db
 	}
 
-	router := http.NewRouter(s, cr, r, fs, ip, log)
-	httpserver := http.NewServer(router, cfg.Addr)
-	log.Info().Str("address", cfg.Addr).Msg("app - Run - Starting httpserver")
+	r = repo.Instrument(r, reg)
 
-	grpcserver := grpc.NewServer(r, fs, log, cfg.GRPCAddr, cfg.TrustedSubnet)
-	log.Info().Str("address", cfg.GRPCAddr).Msg("app - Run - Starting grpcserver")
+	if len(resolutions) > 0 {
+		rollups := services.NewRollups(history, resolutions, reg)
+		go rollups.Run(ctx, cfg.RollupInterval, func(err error) {
+			log.Error().Err(err).Msg("app - Run - rollups.Compute")
+		})
+	}
+	counters := services.NewCounterTracker(reg)
+	historyQuery := services.NewHistoryQuery(history, resolutions)
+	engine := query.NewEngine(r, historyQuery)
+
+	var store services.FileStore
+	if fs != nil {
+		store = fs
+	}
+
+	// Both servers use TLS if the certificate is set, the certificate is reloaded when its files change
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("app - Run - TLSConfig")
+		return
+	}
+
+	handler := http.NewReloadableHandler(http.NewRouter(c.signer, c.guard, c.strict, c.crypto, r, store, counters, historyQuery, engine, c.ip, reg, log))
+	httpserver := http.NewServer(handler, cfg.Addr, tlsConfig)
+	log.Info().Str("address", cfg.Addr).Bool("tls", tlsConfig != nil).Msg("app - Run - Starting httpserver")
+
+	security := interceptors.NewSecurity(c.security(), proto.Metrics_UpdateMetric_FullMethodName)
+	grpcserver := grpc.NewServer(r, store, counters, log, cfg.GRPCAddr, tlsConfig, security, reg)
+	log.Info().Str("address", cfg.GRPCAddr).Bool("tls", tlsConfig != nil).Msg("app - Run - Starting grpcserver")
+
+	// The internal server exposing self-metrics is started only if its address is set
+	metricsErr := make(<-chan error)
+	if cfg.MetricsAddr != "" {
+		mux := nethttp.NewServeMux()
+		mux.Handle("/metrics", reg.Handler())
+
+		metricsserver := http.NewServer(mux, cfg.MetricsAddr, nil)
+		log.Info().Str("address", cfg.MetricsAddr).Msg("app - Run - Starting metrics server")
+
+		defer func() {
+			if err := metricsserver.Shutdown(); err != nil {
+				log.Error().Err(err).Msg("app - Run - metricsserver.Shutdown")
+			}
+		}()
+
+		metricsErr = metricsserver.Err()
+	}
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
-	select {
-	case err := <-httpserver.Err():
-		log.Error().Err(err).Msg("app - Run - httpserver.Err")
-	case err := <-grpcserver.Err():
-		log.Error().Err(err).Msg("app - Run - grpcserver.Err")
-	case sig := <-interrupt:
-		log.Info().Str("signal", sig.String()).Msg("app - Run - signal")
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+loop:
+	for {
+		select {
+		case err := <-httpserver.Err():
+			log.Error().Err(err).Msg("app - Run - httpserver.Err")
+			break loop
+		case err := <-grpcserver.Err():
+			log.Error().Err(err).Msg("app - Run - grpcserver.Err")
+			break loop
+		case err := <-metricsErr:
+			log.Error().Err(err).Msg("app - Run - metricsserver.Err")
+			break loop
+		case sig := <-interrupt:
+			log.Info().Str("signal", sig.String()).Msg("app - Run - signal")
+			break loop
+		case <-hangup:
+			log.Info().Msg("app - Run - Reload configuration")
+
+			newCfg, err := serverconf.Reload()
+			if err != nil {
+				log.Error().Err(err).Msg("app - Run - Reload, configuration rejected")
+				continue
+			}
+
+			c, err := newComponents(newCfg, nonces)
+			if err != nil {
+				log.Error().Err(err).Msg("app - Run - newComponents, configuration rejected")
+				continue
+			}
+
+			for _, name := range restartRequired(cfg, newCfg) {
+				log.Warn().Str("setting", name).Msg("app - Run - Setting change requires restart, ignored")
+			}
+
+			handler.Store(http.NewRouter(c.signer, c.guard, c.strict, c.crypto, r, store, counters, historyQuery, engine, c.ip, reg, log))
+			security.Store(c.security())
+			if fs != nil {
+				fs.SetInterval(newCfg.StoreInt)
+			}
+
+			cfg.SignKey = newCfg.SignKey
+			cfg.SignSkew = newCfg.SignSkew
+			cfg.SignLegacy = newCfg.SignLegacy
+			cfg.SignKeys = newCfg.SignKeys
+			cfg.SignStrict = newCfg.SignStrict
+			cfg.CryptoKeys = newCfg.CryptoKeys
+			cfg.CryptoKey = newCfg.CryptoKey
+			cfg.TrustedSubnet = newCfg.TrustedSubnet
+			cfg.StoreInt = newCfg.StoreInt
+			cfg.LogLevel = newCfg.LogLevel
+			setLogLevel(cfg, log)
+
+			log.Info().Msg("app - Run - Configuration reloaded")
+		}
 	}
 
 	log.Info().Msg("app - Run - Shutdown the httpserver")
-	err := httpserver.Shutdown()
+	err = httpserver.Shutdown()
 	if err != nil {
 		log.Error().Err(err).Msg("app - Run - httpserver.Shutdown")
 	}
@@ -122,3 +240,104 @@ func Run(cfg serverconf.Config) {
 	log.Info().Msg("app - Run - Shutdown the grpcserver")
 	grpcserver.Shutdown()
 }
+
+// components are parts of the server which are replaced when the configuration is reloaded.
+type components struct {
+	signer *services.HashSigner
+	guard  *services.ReplayGuard
+	strict bool
+	crypto services.Crypto
+	ip     services.IPChecker
+}
+
+// security returns the configuration of the security checks of the gRPC server.
+func (c components) security() interceptors.SecurityConfig {
+	return interceptors.SecurityConfig{IP: c.ip, Signer: c.signer, Guard: c.guard, Strict: c.strict, Crypto: c.crypto}
+}
+
+func newComponents(cfg serverconf.Config, nonces *services.NonceCache) (components, error) {
+	// The keys without IDs are the default ones, named keys are selected by clients
+	signKeys := []services.SigningKey{{Key: cfg.SignKey}}
+	for _, k := range cfg.SignKeys {
+		expires, err := serverconf.ParseExpires(k.Expires)
+		if err != nil {
+			return components{}, err
+		}
+
+		signKeys = append(signKeys, services.SigningKey{ID: k.ID, Key: k.Key, Expires: expires})
+	}
+
+	cryptoKeys := []services.CryptoKey{{File: cfg.CryptoKey}}
+	for _, k := range cfg.CryptoKeys {
+		expires, err := serverconf.ParseExpires(k.Expires)
+		if err != nil {
+			return components{}, err
+		}
+
+		cryptoKeys = append(cryptoKeys, services.CryptoKey{ID: k.ID, File: k.File, Expires: expires})
+	}
+
+	c := components{
+		signer: services.NewHashSignerWithKeys(signKeys...),
+		guard:  services.NewReplayGuard(cfg.SignSkew, cfg.SignLegacy, nonces),
+		strict: cfg.SignStrict,
+	}
+
+	// The interface must stay nil if there are no keys
+	if cr := services.NewCryptoServiceWithKeys(cryptoKeys...); cr != nil {
+		c.crypto = cr
+	}
+
+	if cfg.TrustedSubnet != "" {
+		prefix, err := netip.ParsePrefix(cfg.TrustedSubnet)
+		if err != nil {
+			return components{}, err
+		}
+
+		c.ip = services.NewIPChecker(prefix)
+	}
+
+	return c, nil
+}
+
+// restartRequired returns names of settings which are changed,
+// but can't be applied without a restart. Names are ordered alphabetically.
+func restartRequired(old, cfg serverconf.Config) []string {
+	settings := []struct {
+		name    string
+		changed bool
+	}{
+		{"address", old.Addr != cfg.Addr},
+		{"database_dsn", old.DatabaseAddr != cfg.DatabaseAddr},
+		{"db_auto_migrate", old.DBAutoMigrate != cfg.DBAutoMigrate},
+		{"db_pool", old.Pool() != cfg.Pool()},
+		{"db_retention", old.Retention() != cfg.Retention()},
+		{"grpc_address", old.GRPCAddr != cfg.GRPCAddr},
+		{"metrics_address", old.MetricsAddr != cfg.MetricsAddr},
+		{"restore", old.Restore != cfg.Restore},
+		{"rollups", old.RollupResolutions != cfg.RollupResolutions || old.RollupInterval != cfg.RollupInterval},
+		{"store_file", old.FileStoragePath != cfg.FileStoragePath},
+		{"tls_cert", old.TLSCert != cfg.TLSCert},
+		{"tls_client_ca", old.TLSClientCA != cfg.TLSClientCA},
+		{"tls_key", old.TLSKey != cfg.TLSKey},
+	}
+
+	var names []string
+	for _, s := range settings {
+		if s.changed {
+			names = append(names, s.name)
+		}
+	}
+
+	return names
+}
+
+func setLogLevel(cfg serverconf.Config, log zerolog.Logger) {
+	level, err := cfg.Level()
+	if err != nil {
+		log.Error().Err(err).Msg("app - setLogLevel")
+		return
+	}
+
+	zerolog.SetGlobalLevel(level)
+}
@@ -0,0 +1,165 @@
+// Package auto provides a client switching between transports depending on availability of the server.
+package auto
+
+import (
+	"context"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+)
+
+const (
+	checkInterval = 5 * time.Second
+	checkTimeout  = 2 * time.Second
+
+	// Delays of restarts of clients which stopped by themselves, the delay doubles
+	// while clients keep stopping shortly after the start
+	minRestartDelay = time.Second
+	maxRestartDelay = 30 * time.Second
+)
+
+type (
+	// Starter is a client reporting metrics until the context is done.
+	Starter interface {
+		Start(context.Context) error
+	}
+
+	// Client reports metrics with the primary client while its server is healthy
+	// and falls back to the secondary client otherwise. Health of the primary server
+	// is checked periodically, reporting returns to the primary client once checks pass.
+	Client struct {
+		primary   func() Starter
+		secondary func() Starter
+		check     func(context.Context) error
+		interval  time.Duration
+		log       zerolog.Logger
+
+		minDelay time.Duration
+		maxDelay time.Duration
+
+		fallback *telemetry.Gauge
+		switches *telemetry.Counter
+	}
+)
+
+// NewClient creates a new client. Primary and secondary clients are created
+// by the given functions every time reporting switches to them.
+// Fallback state is exposed in the registry as the agent_transport_fallback gauge
+// and the agent_transport_switches_total counter labeled with the output name.
+func NewClient(primary, secondary func() Starter, check func(context.Context) error,
+	reg *telemetry.Registry, output string, l zerolog.Logger) *Client {
+	labels := map[string]string{"output": output}
+
+	return &Client{
+		primary:   primary,
+		secondary: secondary,
+		check:     check,
+		interval:  checkInterval,
+		log:       l,
+		minDelay:  minRestartDelay,
+		maxDelay:  maxRestartDelay,
+		fallback:  reg.Gauge(telemetry.AgentPrefix+"transport_fallback", labels),
+		switches:  reg.Counter(telemetry.AgentPrefix+"transport_switches_total", labels),
+	}
+}
+
+// Start starts reporting metrics. A client which stops by itself is replaced
+// by the other one after a delay, which grows while clients keep failing.
+func (c *Client) Start(ctx context.Context) error {
+	usePrimary := c.healthy(ctx)
+	c.setState(usePrimary)
+
+	var delay time.Duration
+	for {
+		started := time.Now()
+		cctx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+
+		var s Starter
+		if usePrimary {
+			s = c.primary()
+		} else {
+			s = c.secondary()
+		}
+
+		go func() {
+			done <- s.Start(cctx)
+		}()
+
+		var stopped bool
+		usePrimary, stopped = c.watch(ctx, done, usePrimary)
+		cancel()
+		if !stopped {
+			<-done
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if stopped {
+			// A client which worked for a while starts the backoff over
+			if time.Since(started) >= c.maxDelay {
+				delay = 0
+			}
+			delay = min(max(2*delay, c.minDelay), c.maxDelay)
+
+			c.log.Warn().Dur("delay", delay).Msg("auto client - restarting after a delay")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		} else {
+			delay = 0
+		}
+
+		c.switches.Inc()
+		c.setState(usePrimary)
+	}
+}
+
+// watch waits until the state has to be switched or the context is done.
+// It returns the new state and true if the running client has already stopped.
+func (c *Client) watch(ctx context.Context, done <-chan error, usePrimary bool) (bool, bool) {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return usePrimary, false
+		case err := <-done:
+			// The client stopped by itself, try the other one
+			c.log.Error().Err(err).Msg("auto client - client stopped")
+			return !usePrimary, true
+		case <-t.C:
+			if healthy := c.healthy(ctx); healthy != usePrimary {
+				return healthy, false
+			}
+		}
+	}
+}
+
+func (c *Client) healthy(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	err := c.check(ctx)
+	if err != nil {
+		c.log.Debug().Err(err).Msg("auto client - health check failed")
+	}
+
+	return err == nil
+}
+
+func (c *Client) setState(usePrimary bool) {
+	if usePrimary {
+		c.fallback.Set(0)
+		c.log.Info().Msg("auto client - using primary transport")
+	} else {
+		c.fallback.Set(1)
+		c.log.Warn().Msg("auto client - falling back to secondary transport")
+	}
+}
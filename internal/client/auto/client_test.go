@@ -0,0 +1,102 @@
+package auto
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStarter struct {
+	started *atomic.Int32
+}
+
+func (f fakeStarter) Start(ctx context.Context) error {
+	f.started.Add(1)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// failingStarter stops at once as a client failing to start does.
+type failingStarter struct {
+	started *atomic.Int32
+}
+
+func (f failingStarter) Start(context.Context) error {
+	f.started.Add(1)
+	return errors.New("failed")
+}
+
+func TestClient_Start(t *testing.T) {
+	var (
+		primary, secondary atomic.Int32
+		healthy            atomic.Bool
+	)
+
+	reg := telemetry.NewRegistry()
+	c := NewClient(
+		func() Starter { return fakeStarter{started: &primary} },
+		func() Starter { return fakeStarter{started: &secondary} },
+		func(context.Context) error {
+			if healthy.Load() {
+				return nil
+			}
+			return errors.New("unavailable")
+		},
+		reg, "test", zerolog.Nop())
+	c.interval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Start(ctx)
+	}()
+
+	// The primary server is unavailable, the secondary client is used
+	assert.Eventually(t, func() bool { return secondary.Load() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(0), primary.Load())
+	assert.Equal(t, float64(1), reg.Gauge("agent_transport_fallback", map[string]string{"output": "test"}).Value())
+
+	// The primary server is back, reporting returns to the primary client
+	healthy.Store(true)
+	assert.Eventually(t, func() bool { return primary.Load() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return reg.Gauge("agent_transport_fallback", map[string]string{"output": "test"}).Value() == 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(1), reg.Counter("agent_transport_switches_total", map[string]string{"output": "test"}).Value())
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("client didn't stop")
+	}
+}
+
+func TestClient_StartBackoff(t *testing.T) {
+	var started atomic.Int32
+
+	c := NewClient(
+		func() Starter { return failingStarter{started: &started} },
+		func() Starter { return failingStarter{started: &started} },
+		func(context.Context) error { return nil },
+		telemetry.NewRegistry(), "test", zerolog.Nop())
+	c.minDelay = 20 * time.Millisecond
+	c.maxDelay = 80 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	err := c.Start(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Delays are 20, 40, 80, 80... ms, so clients are restarted a few times rather than spinning
+	assert.GreaterOrEqual(t, started.Load(), int32(3))
+	assert.LessOrEqual(t, started.Load(), int32(8))
+}
@@ -2,37 +2,68 @@ package grpc
 
 import (
 	"context"
+	"encoding/hex"
+	"fmt"
 	"runtime"
 	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/client/http"
 	"github.com/leonf08/metrics-yp.git/internal/client/workerpool"
 	"github.com/leonf08/metrics-yp.git/internal/config/agentconf"
+	"github.com/leonf08/metrics-yp.git/internal/models"
 	proto2 "github.com/leonf08/metrics-yp.git/internal/proto"
 	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"go.uber.org/ratelimit"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// Client is a gRPC client for sending metrics to the server
 type Client struct {
-	agent  services.Agent
-	log    zerolog.Logger
-	config agentconf.Config
+	agent   services.Agent
+	log     zerolog.Logger
+	config  agentconf.Config
+	signer  *services.HashSigner
+	crypto  services.Crypto
+	metrics *telemetry.ClientMetrics
 }
 
-func NewClient(a services.Agent, l zerolog.Logger, config agentconf.Config) *Client {
-	return &Client{
-		agent:  a,
-		log:    l,
-		config: config,
+// NewClient creates a new client. Metrics are encrypted if the crypto key is set.
+func NewClient(a services.Agent, l zerolog.Logger, config agentconf.Config, m *telemetry.ClientMetrics) *Client {
+	c := &Client{
+		agent:   a,
+		log:     l,
+		config:  config,
+		signer:  services.NewHashSigner(config.SignKey),
+		metrics: m,
 	}
+
+	if config.CryptoKey != "" {
+		c.crypto = services.NewCryptoService(config.CryptoKey)
+	}
+
+	return c
 }
 
+// Start starts reporting metrics to the server.
+// Metrics are expected to be gathered by the caller.
+// It returns after the context is done and reporting is stopped.
 func (c *Client) Start(ctx context.Context) error {
-	conn, err := grpc.Dial(c.config.GRPCAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opts, err := c.dialOptions()
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial(c.config.GRPCAddr, opts...)
 	if err != nil {
 		return err
 	}
@@ -40,29 +71,104 @@ func (c *Client) Start(ctx context.Context) error {
 
 	client := proto2.NewMetricsClient(conn)
 
-	go c.poll(ctx)
-
-	go c.report(ctx, client)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.report(ctx, client)
+	}()
 
 	<-ctx.Done()
+	<-done
 	return ctx.Err()
 }
 
-func (c *Client) poll(ctx context.Context) {
-	t := time.NewTicker(time.Duration(c.config.PollInt) * time.Second)
-	defer t.Stop()
+// Check checks whether the server is available using the standard gRPC health checking protocol.
+// A server which responds, but doesn't implement the health service, is considered available.
+func (c *Client) Check(ctx context.Context) error {
+	opts, err := c.dialOptions()
+	if err != nil {
+		return err
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-t.C:
-			c.log.Info().Msg("grpc client - Start - Gathering metrics")
-			if err := c.agent.GatherMetrics(ctx); err != nil {
-				c.log.Error().Err(err).Msg("GatherMetrics")
-			}
+	conn, err := grpc.DialContext(ctx, c.config.GRPCAddr, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return nil
 		}
+
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("server status: %s", resp.GetStatus())
 	}
+
+	return nil
+}
+
+// dialOptions returns options of the connection. TLS credentials are used if TLS is enabled
+// and insecure credentials otherwise. Requests are compressed with gzip, as the HTTP client does.
+func (c *Client) dialOptions() ([]grpc.DialOption, error) {
+	tlsConfig, err := c.config.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)),
+	}, nil
+}
+
+// sign adds the signature of the call to outgoing metadata of the context.
+// Calls are signed with the same scheme as HTTP requests, the signed payload
+// is the full method name followed by the request message.
+func (c *Client) sign(ctx context.Context, method string, req proto.Message) (context.Context, error) {
+	if c.signer == nil {
+		return ctx, nil
+	}
+
+	payload, err := proto2.SignedPayload(method, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var kv []string
+	if c.config.SignKeyID != "" {
+		kv = append(kv, services.HeaderKeyID, c.config.SignKeyID)
+	}
+
+	if c.config.SignLegacy {
+		hash, err := c.signer.CalcHash(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		kv = append(kv, services.HeaderHash, hex.EncodeToString(hash))
+		return metadata.AppendToOutgoingContext(ctx, kv...), nil
+	}
+
+	headers, err := c.signer.SignatureHeaders(payload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		kv = append(kv, k, v)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, kv...), nil
 }
 
 func (c *Client) report(ctx context.Context, client proto2.MetricsClient) {
@@ -76,8 +182,9 @@ func (c *Client) report(ctx context.Context, client proto2.MetricsClient) {
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
 	rateLimiter := ratelimit.New(c.config.RateLim)
+	source := services.AgentSource()
 
-	t := time.NewTicker(time.Duration(c.config.ReportInt) * time.Second)
+	t := time.NewTicker(c.config.ReportInt)
 	defer t.Stop()
 
 	for {
@@ -93,14 +200,49 @@ func (c *Client) report(ctx context.Context, client proto2.MetricsClient) {
 
 			tasks := make([]workerpool.Task, 0, len(metrics))
 			for k, v := range metrics {
+				k, v := k, v
+
+				// Counters are reported as cumulative values, as the HTTP client does
+				metric := &proto2.Metric{Id: k, Type: v.Type}
+				switch v := v.Val.(type) {
+				case float64:
+					metric.Value = v
+				case int64:
+					metric.Value = float64(v)
+					metric.Temporality = models.TemporalityCumulative
+					metric.Source = source
+				}
+
+				req := &proto2.UpdateMetricRequest{Metric: metric}
+
+				ctx := ctx
+				if c.crypto != nil {
+					if err := req.Encrypt(c.crypto.Encrypt); err != nil {
+						c.log.Error().Err(err).Msg("gRPC client - Start - Encrypt")
+						return
+					}
+
+					if c.config.CryptoKeyID != "" {
+						ctx = metadata.AppendToOutgoingContext(ctx, services.HeaderCryptoKeyID, c.config.CryptoKeyID)
+					}
+				}
+
 				fn := func() error {
-					resp, err := client.UpdateMetric(ctx, &proto2.UpdateMetricRequest{
-						Metric: &proto2.Metric{
-							Id:    k,
-							Type:  v.Type,
-							Value: v.Val.(float64),
-						},
-					})
+					c.metrics.Requests.Inc()
+					c.metrics.Bytes.Add(int64(proto.Size(req)))
+
+					ctx, err := c.sign(ctx, proto2.Metrics_UpdateMetric_FullMethodName, req)
+					if err != nil {
+						c.metrics.Failed.Inc()
+						c.metrics.Dropped.Inc()
+						return err
+					}
+
+					resp, err := client.UpdateMetric(ctx, req)
+					if err != nil {
+						c.metrics.Failed.Inc()
+						c.metrics.Dropped.Inc()
+					}
 
 					c.log.Info().Str("response", resp.String()).Msg("grpc client - update metric")
 					return err
@@ -109,13 +251,19 @@ func (c *Client) report(ctx context.Context, client proto2.MetricsClient) {
 				tasks = append(tasks, fn)
 			}
 
+			start := time.Now()
+
+			c.metrics.Queue.Set(float64(len(tasks)))
 			pool := workerpool.NewWorkerPool(tasks, runtime.NumCPU(), rateLimiter)
-			result := pool.Run()
+			result := pool.RunContext(ctx)
 			for err := range result {
-				if err != nil {
+				c.metrics.Queue.Add(-1)
+				if err != nil && ctx.Err() == nil {
 					c.log.Error().Err(err).Msg("gRPC client - Start - Send request")
 				}
 			}
+
+			c.metrics.Latency.Set(time.Since(start).Seconds())
 		}
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/leonf08/metrics-yp.git/internal/models"
 	"github.com/leonf08/metrics-yp.git/internal/services"
 	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -20,6 +21,7 @@ func TestNewClient(t *testing.T) {
 		a      services.Agent
 		l      zerolog.Logger
 		config agentconf.Config
+		m      *telemetry.ClientMetrics
 	}
 	tests := []struct {
 		name string
@@ -32,17 +34,19 @@ func TestNewClient(t *testing.T) {
 				a:      &services.AgentService{},
 				l:      zerolog.Logger{},
 				config: agentconf.Config{},
+				m:      &telemetry.ClientMetrics{},
 			},
 			want: &Client{
-				agent:  &services.AgentService{},
-				log:    zerolog.Logger{},
-				config: agentconf.Config{},
+				agent:   &services.AgentService{},
+				log:     zerolog.Logger{},
+				config:  agentconf.Config{},
+				metrics: &telemetry.ClientMetrics{},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewClient(tt.args.a, tt.args.l, tt.args.config); !reflect.DeepEqual(got, tt.want) {
+			if got := NewClient(tt.args.a, tt.args.l, tt.args.config, tt.args.m); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewClient() = %v, want %v", got, tt.want)
 			}
 		})
@@ -51,7 +55,6 @@ func TestNewClient(t *testing.T) {
 
 func TestClient_Start(t *testing.T) {
 	mockAgent := mocks.NewAgent(t)
-	mockAgent.On("GatherMetrics", mock.Anything).Return(nil)
 	mockAgent.On("GetMetrics", mock.Anything).Return(make(map[string]models.Metric), nil)
 
 	client := NewClient(mockAgent, zerolog.Logger{}, agentconf.Config{
@@ -59,7 +62,7 @@ func TestClient_Start(t *testing.T) {
 		PollInt:   1,
 		ReportInt: 2,
 		RateLim:   10,
-	})
+	}, telemetry.NewClientMetrics(telemetry.NewRegistry(), "grpc", "test"))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
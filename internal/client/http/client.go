@@ -3,14 +3,19 @@ package http
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"runtime"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/leonf08/metrics-yp.git/internal/client/workerpool"
 	"github.com/leonf08/metrics-yp.git/internal/config/agentconf"
+	"github.com/leonf08/metrics-yp.git/internal/models"
 	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"go.uber.org/ratelimit"
 )
@@ -23,61 +28,61 @@ const (
 
 // Client is a client for collecting and sending metrics to the server
 type Client struct {
-	client *resty.Client
-	agent  services.Agent
-	signer *services.HashSigner
-	crypto services.Crypto
-	log    zerolog.Logger
-	config agentconf.Config
+	client  *resty.Client
+	agent   services.Agent
+	signer  *services.HashSigner
+	crypto  services.Crypto
+	log     zerolog.Logger
+	config  agentconf.Config
+	metrics *telemetry.ClientMetrics
 }
 
 // NewClient creates a new client
 func NewClient(cl *resty.Client, a services.Agent, s *services.HashSigner, cr services.Crypto,
-	l zerolog.Logger, config agentconf.Config) *Client {
+	l zerolog.Logger, config agentconf.Config, m *telemetry.ClientMetrics) *Client {
 	return &Client{
-		client: cl,
-		agent:  a,
-		signer: s,
-		crypto: cr,
-		log:    l,
-		config: config,
+		client:  cl,
+		agent:   a,
+		signer:  s,
+		crypto:  cr,
+		log:     l,
+		config:  config,
+		metrics: m,
 	}
 }
 
-// Start starts the client
+// Start starts reporting metrics to the server.
+// Metrics are expected to be gathered by the caller.
+// It returns after the context is done and reporting is stopped.
 func (c *Client) Start(ctx context.Context) error {
-	// Gather metrics
-	go c.poll(ctx)
-
-	// Report metrics
-	go c.report(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.report(ctx)
+	}()
 
 	<-ctx.Done()
+	<-done
 	return ctx.Err()
 }
 
-func (c *Client) poll(ctx context.Context) {
-	t := time.NewTicker(time.Duration(c.config.PollInt) * time.Second)
-	defer t.Stop()
+func (c *Client) report(ctx context.Context) {
+	tlsConfig, err := c.config.TLSConfig()
+	if err != nil {
+		c.log.Error().Err(err).Msg("client - Start - TLSConfig")
+		return
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-t.C:
-			c.log.Info().Msg("client - Start - Gathering metrics")
-			if err := c.agent.GatherMetrics(ctx); err != nil {
-				c.log.Error().Err(err).Msg("GatherMetrics")
-			}
-		}
+	scheme := "http://"
+	if tlsConfig != nil {
+		scheme = "https://"
+		c.client.SetTLSClientConfig(tlsConfig)
 	}
-}
 
-func (c *Client) report(ctx context.Context) {
 	if c.config.Mode == "batch" {
-		c.client.SetBaseURL("http://" + c.config.Addr + "/updates")
+		c.client.SetBaseURL(scheme + c.config.Addr + "/updates")
 	} else {
-		c.client.SetBaseURL("http://" + c.config.Addr + "/update")
+		c.client.SetBaseURL(scheme + c.config.Addr + "/update")
 	}
 
 	c.client.OnBeforeRequest(func(cl *resty.Client, r *resty.Request) error {
@@ -91,7 +96,13 @@ func (c *Client) report(ctx context.Context) {
 	})
 
 	if c.config.Mode == "query" {
-		c.client.SetHeader("Content-Type", "text/plain")
+		// Counters are reported as cumulative values, gauges ignore the parameters
+		c.client.
+			SetHeader("Content-Type", "text/plain").
+			SetQueryParams(map[string]string{
+				"temporality": models.TemporalityCumulative,
+				"source":      services.AgentSource(),
+			})
 	} else {
 		c.client.SetHeaders(map[string]string{
 			"Content-Type":     "application/json",
@@ -100,6 +111,11 @@ func (c *Client) report(ctx context.Context) {
 		})
 	}
 
+	// Requests are signed right before every attempt, so retries are sent with fresh nonces
+	c.client.SetPreRequestHook(func(_ *resty.Client, r *http.Request) error {
+		return c.sign(r)
+	})
+
 	ip, err := GetIP()
 	if err != nil {
 		c.log.Error().Err(err).Msg("GetIP")
@@ -114,7 +130,7 @@ func (c *Client) report(ctx context.Context) {
 
 	rateLimiter := ratelimit.New(c.config.RateLim)
 
-	t := time.NewTicker(time.Duration(c.config.ReportInt) * time.Second)
+	t := time.NewTicker(c.config.ReportInt)
 	defer t.Stop()
 
 	for {
@@ -128,10 +144,19 @@ func (c *Client) report(ctx context.Context) {
 				return
 			}
 
+			start := time.Now()
+
 			if c.config.Mode == "batch" {
-				_, err = c.client.R().
-					SetBody(payload[0]).
-					SetContext(ctx).Post("")
+				// The number of metrics in the batch is needed to count dropped metrics
+				var count int
+				if metrics, err := c.agent.GetMetrics(ctx); err == nil {
+					count = len(metrics)
+				}
+
+				c.metrics.Queue.Set(1)
+				// The route of batches has the trailing slash, which resty trims from the base URL
+				err = c.send(c.client.R().SetBody(payload[0]).SetContext(ctx), "/", len(payload[0]), count)
+				c.metrics.Queue.Set(0)
 				if err != nil {
 					c.log.Error().Err(err).Msg("client - Start - Send batch request")
 				}
@@ -149,47 +174,131 @@ func (c *Client) report(ctx context.Context) {
 								c.log.Error().Err(err).Msg("client - Start - Encrypt")
 								return
 							}
-						}
-						if c.signer != nil {
-							hash, err := c.signer.CalcHash(b)
-							if err != nil {
-								c.log.Error().Err(err).Msg("client - Start - CalcHash")
-								return
-							}
 
-							r.SetHeader("HashSHA256", hex.EncodeToString(hash))
+							if c.config.CryptoKeyID != "" {
+								r.SetHeader(services.HeaderCryptoKeyID, c.config.CryptoKeyID)
+							}
 						}
 
 						fn = func() error {
-							_, err = r.SetBody(b).
-								SetContext(ctx).
-								Post("")
-							return err
+							return c.send(r.SetBody(b).SetContext(ctx), "", len(b), 1)
 						}
 					} else {
 						fn = func() error {
-							_, err = r.SetPathParam("path", p).
-								SetContext(ctx).
-								Post("/{path}")
-							return err
+							return c.send(r.SetPathParam("path", p).SetContext(ctx), "/{path}", len(p), 1)
 						}
 					}
 
 					tasks = append(tasks, fn)
 				}
 
+				c.metrics.Queue.Set(float64(len(tasks)))
 				pool := workerpool.NewWorkerPool(tasks, runtime.NumCPU(), rateLimiter)
-				result := pool.Run()
+				result := pool.RunContext(ctx)
 				for err := range result {
-					if err != nil {
+					c.metrics.Queue.Add(-1)
+					if err != nil && ctx.Err() == nil {
 						c.log.Error().Err(err).Msg("client - Start - Send request")
 					}
 				}
 			}
+
+			c.metrics.Latency.Set(time.Since(start).Seconds())
 		}
 	}
 }
 
+// sign sets signature headers of the request if the signer is set. The signature covers
+// the body, or the method and the target if there is no body, as metrics of query mode
+// travel in the URL.
+func (c *Client) sign(r *http.Request) error {
+	if c.signer == nil {
+		return nil
+	}
+
+	var body []byte
+	if r.GetBody != nil {
+		rc, err := r.GetBody()
+		if err != nil {
+			return err
+		}
+
+		// Requests without a body may have no reader of it
+		if rc != nil {
+			defer rc.Close()
+
+			if body, err = io.ReadAll(rc); err != nil {
+				return err
+			}
+		}
+	}
+
+	headers, err := c.signatureHeaders(services.RequestPayload(r.Method, r.URL.RequestURI(), body))
+	if err != nil {
+		return err
+	}
+
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	return nil
+}
+
+// signatureHeaders returns signature headers of the payload, none if the signer is not set.
+// The ID of the key is sent if it is configured.
+// The signature covers a timestamp and a nonce unless legacy signatures are configured.
+func (c *Client) signatureHeaders(payload []byte) (map[string]string, error) {
+	if c.signer == nil {
+		return nil, nil
+	}
+
+	headers := make(map[string]string)
+	if c.config.SignKeyID != "" {
+		headers[services.HeaderKeyID] = c.config.SignKeyID
+	}
+
+	if c.config.SignLegacy {
+		hash, err := c.signer.CalcHash(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		headers[services.HeaderHash] = hex.EncodeToString(hash)
+		return headers, nil
+	}
+
+	signed, err := c.signer.SignatureHeaders(payload, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range signed {
+		headers[k] = v
+	}
+
+	return headers, nil
+}
+
+// send sends the request carrying count metrics and updates self-metrics. The request
+// is considered failed if it can't be sent or the server responds with an error status.
+func (c *Client) send(r *resty.Request, url string, size, count int) error {
+	c.metrics.Requests.Inc()
+	c.metrics.Bytes.Add(int64(size))
+
+	resp, err := r.Post(url)
+	if err == nil && resp.IsError() {
+		err = fmt.Errorf("server responded with status %s", resp.Status())
+	}
+
+	if err != nil {
+		c.metrics.Failed.Inc()
+		c.metrics.Dropped.Add(int64(count))
+	}
+
+	return err
+}
+
 func GetIP() (net.IP, error) {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
@@ -3,15 +3,24 @@ package http
 import (
 	"context"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/leonf08/metrics-yp.git/internal/config/agentconf"
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/server/http/middleware"
 	"github.com/leonf08/metrics-yp.git/internal/services"
 	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewClient(t *testing.T) {
@@ -22,6 +31,7 @@ func TestNewClient(t *testing.T) {
 		cr     services.Crypto
 		l      zerolog.Logger
 		config agentconf.Config
+		m      *telemetry.ClientMetrics
 	}
 	tests := []struct {
 		name string
@@ -37,20 +47,22 @@ func TestNewClient(t *testing.T) {
 				cr:     &services.CryptoService{},
 				l:      zerolog.Logger{},
 				config: agentconf.Config{},
+				m:      &telemetry.ClientMetrics{},
 			},
 			want: &Client{
-				client: &resty.Client{},
-				agent:  &services.AgentService{},
-				signer: &services.HashSigner{},
-				crypto: &services.CryptoService{},
-				log:    zerolog.Logger{},
-				config: agentconf.Config{},
+				client:  &resty.Client{},
+				agent:   &services.AgentService{},
+				signer:  &services.HashSigner{},
+				crypto:  &services.CryptoService{},
+				log:     zerolog.Logger{},
+				config:  agentconf.Config{},
+				metrics: &telemetry.ClientMetrics{},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, NewClient(tt.args.cl, tt.args.a, tt.args.s, tt.args.cr, tt.args.l, tt.args.config), "NewClient(%v, %v, %v, %v, %v, %v)", tt.args.cl, tt.args.a, tt.args.s, tt.args.cr, tt.args.l, tt.args.config)
+			assert.Equalf(t, tt.want, NewClient(tt.args.cl, tt.args.a, tt.args.s, tt.args.cr, tt.args.l, tt.args.config, tt.args.m), "NewClient(%v, %v, %v, %v, %v, %v, %v)", tt.args.cl, tt.args.a, tt.args.s, tt.args.cr, tt.args.l, tt.args.config, tt.args.m)
 		})
 	}
 }
@@ -60,7 +72,6 @@ func TestClient_Start(t *testing.T) {
 	defer cancel()
 
 	mockAgent := mocks.NewAgent(t)
-	mockAgent.On("GatherMetrics", ctx).Return(nil)
 	mockAgent.On("ReportMetrics", ctx).Return([]string{"metric1", "metric2"}, nil)
 
 	config := agentconf.Config{
@@ -69,12 +80,115 @@ func TestClient_Start(t *testing.T) {
 		RateLim:   10,
 	}
 
-	client := NewClient(resty.New(), mockAgent, &services.HashSigner{}, &services.CryptoService{}, zerolog.Logger{}, config)
+	client := NewClient(resty.New(), mockAgent, &services.HashSigner{}, &services.CryptoService{}, zerolog.Logger{}, config,
+		telemetry.NewClientMetrics(telemetry.NewRegistry(), "http", "test"))
 
 	err := client.Start(ctx)
 	assert.NotNil(t, err)
 }
 
+func TestClient_StartQueryStrict(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	srv := httptest.NewServer(middleware.Auth(services.NewHashSigner("key"), nil, true)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case received <- r:
+			default:
+			}
+		})))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mockAgent := mocks.NewAgent(t)
+	mockAgent.On("ReportMetrics", mock.Anything).Return([]string{"gauge/Alloc/1.5"}, nil).Maybe()
+
+	config := agentconf.Config{
+		Addr:      strings.TrimPrefix(srv.URL, "http://"),
+		Mode:      "query",
+		ReportInt: 100 * time.Millisecond,
+		RateLim:   10,
+	}
+
+	client := NewClient(resty.New(), mockAgent, services.NewHashSigner("key"), nil, zerolog.Logger{}, config,
+		telemetry.NewClientMetrics(telemetry.NewRegistry(), "http", "test"))
+
+	done := make(chan error)
+	go func() { done <- client.Start(ctx) }()
+
+	select {
+	case r := <-received:
+		assert.Equal(t, "/update/gauge/Alloc/1.5", r.URL.Path)
+		assert.Equal(t, "cumulative", r.URL.Query().Get("temporality"))
+	case <-ctx.Done():
+		require.Fail(t, "signed query request is not accepted by the strict server")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestClient_StartRetrySigned(t *testing.T) {
+	// The first attempt passes the replay guard and the connection is dropped, so resty retries
+	// the request, which must carry a new nonce. Batches are sent one at a time,
+	// so the second attempt is the retry.
+	var attempts atomic.Int32
+	accepted := make(chan struct{}, 1)
+	guard := services.NewReplayGuard(time.Minute, false, services.NewNonceCache())
+	auth := middleware.Auth(services.NewHashSigner("key"), guard, true)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Load() == 2 {
+				select {
+				case accepted <- struct{}{}:
+				default:
+				}
+			}
+		}))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) > 1 {
+			auth.ServeHTTP(w, r)
+			return
+		}
+
+		auth.ServeHTTP(httptest.NewRecorder(), r)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	mockAgent := mocks.NewAgent(t)
+	mockAgent.On("ReportMetrics", mock.Anything).Return([]string{`[{"id":"Alloc","type":"gauge","value":1.5}]`}, nil).Maybe()
+	mockAgent.On("GetMetrics", mock.Anything).Return(map[string]models.Metric{}, nil).Maybe()
+
+	config := agentconf.Config{
+		Addr:      strings.TrimPrefix(srv.URL, "http://"),
+		Mode:      "batch",
+		ReportInt: 100 * time.Millisecond,
+		RateLim:   10,
+	}
+
+	client := NewClient(resty.New(), mockAgent, services.NewHashSigner("key"), nil, zerolog.Logger{}, config,
+		telemetry.NewClientMetrics(telemetry.NewRegistry(), "http", "test"))
+
+	done := make(chan error)
+	go func() { done <- client.Start(ctx) }()
+
+	select {
+	case <-accepted:
+	case <-ctx.Done():
+		require.Fail(t, "retry of the request is rejected by the replay guard")
+	}
+
+	cancel()
+	<-done
+}
+
 func TestGetIP(t *testing.T) {
 	ip, err := GetIP()
 	assert.Nil(t, err)
@@ -1,6 +1,7 @@
 package workerpool
 
 import (
+	"context"
 	"sync"
 
 	"go.uber.org/ratelimit"
@@ -30,8 +31,14 @@ func NewWorkerPool(ts []Task, ws int, l ratelimit.Limiter) *WorkerPool {
 }
 
 func (w *WorkerPool) Run() <-chan error {
+	return w.RunContext(context.Background())
+}
+
+// RunContext runs the tasks like Run. Tasks not started before the context is done
+// are skipped, their result is the error of the context.
+func (w *WorkerPool) RunContext(ctx context.Context) <-chan error {
 	for i := 0; i < w.workers; i++ {
-		go w.work()
+		go w.work(ctx)
 	}
 
 	w.Add(len(w.tasks))
@@ -49,8 +56,14 @@ func (w *WorkerPool) Run() <-chan error {
 	return w.result
 }
 
-func (w *WorkerPool) work() {
+func (w *WorkerPool) work(ctx context.Context) {
 	for t := range w.jobs {
+		if err := ctx.Err(); err != nil {
+			w.result <- err
+			w.Done()
+			continue
+		}
+
 		w.limiter.Take()
 		w.result <- t()
 		w.Done()
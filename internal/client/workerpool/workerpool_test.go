@@ -1,6 +1,8 @@
 package workerpool
 
 import (
+	"context"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -114,3 +116,22 @@ func Test_newWorkerPool(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkerPool_RunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran atomic.Int32
+	tasks := make([]Task, 10)
+	for i := range tasks {
+		tasks[i] = func() error {
+			ran.Add(1)
+			return nil
+		}
+	}
+
+	for err := range NewWorkerPool(tasks, 2, ratelimit.New(1)).RunContext(ctx) {
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	assert.Equal(t, int32(0), ran.Load())
+}
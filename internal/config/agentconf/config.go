@@ -1,21 +1,36 @@
 package agentconf
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/fsnotify/fsnotify"
+	"github.com/leonf08/metrics-yp.git/internal/config/configcheck"
+	"github.com/leonf08/metrics-yp.git/internal/config/duration"
+	"github.com/leonf08/metrics-yp.git/internal/tlsutil"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 const (
-	defaultAddress        = "localhost:8080"
-	defaultReportInt uint = 10
-	defaultPollInt   uint = 2
-	defaultRateLimit uint = 10
-	defaultMode           = "json"
-	defaultGRPCAddr       = "localhost:8081"
+	defaultAddress          = "localhost:8080"
+	defaultReportInt        = 10 * time.Second
+	defaultPollInt          = 2 * time.Second
+	defaultRateLimit   uint = 10
+	defaultMode             = "json"
+	defaultGRPCAddr         = "localhost:8081"
+	defaultExecTimeout      = 5 * time.Second
+	defaultTransport        = transportHTTP
 )
 
 const (
@@ -28,6 +43,28 @@ const (
 	flagModeName           = "mode"
 	flagRateLimitName      = "rate_limit"
 	flagGRPCAddrName       = "grpc_address"
+	flagScrapeTargetsName  = "scrape_targets"
+	flagExecCommandsName   = "exec_commands"
+	flagExecTimeoutName    = "exec_timeout"
+	flagLogStateFileName   = "log_state_file"
+	keyLogRules            = "log_rules"
+	keyOutputs             = "outputs"
+	flagTransportName      = "transport"
+	flagMetricsAddrName    = "metrics_address"
+	flagTLSName            = "tls"
+	flagTLSCAName          = "tls_ca"
+	flagTLSCertName        = "tls_cert"
+	flagTLSKeyName         = "tls_key"
+	flagTLSServerName      = "tls_server_name"
+	flagSignLegacyName     = "auth_legacy"
+	flagSignKeyIDName      = "auth_key_id"
+	flagCryptoKeyIDName    = "crypto_key_id"
+)
+
+const (
+	transportHTTP = "http"
+	transportGRPC = "grpc"
+	transportAuto = "auto"
 )
 
 type modeEnum string
@@ -50,6 +87,100 @@ func (m *modeEnum) Type() string {
 	return "modeEnum"
 }
 
+type transportEnum string
+
+func (t *transportEnum) Set(s string) error {
+	switch s {
+	case transportHTTP, transportGRPC, transportAuto:
+		*t = transportEnum(s)
+		return nil
+	default:
+		return fmt.Errorf("invalid transport value: %s", s)
+	}
+}
+
+func (t *transportEnum) String() string {
+	return string(*t)
+}
+
+func (t *transportEnum) Type() string {
+	return "transportEnum"
+}
+
+// LogRule describes how lines of a log file are turned into a metric
+type LogRule struct {
+	// Name of the metric
+	Name string `mapstructure:"name"`
+
+	// File is a path to the log file
+	File string `mapstructure:"file"`
+
+	// Pattern is a regular expression matched against every line
+	Pattern string `mapstructure:"pattern"`
+
+	// Type is counter to count matching lines or gauge to take a value of the first capture group
+	Type string `mapstructure:"type"`
+}
+
+// Output is a destination the agent reports metrics to
+type Output struct {
+	// Name identifies the output in logs
+	Name string `mapstructure:"name"`
+
+	// Transport is http, grpc or auto. Auto transport reports over grpc
+	// and falls back to http while the grpc server is unavailable
+	Transport string `mapstructure:"transport"`
+
+	// Addr is the address of the server, the address of the grpc server for auto transport
+	Addr string `mapstructure:"address"`
+
+	// FallbackAddr is the address of the http server for auto transport
+	FallbackAddr string `mapstructure:"fallback_address"`
+
+	// Mode of operation for http transport, inherited from the agent if empty
+	Mode string `mapstructure:"mode"`
+
+	// SignKey used in hash calculation for authentication
+	SignKey string `mapstructure:"auth_key"`
+
+	// SignKeyID is sent to the server to select the key requests are verified with
+	SignKeyID string `mapstructure:"auth_key_id"`
+
+	// SignLegacy signs requests without a timestamp and a nonce for old servers,
+	// it is enabled if it is enabled for the agent as well
+	SignLegacy bool `mapstructure:"auth_legacy"`
+
+	// CryptoKey is a path to a file with public key for encryption
+	CryptoKey string `mapstructure:"crypto_key"`
+
+	// CryptoKeyID is sent to the server to select the key requests are decrypted with
+	CryptoKeyID string `mapstructure:"crypto_key_id"`
+
+	// RateLim limits the number of requests per second, inherited from the agent if zero
+	RateLim int `mapstructure:"rate_limit"`
+
+	// Include is a list of glob patterns, only metrics with matching names are reported if it is not empty
+	Include []string `mapstructure:"include"`
+
+	// Exclude is a list of glob patterns, metrics with matching names are not reported
+	Exclude []string `mapstructure:"exclude"`
+
+	// TLS enables TLS, it is enabled if it is enabled for the agent as well
+	TLS bool `mapstructure:"tls"`
+
+	// TLSCA is a path to the CA bundle, inherited from the agent if empty
+	TLSCA string `mapstructure:"tls_ca"`
+
+	// TLSCert is a path to the client certificate, inherited from the agent if empty
+	TLSCert string `mapstructure:"tls_cert"`
+
+	// TLSKey is a path to the key of the client certificate, inherited from the agent if empty
+	TLSKey string `mapstructure:"tls_key"`
+
+	// TLSServerName is the name the server certificate is verified for, inherited from the agent if empty
+	TLSServerName string `mapstructure:"tls_server_name"`
+}
+
 // Config is a configuration for the agent
 type Config struct {
 	// Addr is the address of the server to send metrics to
@@ -61,75 +192,584 @@ type Config struct {
 	// SignKey used in hash calculation for authentication
 	SignKey string `env:"KEY"`
 
+	// SignKeyID is sent to the server to select the key requests are verified with,
+	// the default key of the server is used if it is empty
+	SignKeyID string `env:"KEY_ID"`
+
+	// SignLegacy signs requests without a timestamp and a nonce for old servers
+	SignLegacy bool `env:"AUTH_LEGACY"`
+
 	// CryptoKey is a path to a file with public key for encryption
 	CryptoKey string `env:"CRYPTO_KEY"`
 
+	// CryptoKeyID is sent to the server to select the key requests are decrypted with,
+	// the default key of the server is used if it is empty
+	CryptoKeyID string `env:"CRYPTO_KEY_ID"`
+
 	// ReportInt is the interval for sending metrics to the server
-	ReportInt uint `env:"REPORT_INTERVAL"`
+	ReportInt time.Duration `env:"REPORT_INTERVAL"`
 
 	// PollInt is the interval for collecting metrics
-	PollInt uint `env:"POLL_INTERVAL"`
+	PollInt time.Duration `env:"POLL_INTERVAL"`
 
 	// RateLim limits the number of requests per second
 	RateLim int `env:"RATE_LIMIT"`
 
 	// GRPCAddr is the address of the gRPC server
 	GRPCAddr string `env:"GRPC_ADDRESS"`
+
+	// ScrapeTargets are URLs of endpoints exposing metrics in Prometheus text format
+	ScrapeTargets []string `env:"SCRAPE_TARGETS" envSeparator:","`
+
+	// ExecCommands are shell commands whose output is collected as metrics.
+	// Counters printed as lines are increments since the previous run, counters printed
+	// as JSON are increments unless their temporality is cumulative
+	ExecCommands []string `env:"EXEC_COMMANDS" envSeparator:";"`
+
+	// ExecTimeout is the time limit for a single command run
+	ExecTimeout time.Duration `env:"EXEC_TIMEOUT"`
+
+	// LogRules are rules for collecting metrics from log files, they are set in the configuration file only
+	LogRules []LogRule `env:"-"`
+
+	// LogStateFile is a path to the file where offsets of log files are saved
+	LogStateFile string `env:"LOG_STATE_FILE"`
+
+	// Transport is http, grpc or auto. It is used if no outputs are configured
+	Transport string `env:"TRANSPORT"`
+
+	// MetricsAddr is the address of the local endpoint exposing self-metrics of the agent,
+	// the endpoint is disabled if it is empty
+	MetricsAddr string `env:"METRICS_ADDRESS"`
+
+	// TLS enables TLS for connections to the server. It is enabled
+	// if the CA bundle or the client certificate is set as well
+	TLS bool `env:"TLS"`
+
+	// TLSCA is a path to the bundle of CAs the server certificate is verified with,
+	// system roots are used if it is empty
+	TLSCA string `env:"TLS_CA"`
+
+	// TLSCert is a path to the client certificate presented to the server
+	TLSCert string `env:"TLS_CERT"`
+
+	// TLSKey is a path to the private key of the client certificate
+	TLSKey string `env:"TLS_KEY"`
+
+	// TLSServerName is the name the server certificate is verified for,
+	// the host of the server address is used if it is empty
+	TLSServerName string `env:"TLS_SERVER_NAME"`
+
+	// Outputs are destinations for metrics, they are set in the configuration file only.
+	// If there are no outputs, metrics are reported with Transport to Addr or GRPCAddr
+	Outputs []Output `env:"-"`
 }
 
 // MustLoadConfig loads configuration from environment variables
 // and command-line flags. If there is an error, it panics.
 func MustLoadConfig() Config {
+	cfg, err := Load()
+	if err != nil {
+		panic(err)
+	}
+
+	return cfg
+}
+
+// Load loads configuration from the configuration file, environment variables
+// and command-line flags. If the configuration is invalid, it is returned
+// along with the error listing all problems.
+func Load() (Config, error) {
 	var mode modeEnum = defaultMode
 	pflag.VarP(&mode, flagModeName, "m", "Mode of operation, possible values: json (default), batch, query")
 
+	var transport transportEnum = defaultTransport
+	pflag.VarP(&transport, flagTransportName, "t", "Transport, possible values: http (default), grpc, auto")
+
 	pflag.StringP(flagAddressName, "a", defaultAddress, "Host address of the server")
 	pflag.StringP(flagSignKeyName, "k", "", "Authentication key")
+	pflag.String(flagSignKeyIDName, "", "ID of the authentication key")
+	pflag.String(flagCryptoKeyIDName, "", "ID of the public key")
+	pflag.Bool(flagSignLegacyName, false, "Sign requests without a timestamp and a nonce for old servers")
 	pflag.UintP(flagRateLimitName, "l", defaultRateLimit, "Rate limit for http requests")
 	pflag.StringP(flagCryptoKeyName, "y", "", "Path to a file with public key")
-	pflag.UintP(flagReportIntervalName, "r", defaultReportInt, "Report interval to server")
-	pflag.UintP(flagPollIntervalName, "p", defaultPollInt, "Poll interval for metrics")
+	duration.FlagP(flagReportIntervalName, "r", defaultReportInt,
+		"Report interval to server, e.g. 10s or 500ms, plain numbers are seconds")
+	duration.FlagP(flagPollIntervalName, "p", defaultPollInt,
+		"Poll interval for metrics, e.g. 2s or 500ms, plain numbers are seconds")
 	pflag.StringP(flagConfigName, "c", "", "Path to the configuration file")
 	pflag.StringP(flagGRPCAddrName, "g", defaultGRPCAddr, "Address of the gRPC server")
+	pflag.StringSlice(flagScrapeTargetsName, nil, "URLs of Prometheus metrics endpoints to scrape")
+	pflag.StringArray(flagExecCommandsName, nil, "Shell commands to collect metrics from")
+	duration.FlagP(flagExecTimeoutName, "", defaultExecTimeout, "Timeout for a command run")
+	pflag.String(flagLogStateFileName, "", "Path to the file with offsets of log files")
+	pflag.String(flagMetricsAddrName, "", "Address of the endpoint exposing self-metrics")
+	pflag.Bool(flagTLSName, false, "Use TLS for connections to the server")
+	pflag.String(flagTLSCAName, "", "Path to the CA bundle for verification of the server certificate")
+	pflag.String(flagTLSCertName, "", "Path to the client certificate")
+	pflag.String(flagTLSKeyName, "", "Path to the private key of the client certificate")
+	pflag.String(flagTLSServerName, "", "Server name for verification of the server certificate")
+	configcheck.RegisterFlags()
 
 	pflag.Parse()
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
-		panic(err)
+		return Config{}, err
+	}
+
+	return load()
+}
+
+// Reload reads the configuration again. Values of the configuration file
+// and environment variables are read anew, flags keep values given at the start.
+// The configuration is validated, an invalid configuration is returned with an error.
+func Reload() (Config, error) {
+	return load()
+}
+
+// Watch watches the configuration file and sends to the returned channel every time
+// the file is changed. The file isn't read here: the receiver reads it with Reload,
+// so changes of the file are applied on the same path as reloads on SIGHUP.
+// The channel is nil if there is no configuration file. Watching stops when the context is done.
+func Watch(ctx context.Context) (<-chan struct{}, error) {
+	loadMu.Lock()
+	file := configFile()
+	loadMu.Unlock()
+
+	if file == "" {
+		return nil, nil
+	}
+	file = filepath.Clean(file)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
 	}
 
-	configFile, ok := os.LookupEnv("CONFIG")
+	// The directory is watched, as editors replace the file rather than write to it
+	if err = w.Add(filepath.Dir(file)); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	// Changes made before the receiver reloads the configuration are coalesced
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer w.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) != file || !e.Has(fsnotify.Write) && !e.Has(fsnotify.Create) {
+					continue
+				}
+
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+func configFile() string {
+	file, ok := os.LookupEnv("CONFIG")
 	if !ok {
-		configFile = viper.GetString(flagConfigName)
+		file = viper.GetString(flagConfigName)
 	}
 
-	if configFile != "" {
-		viper.SetConfigFile(configFile)
+	return file
+}
+
+var loadMu sync.Mutex
+
+func load() (Config, error) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	if file := configFile(); file != "" {
+		viper.SetConfigFile(file)
 		if err := viper.ReadInConfig(); err != nil {
-			panic(err)
+			return Config{}, err
 		}
 	}
 
+	// Mode and transport are checked by Validate, so invalid values can be reported
+	mode := viper.GetString(flagModeName)
+	if mode == "" {
+		mode = defaultMode
+	}
+
+	transport := viper.GetString(flagTransportName)
+	if transport == "" {
+		transport = defaultTransport
+	}
+
 	address := viper.GetString(flagAddressName)
 	key := viper.GetString(flagSignKeyName)
+	signLegacy := viper.GetBool(flagSignLegacyName)
+	signKeyID := viper.GetString(flagSignKeyIDName)
+	cryptoKeyID := viper.GetString(flagCryptoKeyIDName)
 	cryptoKey := viper.GetString(flagCryptoKeyName)
-	reportInt := viper.GetUint(flagReportIntervalName)
-	pollInt := viper.GetUint(flagPollIntervalName)
+	// Durations are parsed before returning, so all invalid values are reported at once
+	var parsed configcheck.Validator
+	reportInt, err := duration.Get(flagReportIntervalName)
+	parsed.Check(flagReportIntervalName, err)
+	pollInt, err := duration.Get(flagPollIntervalName)
+	parsed.Check(flagPollIntervalName, err)
 	rate := viper.GetUint(flagRateLimitName)
 	grpcAddr := viper.GetString(flagGRPCAddrName)
+	scrapeTargets := viper.GetStringSlice(flagScrapeTargetsName)
+	if len(scrapeTargets) == 0 {
+		scrapeTargets = nil
+	}
+	execCommands := viper.GetStringSlice(flagExecCommandsName)
+	if len(execCommands) == 0 {
+		execCommands = nil
+	}
+	execTimeout, err := duration.Get(flagExecTimeoutName)
+	parsed.Check(flagExecTimeoutName, err)
+	if err := parsed.Err(); err != nil {
+		return Config{}, err
+	}
+	logStateFile := viper.GetString(flagLogStateFileName)
+	metricsAddr := viper.GetString(flagMetricsAddrName)
+	useTLS := viper.GetBool(flagTLSName)
+	tlsCA := viper.GetString(flagTLSCAName)
+	tlsCert := viper.GetString(flagTLSCertName)
+	tlsKey := viper.GetString(flagTLSKeyName)
+	tlsServerName := viper.GetString(flagTLSServerName)
+
+	var logRules []LogRule
+	if err := viper.UnmarshalKey(keyLogRules, &logRules); err != nil {
+		return Config{}, err
+	}
+
+	var outputs []Output
+	if err := viper.UnmarshalKey(keyOutputs, &outputs); err != nil {
+		return Config{}, err
+	}
 
 	cfg := Config{
-		Addr:      address,
-		Mode:      string(mode),
-		SignKey:   key,
-		CryptoKey: cryptoKey,
-		ReportInt: reportInt,
-		PollInt:   pollInt,
-		RateLim:   int(rate),
-		GRPCAddr:  grpcAddr,
-	}
-	if err := env.Parse(&cfg); err != nil {
-		panic(err)
+		Addr:          address,
+		Mode:          mode,
+		SignKey:       key,
+		SignLegacy:    signLegacy,
+		SignKeyID:     signKeyID,
+		CryptoKeyID:   cryptoKeyID,
+		CryptoKey:     cryptoKey,
+		ReportInt:     reportInt,
+		PollInt:       pollInt,
+		RateLim:       int(rate),
+		GRPCAddr:      grpcAddr,
+		ScrapeTargets: scrapeTargets,
+		ExecCommands:  execCommands,
+		ExecTimeout:   execTimeout,
+		LogRules:      logRules,
+		LogStateFile:  logStateFile,
+		Transport:     transport,
+		MetricsAddr:   metricsAddr,
+		TLS:           useTLS,
+		TLSCA:         tlsCA,
+		TLSCert:       tlsCert,
+		TLSKey:        tlsKey,
+		TLSServerName: tlsServerName,
+		Outputs:       outputs,
+	}
+	if err := env.ParseWithFuncs(&cfg, duration.ParserFuncs); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the configuration and returns all problems found.
+func (cfg Config) Validate() error {
+	var v configcheck.Validator
+
+	var mode modeEnum
+	v.Check(flagModeName, mode.Set(cfg.Mode))
+	var transport transportEnum
+	v.Check(flagTransportName, transport.Set(cfg.Transport))
+
+	v.Check(flagAddressName, configcheck.CheckAddress(cfg.Addr))
+	v.Check(flagGRPCAddrName, configcheck.CheckAddress(cfg.GRPCAddr))
+	if cfg.MetricsAddr != "" {
+		v.Check(flagMetricsAddrName, configcheck.CheckAddress(cfg.MetricsAddr))
+	}
+
+	if cfg.PollInt == 0 {
+		v.Check(flagPollIntervalName, errors.New("must be positive"))
+	}
+	if cfg.ReportInt == 0 {
+		v.Check(flagReportIntervalName, errors.New("must be positive"))
+	}
+	if cfg.RateLim <= 0 {
+		v.Check(flagRateLimitName, errors.New("must be positive"))
+	}
+	if cfg.CryptoKey != "" {
+		v.Check(flagCryptoKeyName, configcheck.CheckPublicKey(cfg.CryptoKey))
+	}
+
+	v.Check(flagTLSCertName, tlsutil.CheckKeyPair(cfg.TLSCert, cfg.TLSKey))
+	if cfg.TLSCA != "" {
+		_, err := tlsutil.LoadCertPool(cfg.TLSCA)
+		v.Check(flagTLSCAName, err)
+	}
+
+	for i, t := range cfg.ScrapeTargets {
+		v.Check(fmt.Sprintf("%s[%d]", flagScrapeTargetsName, i), checkURL(t))
+	}
+	if len(cfg.ExecCommands) > 0 && cfg.ExecTimeout == 0 {
+		v.Check(flagExecTimeoutName, errors.New("must be positive"))
+	}
+
+	for i, r := range cfg.LogRules {
+		v.Check(fmt.Sprintf("%s[%d]", keyLogRules, i), r.validate())
+	}
+
+	for i, o := range cfg.Outputs {
+		v.Check(fmt.Sprintf("%s[%d]", keyOutputs, i), o.validate())
+	}
+
+	return v.Err()
+}
+
+func (r LogRule) validate() error {
+	var errs []error
+
+	if r.Name == "" {
+		errs = append(errs, errors.New("name is empty"))
+	}
+	if r.File == "" {
+		errs = append(errs, errors.New("file is empty"))
+	}
+
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	switch r.Type {
+	case "counter":
+	case "gauge":
+		if re != nil && re.NumSubexp() == 0 {
+			errs = append(errs, errors.New("gauge pattern must have a capture group"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid metric type: %s", r.Type))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (o Output) validate() error {
+	var errs []error
+
+	var t transportEnum
+	if err := t.Set(o.Transport); err != nil {
+		errs = append(errs, err)
+	}
+
+	if o.Mode != "" {
+		var m modeEnum
+		if err := m.Set(o.Mode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := configcheck.CheckAddress(o.Addr); err != nil {
+		errs = append(errs, err)
+	}
+	if o.Transport == transportAuto {
+		if err := configcheck.CheckAddress(o.FallbackAddr); err != nil {
+			errs = append(errs, fmt.Errorf("fallback address: %w", err))
+		}
+	}
+
+	if o.CryptoKey != "" {
+		if err := configcheck.CheckPublicKey(o.CryptoKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if o.TLSCert != "" || o.TLSKey != "" {
+		if err := tlsutil.CheckKeyPair(o.TLSCert, o.TLSKey); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.TLSCA != "" {
+		if _, err := tlsutil.LoadCertPool(o.TLSCA); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, p := range append(o.Include, o.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("pattern %q: %w", p, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL: %s", s)
+	}
+
+	return nil
+}
+
+// Settings returns the settings of the configuration for printing.
+func (cfg Config) Settings() []configcheck.Setting {
+	// Sign keys of outputs are masked as well
+	var outputs []Output
+	for _, o := range cfg.Outputs {
+		if o.SignKey != "" {
+			o.SignKey = configcheck.SecretMask
+		}
+		outputs = append(outputs, o)
+	}
+
+	return []configcheck.Setting{
+		{Key: flagAddressName, Env: "ADDRESS", Value: cfg.Addr},
+		{Key: flagGRPCAddrName, Env: "GRPC_ADDRESS", Value: cfg.GRPCAddr},
+		{Key: flagTransportName, Env: "TRANSPORT", Value: cfg.Transport},
+		{Key: flagModeName, Value: cfg.Mode},
+		{Key: flagSignKeyName, Env: "KEY", Value: cfg.SignKey, Secret: true},
+		{Key: flagSignLegacyName, Env: "AUTH_LEGACY", Value: cfg.SignLegacy},
+		{Key: flagSignKeyIDName, Env: "KEY_ID", Value: cfg.SignKeyID},
+		{Key: flagCryptoKeyIDName, Env: "CRYPTO_KEY_ID", Value: cfg.CryptoKeyID},
+		{Key: flagCryptoKeyName, Env: "CRYPTO_KEY", Value: cfg.CryptoKey},
+		{Key: flagReportIntervalName, Env: "REPORT_INTERVAL", Value: cfg.ReportInt.String()},
+		{Key: flagPollIntervalName, Env: "POLL_INTERVAL", Value: cfg.PollInt.String()},
+		{Key: flagRateLimitName, Env: "RATE_LIMIT", Value: cfg.RateLim},
+		{Key: flagScrapeTargetsName, Env: "SCRAPE_TARGETS", Value: cfg.ScrapeTargets},
+		{Key: flagExecCommandsName, Env: "EXEC_COMMANDS", Value: cfg.ExecCommands},
+		{Key: flagExecTimeoutName, Env: "EXEC_TIMEOUT", Value: cfg.ExecTimeout.String()},
+		{Key: keyLogRules, Value: cfg.LogRules},
+		{Key: flagLogStateFileName, Env: "LOG_STATE_FILE", Value: cfg.LogStateFile},
+		{Key: flagMetricsAddrName, Env: "METRICS_ADDRESS", Value: cfg.MetricsAddr},
+		{Key: flagTLSName, Env: "TLS", Value: cfg.TLS},
+		{Key: flagTLSCAName, Env: "TLS_CA", Value: cfg.TLSCA},
+		{Key: flagTLSCertName, Env: "TLS_CERT", Value: cfg.TLSCert},
+		{Key: flagTLSKeyName, Env: "TLS_KEY", Value: cfg.TLSKey},
+		{Key: flagTLSServerName, Env: "TLS_SERVER_NAME", Value: cfg.TLSServerName},
+		{Key: keyOutputs, Value: outputs},
+	}
+}
+
+// EffectiveOutputs returns the outputs the agent reports metrics to.
+// Empty mode and rate limit of outputs are inherited from the agent configuration.
+// If no outputs are configured, the single output with the agent transport is returned.
+func (cfg Config) EffectiveOutputs() []Output {
+	if len(cfg.Outputs) == 0 {
+		o := Output{
+			Name:      transportHTTP,
+			Transport: transportHTTP,
+			Addr:      cfg.Addr,
+			Mode:      cfg.Mode,
+			SignKey:   cfg.SignKey,
+			CryptoKey: cfg.CryptoKey,
+			RateLim:   cfg.RateLim,
+		}
+		o.SignLegacy = cfg.SignLegacy
+		o.SignKeyID, o.CryptoKeyID = cfg.SignKeyID, cfg.CryptoKeyID
+		o.inheritTLS(cfg)
+
+		switch cfg.Transport {
+		case transportGRPC:
+			o.Name, o.Transport, o.Addr = transportGRPC, transportGRPC, cfg.GRPCAddr
+		case transportAuto:
+			o.Name, o.Transport, o.Addr, o.FallbackAddr = transportAuto, transportAuto, cfg.GRPCAddr, cfg.Addr
+		}
+
+		return []Output{o}
+	}
+
+	outputs := make([]Output, len(cfg.Outputs))
+	for i, o := range cfg.Outputs {
+		if o.Name == "" {
+			o.Name = fmt.Sprintf("%s-%d", o.Transport, i)
+		}
+		if o.Mode == "" {
+			o.Mode = cfg.Mode
+		}
+		if o.RateLim == 0 {
+			o.RateLim = cfg.RateLim
+		}
+		o.SignLegacy = o.SignLegacy || cfg.SignLegacy
+		o.inheritTLS(cfg)
+
+		outputs[i] = o
+	}
+
+	return outputs
+}
+
+// inheritTLS sets empty TLS settings of the output to the settings of the agent.
+func (o *Output) inheritTLS(cfg Config) {
+	o.TLS = o.TLS || cfg.TLS
+	if o.TLSCA == "" {
+		o.TLSCA = cfg.TLSCA
+	}
+	if o.TLSCert == "" && o.TLSKey == "" {
+		o.TLSCert, o.TLSKey = cfg.TLSCert, cfg.TLSKey
+	}
+	if o.TLSServerName == "" {
+		o.TLSServerName = cfg.TLSServerName
+	}
+}
+
+// ForOutput returns a copy of the configuration with connection settings
+// of the agent replaced by the settings of the output.
+// Transport of the returned configuration is the transport of the output.
+func (cfg Config) ForOutput(o Output) Config {
+	cfg.Transport = o.Transport
+	cfg.Mode = o.Mode
+	cfg.SignKey = o.SignKey
+	cfg.SignLegacy = o.SignLegacy
+	cfg.SignKeyID = o.SignKeyID
+	cfg.CryptoKeyID = o.CryptoKeyID
+	cfg.CryptoKey = o.CryptoKey
+	cfg.RateLim = o.RateLim
+	cfg.TLS, cfg.TLSCA, cfg.TLSCert, cfg.TLSKey, cfg.TLSServerName = o.TLS, o.TLSCA, o.TLSCert, o.TLSKey, o.TLSServerName
+	switch o.Transport {
+	case transportGRPC:
+		cfg.GRPCAddr = o.Addr
+	case transportAuto:
+		cfg.GRPCAddr = o.Addr
+		cfg.Addr = o.FallbackAddr
+	default:
+		cfg.Addr = o.Addr
 	}
 
 	return cfg
 }
+
+// TLSConfig returns the TLS configuration of connections to the server
+// or nil if TLS is not enabled.
+func (cfg Config) TLSConfig() (*tls.Config, error) {
+	if !cfg.TLS && cfg.TLSCA == "" && cfg.TLSCert == "" {
+		return nil, nil
+	}
+
+	return tlsutil.ClientConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey, cfg.TLSServerName)
+}
@@ -1,8 +1,17 @@
 package agentconf
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMustLoadConfig(t *testing.T) {
@@ -13,14 +22,16 @@ func TestMustLoadConfig(t *testing.T) {
 		{
 			name: "Test MustLoadConfig",
 			want: Config{
-				Addr:      defaultAddress,
-				Mode:      defaultMode,
-				SignKey:   "",
-				CryptoKey: "",
-				ReportInt: defaultReportInt,
-				PollInt:   defaultPollInt,
-				RateLim:   int(defaultRateLimit),
-				GRPCAddr:  defaultGRPCAddr,
+				Addr:        defaultAddress,
+				Mode:        defaultMode,
+				SignKey:     "",
+				CryptoKey:   "",
+				ReportInt:   defaultReportInt,
+				PollInt:     defaultPollInt,
+				RateLim:     int(defaultRateLimit),
+				GRPCAddr:    defaultGRPCAddr,
+				ExecTimeout: defaultExecTimeout,
+				Transport:   defaultTransport,
 			},
 		},
 	}
@@ -106,3 +117,353 @@ func Test_modeEnum_Type(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_EffectiveOutputs(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want []Output
+	}{
+		{
+			name: "http transport",
+			cfg: Config{
+				Addr:      "localhost:8080",
+				GRPCAddr:  "localhost:8081",
+				Mode:      "batch",
+				SignKey:   "key",
+				RateLim:   5,
+				Transport: "http",
+			},
+			want: []Output{
+				{Name: "http", Transport: "http", Addr: "localhost:8080", Mode: "batch", SignKey: "key", RateLim: 5},
+			},
+		},
+		{
+			name: "grpc transport",
+			cfg: Config{
+				Addr:      "localhost:8080",
+				GRPCAddr:  "localhost:8081",
+				Mode:      "batch",
+				RateLim:   5,
+				Transport: "grpc",
+			},
+			want: []Output{
+				{Name: "grpc", Transport: "grpc", Addr: "localhost:8081", Mode: "batch", RateLim: 5},
+			},
+		},
+		{
+			name: "auto transport",
+			cfg: Config{
+				Addr:      "localhost:8080",
+				GRPCAddr:  "localhost:8081",
+				Mode:      "json",
+				RateLim:   5,
+				Transport: "auto",
+			},
+			want: []Output{
+				{Name: "auto", Transport: "auto", Addr: "localhost:8081", FallbackAddr: "localhost:8080",
+					Mode: "json", RateLim: 5},
+			},
+		},
+		{
+			name: "configured outputs",
+			cfg: Config{
+				Mode:    "json",
+				RateLim: 5,
+				Outputs: []Output{
+					{Name: "prod", Transport: "http", Addr: "prod:8080", Mode: "batch", RateLim: 20},
+					{Transport: "grpc", Addr: "staging:8081", Include: []string{"Heap*"}},
+				},
+			},
+			want: []Output{
+				{Name: "prod", Transport: "http", Addr: "prod:8080", Mode: "batch", RateLim: 20},
+				{Name: "grpc-1", Transport: "grpc", Addr: "staging:8081", Mode: "json", RateLim: 5,
+					Include: []string{"Heap*"}},
+			},
+		},
+		{
+			name: "tls settings are inherited",
+			cfg: Config{
+				Mode:          "json",
+				RateLim:       5,
+				TLS:           true,
+				TLSCA:         "ca.pem",
+				TLSCert:       "agent.pem",
+				TLSKey:        "agent-key.pem",
+				TLSServerName: "metrics",
+				Outputs: []Output{
+					{Name: "prod", Transport: "grpc", Addr: "prod:8081"},
+					{Name: "staging", Transport: "http", Addr: "staging:8080", TLSCA: "staging-ca.pem",
+						TLSCert: "staging.pem", TLSKey: "staging-key.pem"},
+				},
+			},
+			want: []Output{
+				{Name: "prod", Transport: "grpc", Addr: "prod:8081", Mode: "json", RateLim: 5, TLS: true,
+					TLSCA: "ca.pem", TLSCert: "agent.pem", TLSKey: "agent-key.pem", TLSServerName: "metrics"},
+				{Name: "staging", Transport: "http", Addr: "staging:8080", Mode: "json", RateLim: 5, TLS: true,
+					TLSCA: "staging-ca.pem", TLSCert: "staging.pem", TLSKey: "staging-key.pem", TLSServerName: "metrics"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cfg.EffectiveOutputs())
+		})
+	}
+}
+
+func TestConfig_ForOutput(t *testing.T) {
+	cfg := Config{
+		Addr:      "localhost:8080",
+		GRPCAddr:  "localhost:8081",
+		Mode:      "json",
+		ReportInt: 10,
+	}
+
+	got := cfg.ForOutput(Output{Transport: "grpc", Addr: "staging:8081", Mode: "batch", SignKey: "key", RateLim: 3})
+	assert.Equal(t, Config{
+		Addr:      "localhost:8080",
+		GRPCAddr:  "staging:8081",
+		Mode:      "batch",
+		SignKey:   "key",
+		ReportInt: 10,
+		RateLim:   3,
+		Transport: "grpc",
+	}, got)
+}
+
+func Test_transportEnum_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{
+			name:    "Test transportEnum Set, valid",
+			s:       "auto",
+			wantErr: false,
+		},
+		{
+			name:    "Test transportEnum Set, invalid",
+			s:       "udp",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tr transportEnum
+			if err := tr.Set(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Config
+		wantErr bool
+
+		// wantKeys are settings which must be named in the error
+		wantKeys []string
+	}{
+		{
+			name: "valid config",
+			content: `{"poll_interval": 1, "report_interval": 5, "rate_limit": 3, "mode": "batch",
+				"outputs": [{"transport": "grpc", "address": "localhost:9090"}]}`,
+			want: Config{
+				PollInt:   time.Second,
+				ReportInt: 5 * time.Second,
+				RateLim:   3,
+				Mode:      "batch",
+				Outputs:   []Output{{Transport: "grpc", Addr: "localhost:9090"}},
+			},
+		},
+		{
+			name:    "duration strings",
+			content: `{"poll_interval": "500ms", "report_interval": "1m30s", "rate_limit": 3}`,
+			want: Config{
+				PollInt:   500 * time.Millisecond,
+				ReportInt: 90 * time.Second,
+				RateLim:   3,
+				Mode:      defaultMode,
+			},
+		},
+		{
+			name:    "invalid duration",
+			content: `{"poll_interval": "fast", "report_interval": 5, "rate_limit": 3}`,
+			wantErr: true,
+		},
+		{
+			name:     "invalid durations are reported together",
+			content:  `{"poll_interval": "fast", "report_interval": "rarely", "rate_limit": 3}`,
+			wantErr:  true,
+			wantKeys: []string{flagPollIntervalName, flagReportIntervalName},
+		},
+		{
+			name:    "invalid mode",
+			content: `{"poll_interval": 1, "report_interval": 5, "rate_limit": 3, "mode": "xml"}`,
+			wantErr: true,
+		},
+		{
+			name:    "zero report interval",
+			content: `{"poll_interval": 1, "report_interval": 0, "rate_limit": 3}`,
+			wantErr: true,
+		},
+		{
+			name: "invalid output transport",
+			content: `{"poll_interval": 1, "report_interval": 5, "rate_limit": 3,
+				"outputs": [{"transport": "udp", "address": "localhost:9090"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(file, []byte(tt.content), 0o600))
+			t.Setenv("CONFIG", file)
+
+			got, err := Reload()
+			if tt.wantErr {
+				require.Error(t, err)
+				for _, key := range tt.wantKeys {
+					assert.Contains(t, err.Error(), key+": ")
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.PollInt, got.PollInt)
+			assert.Equal(t, tt.want.ReportInt, got.ReportInt)
+			assert.Equal(t, tt.want.RateLim, got.RateLim)
+			assert.Equal(t, tt.want.Mode, got.Mode)
+			assert.Equal(t, tt.want.Outputs, got.Outputs)
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	write := func(rateLimit int) {
+		content := fmt.Sprintf(`{"poll_interval": 1, "report_interval": 5, "rate_limit": %d}`, rateLimit)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	}
+	write(1)
+	t.Setenv("CONFIG", file)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := Watch(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, changes)
+
+	// Reloads on file changes and on SIGHUP are run concurrently as the agent may do,
+	// go test -race reports unsynchronized reads of the configuration
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			_, _ = Reload()
+		}
+	}()
+
+	write(7)
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change of the file is not reported")
+	}
+	wg.Wait()
+
+	got, err := Reload()
+	require.NoError(t, err)
+	assert.Equal(t, 7, got.RateLim)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		write(8)
+		select {
+		case <-changes:
+			return false
+		case <-time.After(50 * time.Millisecond):
+			return true
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := Config{
+		Addr:      "localhost:8080",
+		GRPCAddr:  "localhost:8081",
+		Mode:      "json",
+		Transport: "http",
+		PollInt:   2 * time.Second,
+		ReportInt: 10 * time.Second,
+		RateLim:   10,
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(*Config)
+		wantKeys []string
+	}{
+		{
+			name:   "valid",
+			modify: func(*Config) {},
+		},
+		{
+			name: "all problems are reported",
+			modify: func(c *Config) {
+				c.PollInt = 0
+				c.RateLim = 0
+				c.Mode = "xml"
+				c.CryptoKey = "/nonexistent/key.pem"
+			},
+			wantKeys: []string{flagPollIntervalName, flagRateLimitName, flagModeName, flagCryptoKeyName},
+		},
+		{
+			name: "invalid scrape target and exec timeout",
+			modify: func(c *Config) {
+				c.ScrapeTargets = []string{"localhost:9100/metrics"}
+				c.ExecCommands = []string{"echo a 1"}
+				c.ExecTimeout = 0
+			},
+			wantKeys: []string{"scrape_targets[0]", flagExecTimeoutName},
+		},
+		{
+			name: "invalid log rule",
+			modify: func(c *Config) {
+				c.LogRules = []LogRule{{Name: "errors", File: "/var/log/app.log", Pattern: "(", Type: "counter"}}
+			},
+			wantKeys: []string{"log_rules[0]"},
+		},
+		{
+			name: "auto output without fallback address",
+			modify: func(c *Config) {
+				c.Outputs = []Output{{Transport: "auto", Addr: "localhost:8081"}}
+			},
+			wantKeys: []string{"outputs[0]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantKeys) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			for _, key := range tt.wantKeys {
+				assert.Contains(t, err.Error(), key+": ")
+			}
+		})
+	}
+}
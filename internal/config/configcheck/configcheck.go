@@ -0,0 +1,229 @@
+// Package configcheck provides validation and printing of configurations
+// shared by the server and the agent.
+package configcheck
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Sources of setting values in the order of precedence.
+const (
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// SecretMask replaces values of secret settings in the output.
+const SecretMask = "******"
+
+const flagDumpConfig = "dump-config"
+
+type (
+	// FieldError is a problem with a single setting.
+	FieldError struct {
+		Key string
+		Err error
+	}
+
+	// Validator collects problems with settings, so all of them are reported at once.
+	Validator struct {
+		errs []error
+	}
+
+	// Setting is a setting of a configuration with its effective value.
+	Setting struct {
+		// Key is the name of the flag and the key in the configuration file
+		Key string
+
+		// Env is the name of the environment variable, empty if there is none
+		Env string
+
+		// Value is the effective value
+		Value any
+
+		// Secret settings are printed masked
+		Secret bool
+	}
+)
+
+func (e *FieldError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Check adds the error of the setting with the key, nil errors are ignored.
+func (v *Validator) Check(key string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, &FieldError{Key: key, Err: err})
+	}
+}
+
+// Err returns all collected problems joined or nil if there are none.
+func (v *Validator) Err() error {
+	return errors.Join(v.errs...)
+}
+
+// RegisterFlags registers flags of the configuration check mode.
+func RegisterFlags() {
+	pflag.Bool(flagDumpConfig, false, "Print the effective configuration with its sources and exit")
+}
+
+// Requested returns true if the configuration check mode is requested
+// either with the "config check" command or with the --dump-config flag.
+// It must be called after flags are parsed.
+func Requested() bool {
+	if dump, err := pflag.CommandLine.GetBool(flagDumpConfig); err == nil && dump {
+		return true
+	}
+
+	args := pflag.Args()
+	return len(args) == 2 && args[0] == "config" && args[1] == "check"
+}
+
+// Source returns where the effective value of the setting comes from.
+// Environment variables override flags, flags override the configuration file.
+func Source(key, env string) string {
+	if env != "" {
+		if _, ok := os.LookupEnv(env); ok {
+			return SourceEnv
+		}
+	}
+
+	if f := pflag.Lookup(key); f != nil && f.Changed {
+		return SourceFlag
+	}
+
+	if viper.InConfig(key) {
+		return SourceFile
+	}
+
+	return SourceDefault
+}
+
+// Dump prints settings with their sources and values. Values are printed in JSON.
+func Dump(w io.Writer, settings []Setting) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "SETTING\tSOURCE\tVALUE"); err != nil {
+		return err
+	}
+
+	for _, s := range settings {
+		v := s.Value
+		if s.Secret && v != "" {
+			v = SecretMask
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		if _, err = fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, Source(s.Key, s.Env), b); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+// Report prints settings and problems with the configuration.
+// It returns true if the configuration is valid.
+func Report(w io.Writer, settings []Setting, err error) bool {
+	if settings != nil {
+		if dumpErr := Dump(w, settings); dumpErr != nil {
+			err = errors.Join(err, dumpErr)
+		}
+	}
+
+	if err == nil {
+		fmt.Fprintln(w, "\nconfiguration is valid")
+		return true
+	}
+
+	fmt.Fprintln(w, "\nconfiguration is invalid:")
+	for _, e := range unjoin(err) {
+		fmt.Fprintf(w, "  - %v\n", e)
+	}
+
+	return false
+}
+
+// unjoin flattens joined errors, so every problem is printed on its own line.
+func unjoin(err error) []error {
+	if fe, ok := err.(*FieldError); ok {
+		errs := unjoin(fe.Err)
+		for i, e := range errs {
+			errs[i] = &FieldError{Key: fe.Key, Err: e}
+		}
+
+		return errs
+	}
+
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, e := range j.Unwrap() {
+			errs = append(errs, unjoin(e)...)
+		}
+
+		return errs
+	}
+
+	return []error{err}
+}
+
+// CheckAddress checks that the address is in the host:port form.
+func CheckAddress(addr string) error {
+	_, _, err := net.SplitHostPort(addr)
+	return err
+}
+
+// CheckPrivateKey checks that the file contains an RSA private key in PEM format.
+func CheckPrivateKey(path string) error {
+	block, err := readPEM(path, "RSA PRIVATE KEY")
+	if err != nil {
+		return err
+	}
+
+	_, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	return err
+}
+
+// CheckPublicKey checks that the file contains an RSA public key in PEM format.
+func CheckPublicKey(path string) error {
+	block, err := readPEM(path, "RSA PUBLIC KEY")
+	if err != nil {
+		return err
+	}
+
+	_, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	return err
+}
+
+func readPEM(path, typ string) (*pem.Block, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != typ {
+		return nil, fmt.Errorf("no PEM block of type %q", typ)
+	}
+
+	return block, nil
+}
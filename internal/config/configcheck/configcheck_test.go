@@ -0,0 +1,128 @@
+package configcheck
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidator(t *testing.T) {
+	var v Validator
+	assert.NoError(t, v.Err())
+
+	v.Check("address", nil)
+	v.Check("poll_interval", errors.New("must be positive"))
+	v.Check("rate_limit", errors.New("must be positive"))
+
+	err := v.Err()
+	require.Error(t, err)
+	assert.Equal(t, "poll_interval: must be positive\nrate_limit: must be positive", err.Error())
+
+	var fe *FieldError
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, "poll_interval", fe.Key)
+}
+
+func TestCheckAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{addr: "localhost:8080"},
+		{addr: ":8080"},
+		{addr: "localhost", wantErr: true},
+		{addr: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := CheckAddress(tt.addr)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	private := writePEM(t, dir, "private.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	public := writePEM(t, dir, "public.pem", "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey))
+	broken := writePEM(t, dir, "broken.pem", "RSA PRIVATE KEY", []byte("broken"))
+
+	assert.NoError(t, CheckPrivateKey(private))
+	assert.NoError(t, CheckPublicKey(public))
+
+	assert.Error(t, CheckPrivateKey(public))
+	assert.Error(t, CheckPublicKey(private))
+	assert.Error(t, CheckPrivateKey(broken))
+	assert.Error(t, CheckPrivateKey(filepath.Join(dir, "missing.pem")))
+}
+
+func TestReport(t *testing.T) {
+	settings := []Setting{
+		{Key: "address", Value: "localhost:8080"},
+		{Key: "auth_key", Value: "secret", Secret: true},
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		want      bool
+		wantLines []string
+	}{
+		{
+			name:      "valid",
+			want:      true,
+			wantLines: []string{`"localhost:8080"`, `"` + SecretMask + `"`, "configuration is valid"},
+		},
+		{
+			name: "invalid",
+			err: errors.Join(
+				&FieldError{Key: "address", Err: errors.New("missing port")},
+				&FieldError{Key: "outputs[0]", Err: errors.Join(errors.New("a"), errors.New("b"))},
+			),
+			wantLines: []string{
+				"configuration is invalid:",
+				"  - address: missing port",
+				"  - outputs[0]: a",
+				"  - outputs[0]: b",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.Equal(t, tt.want, Report(&buf, settings, tt.err))
+
+			out := buf.String()
+			assert.NotContains(t, out, "secret")
+			for _, l := range tt.wantLines {
+				assert.Contains(t, out, l)
+			}
+		})
+	}
+}
+
+func writePEM(t *testing.T, dir, name, typ string, b []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0o600))
+
+	return path
+}
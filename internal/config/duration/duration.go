@@ -0,0 +1,84 @@
+// Package duration provides parsing of intervals given in configuration.
+// Intervals are Go duration strings like 500ms or 1m30s. Plain integers
+// are accepted for backward compatibility and mean seconds.
+package duration
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Parse parses the interval. A plain integer is a number of seconds.
+func Parse(s string) (time.Duration, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration: %s", s)
+	}
+
+	return d, nil
+}
+
+// Value is a flag value holding an interval.
+type Value time.Duration
+
+// Set parses the interval.
+func (v *Value) Set(s string) error {
+	d, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*v = Value(d)
+	return nil
+}
+
+func (v *Value) String() string {
+	return time.Duration(*v).String()
+}
+
+func (v *Value) Type() string {
+	return "duration"
+}
+
+// FlagP defines an interval flag with the default value, like pflag.DurationP,
+// but accepts plain integers as seconds.
+func FlagP(name, shorthand string, value time.Duration, usage string) {
+	v := Value(value)
+	pflag.VarP(&v, name, shorthand, usage)
+}
+
+// Get returns the interval set for the key in viper. Values of the configuration
+// file may be numbers of seconds or duration strings.
+func Get(key string) (time.Duration, error) {
+	v := viper.Get(key)
+	switch v := v.(type) {
+	case nil:
+		return 0, nil
+	case time.Duration:
+		return v, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	default:
+		return Parse(fmt.Sprint(v))
+	}
+}
+
+// ParserFuncs are parsers for env.ParseWithFuncs parsing intervals of environment variables.
+var ParserFuncs = map[reflect.Type]env.ParserFunc{
+	reflect.TypeOf(time.Duration(0)): func(s string) (interface{}, error) {
+		return Parse(s)
+	},
+}
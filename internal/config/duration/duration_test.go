@@ -0,0 +1,48 @@
+package duration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "10", want: 10 * time.Second},
+		{s: "0", want: 0},
+		{s: "500ms", want: 500 * time.Millisecond},
+		{s: "1m30s", want: 90 * time.Second},
+		{s: "-1s", wantErr: true},
+		{s: "fast", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := Parse(tt.s)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValue_Set(t *testing.T) {
+	var v Value
+	assert.NoError(t, v.Set("2"))
+	assert.Equal(t, "2s", v.String())
+
+	assert.NoError(t, v.Set("250ms"))
+	assert.Equal(t, "250ms", v.String())
+
+	assert.Error(t, v.Set("1 hour"))
+	assert.Equal(t, "250ms", v.String())
+}
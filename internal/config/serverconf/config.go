@@ -1,18 +1,39 @@
 package serverconf
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/netip"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v6"
+	"github.com/jackc/pgx/v5"
+	"github.com/leonf08/metrics-yp.git/internal/config/configcheck"
+	"github.com/leonf08/metrics-yp.git/internal/config/duration"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/tlsutil"
+	"github.com/rs/zerolog"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 const (
 	defaultAddress       = ":8080"
-	defaultStoreInterval = 300
+	defaultStoreInterval = 300 * time.Second
 	defaultRestore       = true
 	defaultGRPCAddr      = ":8081"
+	defaultLogLevel      = "info"
+	defaultSignSkew      = 5 * time.Minute
+	defaultSignStrict    = true
+	defaultDBAutoMigrate = true
+	defaultDBPartition   = "day"
+	defaultDBMaintenance = time.Hour
+	defaultDBRetention   = 7 * 24 * time.Hour
+	defaultRollups       = "1m,1h,1d"
+	defaultRollupInt     = time.Minute
 )
 
 const (
@@ -26,15 +47,66 @@ const (
 	flagConfigName        = "config"
 	flagTrustedSubnet     = "trusted_subnet"
 	flagGRPCAddrName      = "grpc_address"
+	flagMetricsAddrName   = "metrics_address"
+	flagLogLevelName      = "log_level"
+	flagTLSCertName       = "tls_cert"
+	flagTLSKeyName        = "tls_key"
+	flagTLSClientCAName   = "tls_client_ca"
+	flagSignSkewName      = "auth_skew"
+	flagSignLegacyName    = "auth_legacy"
+	flagSignStrictName    = "auth_strict"
+	flagDBMaxConnsName    = "db_max_conns"
+	flagDBMinConnsName    = "db_min_conns"
+	flagDBLifetimeName    = "db_max_conn_lifetime"
+	flagDBIdleTimeName    = "db_max_conn_idle_time"
+	flagDBHealthCheckName = "db_health_check_period"
+	flagDBStmtCacheName   = "db_statement_cache"
+	flagDBAutoMigrate     = "db_auto_migrate"
+	flagDBPartitionName   = "db_partition"
+	flagDBRetentionName   = "db_retention"
+	flagDBMaintenanceName = "db_maintenance_interval"
+	flagRollupsName       = "rollup_resolutions"
+	flagRollupIntName     = "rollup_interval"
+	keySignKeys           = "auth_keys"
+	keyCryptoKeys         = "crypto_keys"
 )
 
+// SigningKey is a named key for verification of signed requests.
+// Agents select the key with the KeyID header.
+type SigningKey struct {
+	// ID of the key, it must not be empty
+	ID string `mapstructure:"id"`
+
+	// Key is the shared secret
+	Key string `mapstructure:"key"`
+
+	// Expires is the time in RFC 3339 format the key is not accepted after,
+	// the key doesn't expire if it is empty
+	Expires string `mapstructure:"expires"`
+}
+
+// DecryptionKey is a named private key for decryption of requests.
+// Agents select the key with the CryptoKeyID header.
+type DecryptionKey struct {
+	// ID of the key, it must not be empty
+	ID string `mapstructure:"id"`
+
+	// File is a path to the file with the private key
+	File string `mapstructure:"file"`
+
+	// Expires is the time in RFC 3339 format the key is not accepted after,
+	// the key doesn't expire if it is empty
+	Expires string `mapstructure:"expires"`
+}
+
 // Config is a struct for server configuration
 type Config struct {
 	// Addr is the address of the server
 	Addr string `env:"ADDRESS"`
 
-	// StoreInt defines the interval for storing metrics if file storage is used
-	StoreInt uint `env:"STORE_INTERVAL"`
+	// StoreInt defines the interval for storing metrics if file storage is used,
+	// metrics are stored on every change if it is zero
+	StoreInt time.Duration `env:"STORE_INTERVAL"`
 
 	// FileStoragePath is the path to file where metrics are stored
 	FileStoragePath string `env:"FILE_STORAGE_PATH"`
@@ -45,38 +117,161 @@ type Config struct {
 	// DatabaseAddr is the address of the database
 	DatabaseAddr string `env:"DATABASE_DSN"`
 
+	// DBMaxConns is the maximum number of connections to the database
+	DBMaxConns int32 `env:"DB_MAX_CONNS"`
+
+	// DBMinConns is the number of connections to the database kept open when the server is idle
+	DBMinConns int32 `env:"DB_MIN_CONNS"`
+
+	// DBMaxConnLifetime is the time after which a connection to the database is replaced
+	DBMaxConnLifetime time.Duration `env:"DB_MAX_CONN_LIFETIME"`
+
+	// DBMaxConnIdleTime is the time after which an idle connection to the database is closed
+	DBMaxConnIdleTime time.Duration `env:"DB_MAX_CONN_IDLE_TIME"`
+
+	// DBHealthCheckPeriod is the period of checks of idle connections to the database
+	DBHealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD"`
+
+	// DBStatementCache is the mode queries are executed in: cache_statement (default),
+	// cache_describe, describe_exec, exec or simple_protocol
+	DBStatementCache string `env:"DB_STATEMENT_CACHE"`
+
+	// DBAutoMigrate defines whether to apply migrations of the database at the server start.
+	// If disabled, migrations are applied with the migrate command
+	DBAutoMigrate bool `env:"DB_AUTO_MIGRATE"`
+
+	// DBPartition is the time range of a partition of the history of metrics: day or week
+	DBPartition string `env:"DB_PARTITION"`
+
+	// DBRetention is the age after which the history of metrics is dropped, a week by default.
+	// Zero keeps it forever
+	DBRetention time.Duration `env:"DB_RETENTION"`
+
+	// DBMaintenanceInterval is the period of creation and dropping of partitions of the history
+	DBMaintenanceInterval time.Duration `env:"DB_MAINTENANCE_INTERVAL"`
+
+	// RollupResolutions are resolutions of rollups of the history with optional retentions,
+	// e.g. 1m:7d,1h,1d. Empty resolutions disable rollups
+	RollupResolutions string `env:"ROLLUP_RESOLUTIONS"`
+
+	// RollupInterval is the period of computation of rollups
+	RollupInterval time.Duration `env:"ROLLUP_INTERVAL"`
+
 	// SignKey used in hash calculation for authentication
 	SignKey string `env:"KEY"`
 
+	// SignSkew is the allowed difference between the timestamp of a signed request
+	// and the time of the server. Requests outside the window are rejected
+	SignSkew time.Duration `env:"AUTH_SKEW"`
+
+	// SignLegacy allows requests signed without a timestamp and a nonce by old agents,
+	// such requests are not protected from replay
+	SignLegacy bool `env:"AUTH_LEGACY"`
+
+	// SignStrict rejects unsigned write requests if a signing key is set
+	SignStrict bool `env:"AUTH_STRICT"`
+
+	// SignKeys are named signing keys accepted along with SignKey which is the default key,
+	// they are set in the configuration file only
+	SignKeys []SigningKey `env:"-"`
+
 	// CryptoKey is a path to a file with private key for decryption
 	CryptoKey string `env:"CRYPTO_KEY"`
 
+	// CryptoKeys are named private keys accepted along with CryptoKey which is the default key,
+	// they are set in the configuration file only
+	CryptoKeys []DecryptionKey `env:"-"`
+
 	// TrustedSubnet is CIDR notation of trusted subnet
 	TrustedSubnet string `env:"TRUSTED_SUBNET"`
 
 	// GRPCAddr is the address of the gRPC server
 	GRPCAddr string `env:"GRPC_ADDRESS"`
+
+	// MetricsAddr is the address of the internal endpoint exposing self-metrics of the server,
+	// the endpoint is disabled if it is empty
+	MetricsAddr string `env:"METRICS_ADDRESS"`
+
+	// LogLevel is the minimal level of log messages
+	LogLevel string `env:"LOG_LEVEL"`
+
+	// TLSCert is a path to the certificate of the HTTP and gRPC servers,
+	// the servers use TLS if it is set. The certificate is reloaded when the file changes
+	TLSCert string `env:"TLS_CERT"`
+
+	// TLSKey is a path to the private key of the certificate
+	TLSKey string `env:"TLS_KEY"`
+
+	// TLSClientCA is a path to the bundle of CAs client certificates are verified with,
+	// clients must present a certificate if it is set
+	TLSClientCA string `env:"TLS_CLIENT_CA"`
 }
 
 // MustLoadConfig loads configuration from environment variables
 // and command-line flags. If there is an error, it panics.
 func MustLoadConfig() Config {
+	cfg, err := Load()
+	if err != nil {
+		panic(err)
+	}
+
+	return cfg
+}
+
+// Load loads configuration from the configuration file, environment variables
+// and command-line flags. If the configuration is invalid, it is returned
+// along with the error listing all problems.
+func Load() (Config, error) {
 	pflag.StringP(flagAddressName, "a", defaultAddress, "Host address of the server")
-	pflag.UintP(flagStoreIntervalName, "i", defaultStoreInterval, "Store interval for the metrics")
+	duration.FlagP(flagStoreIntervalName, "i", defaultStoreInterval,
+		"Store interval for the metrics, e.g. 300s or 5m, plain numbers are seconds")
 	pflag.StringP(flagFileStorageName, "f", "", "Path to file storage")
 	pflag.StringP(flagDatabaseAddrName, "d", "", "Database address")
 	pflag.BoolP(flagRestoreName, "r", defaultRestore, "Restore metrics from file")
+	pflag.Int32(flagDBMaxConnsName, 0, "Maximum number of connections to the database")
+	pflag.Int32(flagDBMinConnsName, 0, "Number of connections to the database kept open")
+	duration.FlagP(flagDBLifetimeName, "", 0, "Maximum lifetime of a connection to the database")
+	duration.FlagP(flagDBIdleTimeName, "", 0, "Maximum idle time of a connection to the database")
+	duration.FlagP(flagDBHealthCheckName, "", 0, "Period of health checks of connections to the database")
+	pflag.String(flagDBStmtCacheName, "", "Statement cache mode: cache_statement, cache_describe, "+
+		"describe_exec, exec, simple_protocol")
+	pflag.Bool(flagDBAutoMigrate, defaultDBAutoMigrate, "Apply migrations of the database at the start")
+	pflag.String(flagDBPartitionName, defaultDBPartition, "Time range of a partition of the history: day, week")
+	duration.FlagP(flagDBRetentionName, "", defaultDBRetention, "Age after which the history is dropped, zero keeps it forever")
+	duration.FlagP(flagDBMaintenanceName, "", defaultDBMaintenance, "Period of maintenance of partitions of the history")
+	pflag.String(flagRollupsName, defaultRollups, "Resolutions of rollups of the history with optional retentions, e.g. 1m:7d,1h,1d")
+	duration.FlagP(flagRollupIntName, "", defaultRollupInt, "Period of computation of rollups of the history")
 	pflag.StringP(flagSignKeyName, "k", "", "Authentication key")
+	duration.FlagP(flagSignSkewName, "", defaultSignSkew, "Allowed clock skew of signed requests")
+	pflag.Bool(flagSignLegacyName, false, "Accept requests signed without a timestamp and a nonce")
+	pflag.Bool(flagSignStrictName, defaultSignStrict, "Reject unsigned write requests if a key is set")
 	pflag.StringP(flagCryptoKeyName, "y", "", "Path to the file with private key")
 	pflag.StringP(flagConfigName, "c", "", "Path to the configuration file")
 	pflag.StringP(flagTrustedSubnet, "t", "", "CIDR notation of trusted subnet")
 	pflag.StringP(flagGRPCAddrName, "g", defaultGRPCAddr, "Address of the gRPC server")
+	pflag.String(flagMetricsAddrName, "", "Address of the endpoint exposing self-metrics")
+	pflag.String(flagLogLevelName, defaultLogLevel, "Log level: debug, info, warn, error")
+	pflag.String(flagTLSCertName, "", "Path to the TLS certificate of the servers")
+	pflag.String(flagTLSKeyName, "", "Path to the private key of the TLS certificate")
+	pflag.String(flagTLSClientCAName, "", "Path to the CA bundle for verification of client certificates")
+	configcheck.RegisterFlags()
 
 	pflag.Parse()
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
-		panic(err)
+		return Config{}, err
 	}
 
+	return load()
+}
+
+// Reload reads the configuration again. Values of the configuration file
+// and environment variables are read anew, flags keep values given at the start.
+// The configuration is validated, an invalid configuration is returned with an error.
+func Reload() (Config, error) {
+	return load()
+}
+
+func load() (Config, error) {
 	configFile, ok := os.LookupEnv("CONFIG")
 	if !ok {
 		configFile = viper.GetString(flagConfigName)
@@ -85,7 +280,7 @@ func MustLoadConfig() Config {
 	if configFile != "" {
 		viper.SetConfigFile(configFile)
 		if err := viper.ReadInConfig(); err != nil {
-			panic(err)
+			return Config{}, err
 		}
 	}
 
@@ -93,29 +288,297 @@ func MustLoadConfig() Config {
 	fileStoragePath := viper.GetString(flagFileStorageName)
 	databaseAddr := viper.GetString(flagDatabaseAddrName)
 	restore := viper.GetBool(flagRestoreName)
-	storeInt := viper.GetUint(flagStoreIntervalName)
+	dbMaxConns := viper.GetInt32(flagDBMaxConnsName)
+	dbMinConns := viper.GetInt32(flagDBMinConnsName)
+	// Durations are parsed before returning, so all invalid values are reported at once
+	var parsed configcheck.Validator
+	dbLifetime, err := duration.Get(flagDBLifetimeName)
+	parsed.Check(flagDBLifetimeName, err)
+	dbIdleTime, err := duration.Get(flagDBIdleTimeName)
+	parsed.Check(flagDBIdleTimeName, err)
+	dbHealthCheck, err := duration.Get(flagDBHealthCheckName)
+	parsed.Check(flagDBHealthCheckName, err)
+	dbStmtCache := viper.GetString(flagDBStmtCacheName)
+	dbAutoMigrate := viper.GetBool(flagDBAutoMigrate)
+	dbPartition := viper.GetString(flagDBPartitionName)
+	dbRetention, err := duration.Get(flagDBRetentionName)
+	parsed.Check(flagDBRetentionName, err)
+	dbMaintenance, err := duration.Get(flagDBMaintenanceName)
+	parsed.Check(flagDBMaintenanceName, err)
+	rollups := viper.GetString(flagRollupsName)
+	rollupInt, err := duration.Get(flagRollupIntName)
+	parsed.Check(flagRollupIntName, err)
+	storeInt, err := duration.Get(flagStoreIntervalName)
+	parsed.Check(flagStoreIntervalName, err)
 	signKey := viper.GetString(flagSignKeyName)
+	signSkew, err := duration.Get(flagSignSkewName)
+	parsed.Check(flagSignSkewName, err)
+	if err := parsed.Err(); err != nil {
+		return Config{}, err
+	}
+	signLegacy := viper.GetBool(flagSignLegacyName)
+	signStrict := viper.GetBool(flagSignStrictName)
 	cryptoKey := viper.GetString(flagCryptoKeyName)
+
+	var signKeys []SigningKey
+	if err := viper.UnmarshalKey(keySignKeys, &signKeys); err != nil {
+		return Config{}, err
+	}
+
+	var cryptoKeys []DecryptionKey
+	if err := viper.UnmarshalKey(keyCryptoKeys, &cryptoKeys); err != nil {
+		return Config{}, err
+	}
 	trustedSubnet := viper.GetString(flagTrustedSubnet)
 	grpcAddr := viper.GetString(flagGRPCAddrName)
+	metricsAddr := viper.GetString(flagMetricsAddrName)
+	logLevel := viper.GetString(flagLogLevelName)
+	tlsCert := viper.GetString(flagTLSCertName)
+	tlsKey := viper.GetString(flagTLSKeyName)
+	tlsClientCA := viper.GetString(flagTLSClientCAName)
 
 	cfg := Config{
-		Addr:            address,
-		StoreInt:        storeInt,
-		FileStoragePath: fileStoragePath,
-		DatabaseAddr:    databaseAddr,
-		Restore:         restore,
-		SignKey:         signKey,
-		CryptoKey:       cryptoKey,
-		TrustedSubnet:   trustedSubnet,
-		GRPCAddr:        grpcAddr,
-	}
-
-	if err := env.Parse(&cfg); err != nil {
-		panic(err)
+		Addr:                  address,
+		StoreInt:              storeInt,
+		FileStoragePath:       fileStoragePath,
+		DatabaseAddr:          databaseAddr,
+		DBMaxConns:            dbMaxConns,
+		DBMinConns:            dbMinConns,
+		DBMaxConnLifetime:     dbLifetime,
+		DBMaxConnIdleTime:     dbIdleTime,
+		DBHealthCheckPeriod:   dbHealthCheck,
+		DBStatementCache:      dbStmtCache,
+		DBAutoMigrate:         dbAutoMigrate,
+		DBPartition:           dbPartition,
+		DBRetention:           dbRetention,
+		DBMaintenanceInterval: dbMaintenance,
+		RollupResolutions:     rollups,
+		RollupInterval:        rollupInt,
+		Restore:               restore,
+		SignKey:               signKey,
+		SignSkew:              signSkew,
+		SignLegacy:            signLegacy,
+		SignStrict:            signStrict,
+		SignKeys:              signKeys,
+		CryptoKey:             cryptoKey,
+		CryptoKeys:            cryptoKeys,
+		TrustedSubnet:         trustedSubnet,
+		GRPCAddr:              grpcAddr,
+		MetricsAddr:           metricsAddr,
+		LogLevel:              logLevel,
+		TLSCert:               tlsCert,
+		TLSKey:                tlsKey,
+		TLSClientCA:           tlsClientCA,
 	}
 
-	return cfg
+	if err := env.ParseWithFuncs(&cfg, duration.ParserFuncs); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the configuration and returns all problems found.
+func (cfg Config) Validate() error {
+	var v configcheck.Validator
+
+	v.Check(flagAddressName, configcheck.CheckAddress(cfg.Addr))
+	v.Check(flagGRPCAddrName, configcheck.CheckAddress(cfg.GRPCAddr))
+	if cfg.MetricsAddr != "" {
+		v.Check(flagMetricsAddrName, configcheck.CheckAddress(cfg.MetricsAddr))
+	}
+
+	if cfg.DatabaseAddr != "" {
+		_, err := pgx.ParseConfig(cfg.DatabaseAddr)
+		v.Check(flagDatabaseAddrName, err)
+	}
+
+	if cfg.DBMaxConns < 0 {
+		v.Check(flagDBMaxConnsName, errors.New("must not be negative"))
+	}
+	if cfg.DBMinConns < 0 {
+		v.Check(flagDBMinConnsName, errors.New("must not be negative"))
+	}
+	if cfg.DBMaxConns > 0 && cfg.DBMinConns > cfg.DBMaxConns {
+		v.Check(flagDBMinConnsName, fmt.Errorf("must not exceed %s", flagDBMaxConnsName))
+	}
+
+	_, err := repo.ParseQueryExecMode(cfg.DBStatementCache)
+	v.Check(flagDBStmtCacheName, err)
+
+	_, err = repo.ParsePartition(cfg.DBPartition)
+	v.Check(flagDBPartitionName, err)
+	if cfg.DBRetention < 0 {
+		v.Check(flagDBRetentionName, errors.New("must not be negative"))
+	}
+	if cfg.DatabaseAddr != "" && cfg.DBMaintenanceInterval <= 0 {
+		v.Check(flagDBMaintenanceName, errors.New("must be positive"))
+	}
+
+	resolutions, err := services.ParseResolutions(cfg.RollupResolutions)
+	v.Check(flagRollupsName, err)
+	if len(resolutions) > 0 && cfg.RollupInterval <= 0 {
+		v.Check(flagRollupIntName, errors.New("must be positive"))
+	}
+
+	if cfg.TrustedSubnet != "" {
+		_, err := netip.ParsePrefix(cfg.TrustedSubnet)
+		v.Check(flagTrustedSubnet, err)
+	}
+
+	if cfg.CryptoKey != "" {
+		v.Check(flagCryptoKeyName, configcheck.CheckPrivateKey(cfg.CryptoKey))
+	}
+
+	_, err = cfg.Level()
+	v.Check(flagLogLevelName, err)
+
+	ids := make(map[string]bool)
+	for i, k := range cfg.SignKeys {
+		key := fmt.Sprintf("%s[%d]", keySignKeys, i)
+		v.Check(key, checkKeyID(k.ID, ids))
+		if k.Key == "" {
+			v.Check(key, errors.New("key is empty"))
+		}
+		_, err := ParseExpires(k.Expires)
+		v.Check(key, err)
+	}
+
+	ids = make(map[string]bool)
+	for i, k := range cfg.CryptoKeys {
+		key := fmt.Sprintf("%s[%d]", keyCryptoKeys, i)
+		v.Check(key, checkKeyID(k.ID, ids))
+		v.Check(key, configcheck.CheckPrivateKey(k.File))
+		_, err := ParseExpires(k.Expires)
+		v.Check(key, err)
+	}
+
+	if (cfg.SignKey != "" || len(cfg.SignKeys) > 0) && cfg.SignSkew == 0 {
+		v.Check(flagSignSkewName, errors.New("must be positive"))
+	}
+
+	v.Check(flagTLSCertName, tlsutil.CheckKeyPair(cfg.TLSCert, cfg.TLSKey))
+	if cfg.TLSClientCA != "" {
+		if cfg.TLSCert == "" {
+			v.Check(flagTLSClientCAName, errors.New("client certificates require TLS certificate of the server"))
+		}
+
+		_, err := tlsutil.LoadCertPool(cfg.TLSClientCA)
+		v.Check(flagTLSClientCAName, err)
+	}
+
+	return v.Err()
+}
+
+// checkKeyID checks that the ID is set and is not used by another key.
+func checkKeyID(id string, ids map[string]bool) error {
+	if id == "" {
+		return errors.New("id is empty")
+	}
+	if ids[id] {
+		return fmt.Errorf("duplicate id: %s", id)
+	}
+	ids[id] = true
+
+	return nil
+}
+
+// ParseExpires parses the expiration time of a key, empty string means the key doesn't expire.
+func ParseExpires(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// Settings returns the settings of the configuration for printing.
+func (cfg Config) Settings() []configcheck.Setting {
+	return []configcheck.Setting{
+		{Key: flagAddressName, Env: "ADDRESS", Value: cfg.Addr},
+		{Key: flagGRPCAddrName, Env: "GRPC_ADDRESS", Value: cfg.GRPCAddr},
+		{Key: flagMetricsAddrName, Env: "METRICS_ADDRESS", Value: cfg.MetricsAddr},
+		{Key: flagStoreIntervalName, Env: "STORE_INTERVAL", Value: cfg.StoreInt.String()},
+		{Key: flagFileStorageName, Env: "FILE_STORAGE_PATH", Value: cfg.FileStoragePath},
+		{Key: flagRestoreName, Env: "RESTORE", Value: cfg.Restore},
+		{Key: flagDatabaseAddrName, Env: "DATABASE_DSN", Value: cfg.DatabaseAddr, Secret: true},
+		{Key: flagDBMaxConnsName, Env: "DB_MAX_CONNS", Value: cfg.DBMaxConns},
+		{Key: flagDBMinConnsName, Env: "DB_MIN_CONNS", Value: cfg.DBMinConns},
+		{Key: flagDBLifetimeName, Env: "DB_MAX_CONN_LIFETIME", Value: cfg.DBMaxConnLifetime.String()},
+		{Key: flagDBIdleTimeName, Env: "DB_MAX_CONN_IDLE_TIME", Value: cfg.DBMaxConnIdleTime.String()},
+		{Key: flagDBHealthCheckName, Env: "DB_HEALTH_CHECK_PERIOD", Value: cfg.DBHealthCheckPeriod.String()},
+		{Key: flagDBStmtCacheName, Env: "DB_STATEMENT_CACHE", Value: cfg.DBStatementCache},
+		{Key: flagDBAutoMigrate, Env: "DB_AUTO_MIGRATE", Value: cfg.DBAutoMigrate},
+		{Key: flagDBPartitionName, Env: "DB_PARTITION", Value: cfg.DBPartition},
+		{Key: flagDBRetentionName, Env: "DB_RETENTION", Value: cfg.DBRetention.String()},
+		{Key: flagDBMaintenanceName, Env: "DB_MAINTENANCE_INTERVAL", Value: cfg.DBMaintenanceInterval.String()},
+		{Key: flagRollupsName, Env: "ROLLUP_RESOLUTIONS", Value: cfg.RollupResolutions},
+		{Key: flagRollupIntName, Env: "ROLLUP_INTERVAL", Value: cfg.RollupInterval.String()},
+		{Key: flagSignKeyName, Env: "KEY", Value: cfg.SignKey, Secret: true},
+		{Key: flagSignSkewName, Env: "AUTH_SKEW", Value: cfg.SignSkew.String()},
+		{Key: flagSignLegacyName, Env: "AUTH_LEGACY", Value: cfg.SignLegacy},
+		{Key: flagSignStrictName, Env: "AUTH_STRICT", Value: cfg.SignStrict},
+		{Key: keySignKeys, Value: maskedSignKeys(cfg.SignKeys)},
+		{Key: flagCryptoKeyName, Env: "CRYPTO_KEY", Value: cfg.CryptoKey},
+		{Key: keyCryptoKeys, Value: cfg.CryptoKeys},
+		{Key: flagTrustedSubnet, Env: "TRUSTED_SUBNET", Value: cfg.TrustedSubnet},
+		{Key: flagLogLevelName, Env: "LOG_LEVEL", Value: cfg.LogLevel},
+		{Key: flagTLSCertName, Env: "TLS_CERT", Value: cfg.TLSCert},
+		{Key: flagTLSKeyName, Env: "TLS_KEY", Value: cfg.TLSKey},
+		{Key: flagTLSClientCAName, Env: "TLS_CLIENT_CA", Value: cfg.TLSClientCA},
+	}
+}
+
+// maskedSignKeys returns the keys with secrets masked for printing.
+func maskedSignKeys(keys []SigningKey) []SigningKey {
+	var masked []SigningKey
+	for _, k := range keys {
+		k.Key = configcheck.SecretMask
+		masked = append(masked, k)
+	}
+
+	return masked
+}
+
+// Pool returns the settings of the connection pool of the database.
+func (cfg Config) Pool() repo.PoolConfig {
+	return repo.PoolConfig{
+		MaxConns:          cfg.DBMaxConns,
+		MinConns:          cfg.DBMinConns,
+		MaxConnLifetime:   cfg.DBMaxConnLifetime,
+		MaxConnIdleTime:   cfg.DBMaxConnIdleTime,
+		HealthCheckPeriod: cfg.DBHealthCheckPeriod,
+		StatementCache:    cfg.DBStatementCache,
+	}
+}
+
+// Retention returns the settings of partitioning and retention of the history of metrics.
+func (cfg Config) Retention() repo.RetentionConfig {
+	return repo.RetentionConfig{
+		Partition: cfg.DBPartition,
+		Retention: cfg.DBRetention,
+		Interval:  cfg.DBMaintenanceInterval,
+	}
+}
+
+// Resolutions returns resolutions of rollups of the history. Invalid resolutions
+// are rejected by validation, so they are empty here.
+func (cfg Config) Resolutions() []services.Resolution {
+	resolutions, err := services.ParseResolutions(cfg.RollupResolutions)
+	if err != nil {
+		return nil
+	}
+
+	return resolutions
+}
+
+// Level returns the log level. Empty log level is the info level.
+func (cfg Config) Level() (zerolog.Level, error) {
+	if cfg.LogLevel == "" {
+		return zerolog.InfoLevel, nil
+	}
+
+	return zerolog.ParseLevel(cfg.LogLevel)
 }
 
 // IsInMemStorage returns true if the server is configured to use in-memory storage
@@ -123,6 +586,15 @@ func (cfg Config) IsInMemStorage() bool {
 	return cfg.DatabaseAddr == ""
 }
 
+// TLSConfig returns the TLS configuration of the servers or nil if TLS is not enabled.
+func (cfg Config) TLSConfig() (*tls.Config, error) {
+	if cfg.TLSCert == "" {
+		return nil, nil
+	}
+
+	return tlsutil.ServerConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+}
+
 // IsFileStorage returns true if the server is configured to use additional file storage
 func (cfg Config) IsFileStorage() bool {
 	return cfg.FileStoragePath != "" && cfg.IsInMemStorage()
@@ -1,8 +1,14 @@
 package serverconf
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfig_IsFileStorage(t *testing.T) {
@@ -98,15 +104,24 @@ func TestMustLoadConfig(t *testing.T) {
 		{
 			name: "Test MustLoadConfig",
 			want: Config{
-				Addr:            defaultAddress,
-				StoreInt:        defaultStoreInterval,
-				FileStoragePath: "",
-				Restore:         defaultRestore,
-				DatabaseAddr:    "",
-				SignKey:         "",
-				CryptoKey:       "",
-				TrustedSubnet:   "",
-				GRPCAddr:        defaultGRPCAddr,
+				Addr:                  defaultAddress,
+				StoreInt:              defaultStoreInterval,
+				FileStoragePath:       "",
+				Restore:               defaultRestore,
+				DatabaseAddr:          "",
+				SignKey:               "",
+				SignSkew:              defaultSignSkew,
+				SignStrict:            defaultSignStrict,
+				DBAutoMigrate:         defaultDBAutoMigrate,
+				DBPartition:           defaultDBPartition,
+				DBRetention:           defaultDBRetention,
+				DBMaintenanceInterval: defaultDBMaintenance,
+				RollupResolutions:     defaultRollups,
+				RollupInterval:        defaultRollupInt,
+				CryptoKey:             "",
+				TrustedSubnet:         "",
+				GRPCAddr:              defaultGRPCAddr,
+				LogLevel:              defaultLogLevel,
 			},
 		},
 	}
@@ -118,3 +133,170 @@ func TestMustLoadConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Config
+		wantErr bool
+
+		// wantKeys are settings which must be named in the error
+		wantKeys []string
+	}{
+		{
+			name:    "valid config",
+			content: `{"trusted_subnet": "10.0.0.0/8", "auth_key": "key", "store_interval": 5, "log_level": "debug"}`,
+			want: Config{
+				TrustedSubnet: "10.0.0.0/8",
+				SignKey:       "key",
+				StoreInt:      5 * time.Second,
+				LogLevel:      "debug",
+			},
+		},
+		{
+			name:    "duration string",
+			content: `{"store_interval": "1m"}`,
+			want: Config{
+				StoreInt: time.Minute,
+				LogLevel: defaultLogLevel,
+			},
+		},
+		{
+			name:    "invalid subnet",
+			content: `{"trusted_subnet": "10.0.0.0/64"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid log level",
+			content: `{"log_level": "verbose"}`,
+			wantErr: true,
+		},
+		{
+			name:     "invalid durations are reported together",
+			content:  `{"store_interval": "often", "db_retention": "forever"}`,
+			wantErr:  true,
+			wantKeys: []string{flagStoreIntervalName, flagDBRetentionName},
+		},
+		{
+			name:    "missing crypto key",
+			content: `{"crypto_key": "/nonexistent/key.pem"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(file, []byte(tt.content), 0o600))
+			t.Setenv("CONFIG", file)
+
+			got, err := Reload()
+			if tt.wantErr {
+				require.Error(t, err)
+				for _, key := range tt.wantKeys {
+					assert.Contains(t, err.Error(), key+": ")
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.TrustedSubnet, got.TrustedSubnet)
+			assert.Equal(t, tt.want.SignKey, got.SignKey)
+			assert.Equal(t, tt.want.StoreInt, got.StoreInt)
+			assert.Equal(t, tt.want.LogLevel, got.LogLevel)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := Config{Addr: "localhost:8080", GRPCAddr: "localhost:8081"}
+
+	tests := []struct {
+		name     string
+		modify   func(*Config)
+		wantKeys []string
+	}{
+		{
+			name:   "valid",
+			modify: func(*Config) {},
+		},
+		{
+			name: "all problems are reported",
+			modify: func(c *Config) {
+				c.Addr = "localhost"
+				c.TrustedSubnet = "10.0.0/8"
+				c.CryptoKey = "/nonexistent/key.pem"
+				c.LogLevel = "verbose"
+			},
+			wantKeys: []string{flagAddressName, flagTrustedSubnet, flagCryptoKeyName, flagLogLevelName},
+		},
+		{
+			name: "invalid named keys",
+			modify: func(c *Config) {
+				c.SignKeys = []SigningKey{
+					{ID: "v1", Key: "a", Expires: "2030-01-01T00:00:00Z"},
+					{ID: "v1", Key: "b"},
+					{ID: "v2", Key: "c", Expires: "tomorrow"},
+				}
+				c.CryptoKeys = []DecryptionKey{{File: "/nonexistent/key.pem"}}
+			},
+			wantKeys: []string{"auth_keys[1]", "auth_keys[2]", "crypto_keys[0]"},
+		},
+		{
+			name: "invalid metrics address",
+			modify: func(c *Config) {
+				c.MetricsAddr = "9090"
+			},
+			wantKeys: []string{flagMetricsAddrName},
+		},
+		{
+			name: "invalid pool settings",
+			modify: func(c *Config) {
+				c.DBMaxConns = 2
+				c.DBMinConns = 4
+				c.DBStatementCache = "prepared"
+			},
+			wantKeys: []string{flagDBMinConnsName, flagDBStmtCacheName},
+		},
+		{
+			name: "invalid retention settings",
+			modify: func(c *Config) {
+				c.DatabaseAddr = "postgres://localhost:5432/metrics"
+				c.DBPartition = "month"
+				c.DBRetention = -time.Hour
+			},
+			wantKeys: []string{flagDBPartitionName, flagDBRetentionName, flagDBMaintenanceName},
+		},
+		{
+			name: "invalid rollup settings",
+			modify: func(c *Config) {
+				c.RollupResolutions = "1m,1m"
+			},
+			wantKeys: []string{flagRollupsName},
+		},
+		{
+			name: "rollups without interval",
+			modify: func(c *Config) {
+				c.RollupResolutions = "1m"
+			},
+			wantKeys: []string{flagRollupIntName},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.wantKeys) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			for _, key := range tt.wantKeys {
+				assert.Contains(t, err.Error(), key+": ")
+			}
+		})
+	}
+}
@@ -1,38 +1,225 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"embed"
 	"errors"
+	"fmt"
+	"io"
+	"strconv"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
 )
 
-const sourceURL = "file://internal/database/migrations/postgres/schema"
+// schema holds the migrations, they are built into the binary
+// so the server doesn't depend on its working directory.
+//
+//go:embed schema/*.sql
+var schema embed.FS
 
-// NewConnection opens a connection to the database.
+const schemaDir = "schema"
+
+// Commands of migrations.
+const (
+	CommandUp      = "up"
+	CommandDown    = "down"
+	CommandVersion = "version"
+	CommandForce   = "force"
+)
+
+// NewConnection opens the pool of connections to the database.
+// Queries of the returned database are served by connections of the pool.
+// Migrations are applied if autoMigrate is set.
 // If connection fails, returns error.
-// If connection succeeds, returns nil.
-func NewConnection(dsn string) (*sqlx.DB, error) {
-	db, err := sqlx.Connect("pgx", dsn)
+func NewConnection(cfg *pgxpool.Config, autoMigrate bool) (*sqlx.DB, *pgxpool.Pool, error) {
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := sqlx.NewDb(stdlib.OpenDBFromPool(pool), "pgx")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		pool.Close()
+		return nil, nil, err
+	}
+
+	if autoMigrate {
+		if err := migrateUp(db.DB); err != nil {
+			db.Close()
+			pool.Close()
+			return nil, nil, err
+		}
+	}
+
+	return db, pool, nil
+}
+
+// Command is a command of migrations run by operators.
+type Command struct {
+	// Name is one of up, down, version and force
+	Name string
+
+	// N is the number of migrations to revert for down
+	// and the version to set for force
+	N int
+}
+
+// ParseCommand parses arguments of the migrate command:
+// up, down [N], version or force VERSION. Down reverts one migration by default.
+func ParseCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		return Command{}, errors.New("command is missing, expected up, down [N], version or force VERSION")
+	}
+
+	c := Command{Name: args[0]}
+	switch c.Name {
+	case CommandUp, CommandVersion:
+		if len(args) != 1 {
+			return Command{}, fmt.Errorf("%s: unexpected arguments %q", c.Name, args[1:])
+		}
+	case CommandDown:
+		c.N = 1
+		if len(args) > 2 {
+			return Command{}, fmt.Errorf("%s: unexpected arguments %q", c.Name, args[2:])
+		}
+
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return Command{}, fmt.Errorf("%s: invalid number of migrations %q", c.Name, args[1])
+			}
+
+			c.N = n
+		}
+	case CommandForce:
+		if len(args) != 2 {
+			return Command{}, fmt.Errorf("%s: version is expected", c.Name)
+		}
+
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < -1 {
+			return Command{}, fmt.Errorf("%s: invalid version %q", c.Name, args[1])
+		}
+
+		c.N = v
+	default:
+		return Command{}, fmt.Errorf("unknown command %q, expected up, down [N], version or force VERSION", c.Name)
+	}
+
+	return c, nil
+}
+
+// Run connects to the database and runs the command. The version of the schema
+// after the command is written to w.
+func (c Command) Run(dsn string, w io.Writer) (err error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		return err
+	}
+
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, closeMigrate(m))
+	}()
+
+	switch c.Name {
+	case CommandUp:
+		err = m.Up()
+	case CommandDown:
+		err = m.Steps(-c.N)
+	case CommandForce:
+		err = m.Force(c.N)
+	}
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		fmt.Fprintln(w, "no migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "version: %d, dirty: %t\n", version, dirty)
+	return nil
+}
+
+func migrateUp(db *sql.DB) (err error) {
+	m, err := newMigrate(db)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, closeMigrate(m))
+	}()
+
+	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	return nil
+}
+
+// newMigrate returns migrations of the embedded schema for the database.
+// Migrations are run on a dedicated connection, so closing them with closeMigrate
+// releases the connection and leaves the database open.
+func newMigrate(db *sql.DB) (*migrate.Migrate, error) {
+	src, err := iofs.New(schema, schemaDir)
 	if err != nil {
 		return nil, err
 	}
 
-	driver, err := postgres.WithInstance(db.DB, &postgres.Config{})
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
 	if err != nil {
+		src.Close()
 		return nil, err
 	}
-	m, err := migrate.NewWithDatabaseInstance(sourceURL, "postgres", driver)
+
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{})
 	if err != nil {
+		src.Close()
+		conn.Close()
 		return nil, err
 	}
 
-	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		src.Close()
+		driver.Close()
 		return nil, err
 	}
 
-	return db, nil
+	return m, nil
+}
+
+// closeMigrate closes the source and the connection of the migrations.
+func closeMigrate(m *migrate.Migrate) error {
+	srcErr, dbErr := m.Close()
+	if srcErr != nil {
+		srcErr = fmt.Errorf("close migrations source: %w", srcErr)
+	}
+	if dbErr != nil {
+		dbErr = fmt.Errorf("close migrations connection: %w", dbErr)
+	}
+
+	return errors.Join(srcErr, dbErr)
 }
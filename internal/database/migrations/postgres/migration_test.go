@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	src, err := iofs.New(schema, schemaDir)
+	require.NoError(t, err)
+	defer src.Close()
+
+	version, err := src.First()
+	require.NoError(t, err)
+
+	for {
+		up, _, err := src.ReadUp(version)
+		require.NoError(t, err, "up migration of version %d", version)
+		up.Close()
+
+		down, _, err := src.ReadDown(version)
+		require.NoError(t, err, "down migration of version %d", version)
+		down.Close()
+
+		next, err := src.Next(version)
+		if err != nil {
+			break
+		}
+		version = next
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    Command
+		wantErr bool
+	}{
+		{
+			name: "up",
+			args: []string{"up"},
+			want: Command{Name: CommandUp},
+		},
+		{
+			name: "version",
+			args: []string{"version"},
+			want: Command{Name: CommandVersion},
+		},
+		{
+			name: "down, one migration by default",
+			args: []string{"down"},
+			want: Command{Name: CommandDown, N: 1},
+		},
+		{
+			name: "down, number of migrations",
+			args: []string{"down", "3"},
+			want: Command{Name: CommandDown, N: 3},
+		},
+		{
+			name: "force",
+			args: []string{"force", "20231009120736"},
+			want: Command{Name: CommandForce, N: 20231009120736},
+		},
+		{
+			name:    "missing command",
+			wantErr: true,
+		},
+		{
+			name:    "unknown command",
+			args:    []string{"drop"},
+			wantErr: true,
+		},
+		{
+			name:    "up with arguments",
+			args:    []string{"up", "1"},
+			wantErr: true,
+		},
+		{
+			name:    "down, invalid number",
+			args:    []string{"down", "0"},
+			wantErr: true,
+		},
+		{
+			name:    "force without version",
+			args:    []string{"force"},
+			wantErr: true,
+		},
+		{
+			name:    "force, invalid version",
+			args:    []string{"force", "latest"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommand(tt.args)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1 @@
+drop table metric_samples;
\ No newline at end of file
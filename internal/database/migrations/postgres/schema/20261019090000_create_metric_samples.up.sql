@@ -0,0 +1,8 @@
+create table if not exists metric_samples(
+    name text not null,
+    type text not null,
+    value double precision not null,
+    ts timestamptz not null default now()
+) partition by range (ts);
+
+create index if not exists metric_samples_name_ts_idx on metric_samples (name, ts);
@@ -0,0 +1 @@
+drop table metric_rollups;
\ No newline at end of file
@@ -0,0 +1,14 @@
+create table if not exists metric_rollups(
+    resolution bigint not null,
+    name text not null,
+    type text not null,
+    start timestamptz not null,
+    count bigint not null,
+    min double precision not null,
+    max double precision not null,
+    avg double precision not null,
+    last double precision not null,
+    increase double precision not null,
+    rate double precision not null,
+    primary key (resolution, name, start)
+);
@@ -24,6 +24,11 @@ func isRetriable(err error) bool {
 
 // Retry retries the function f until success, retry limit is reached or context is done.
 func Retry(ctx context.Context, f func() error) error {
+	return RetryNotify(ctx, f, nil)
+}
+
+// RetryNotify is like Retry, but calls notify with the error before every retry.
+func RetryNotify(ctx context.Context, f func() error, notify func(error)) error {
 	var (
 		try   int
 		delay = time.Second
@@ -50,6 +55,10 @@ func Retry(ctx context.Context, f func() error) error {
 		case <-ctx.Done():
 			return err
 		case <-ticker.C:
+			if notify != nil {
+				notify(err)
+			}
+
 			delay += difference
 			try++
 		}
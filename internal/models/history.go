@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Sample is a value of a metric at a moment. Values of counters are their totals.
+type Sample struct {
+	// Name is a name of the metric
+	Name string `db:"name"`
+
+	// Type is a type of the metric
+	Type string `db:"type"`
+
+	// Value is a value of the metric
+	Value float64 `db:"value"`
+
+	// Time is the moment the value was stored
+	Time time.Time `db:"ts"`
+}
+
+// Rollup aggregates samples of a metric over an interval of its resolution.
+// Rollups of gauges keep the minimum, maximum, average and last value,
+// rollups of counters keep the increase and its rate per second.
+type Rollup struct {
+	// Name is a name of the metric
+	Name string `db:"name"`
+
+	// Type is a type of the metric
+	Type string `db:"type"`
+
+	// Start is the start of the interval
+	Start time.Time `db:"start"`
+
+	// Count is the number of samples in the interval
+	Count int64 `db:"count"`
+
+	Min  float64 `db:"min"`
+	Max  float64 `db:"max"`
+	Avg  float64 `db:"avg"`
+	Last float64 `db:"last"`
+
+	Increase float64 `db:"increase"`
+	Rate     float64 `db:"rate"`
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// SeriesName builds the name of a labeled metric in Prometheus notation,
+// e.g. http_requests_total{code="200",target="localhost:9090"}.
+// Labels are sorted by key, so the same set of labels always gives the same name.
+// If there are no labels, the name is returned as is.
+func SeriesName(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := strings.Builder{}
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// ParseSeriesName splits the name of a labeled metric into the base name and labels.
+// It is the inverse of SeriesName.
+func ParseSeriesName(s string) (string, map[string]string, error) {
+	i := strings.IndexByte(s, '{')
+	if i < 0 {
+		return s, nil, nil
+	}
+
+	if !strings.HasSuffix(s, "}") {
+		return "", nil, errors.New("unterminated label set")
+	}
+
+	labels, rest, err := ParseLabels(s[i:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	if rest != "" {
+		return "", nil, errors.New("unexpected characters after label set")
+	}
+
+	return s[:i], labels, nil
+}
+
+// ParseLabels parses a label set in the form {key="value",...} at the beginning of s.
+// It returns parsed labels and the rest of the string after the closing brace.
+func ParseLabels(s string) (map[string]string, string, error) {
+	if s == "" || s[0] != '{' {
+		return nil, "", errors.New("label set must start with '{'")
+	}
+
+	labels := make(map[string]string)
+	s = s[1:]
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return nil, "", errors.New("unterminated label set")
+		}
+
+		if s[0] == '}' {
+			return labels, s[1:], nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, "", errors.New("invalid label")
+		}
+
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " ")
+		if s == "" || s[0] != '"' {
+			return nil, "", errors.New("label value must be quoted")
+		}
+
+		val, n, err := unquoteLabelValue(s)
+		if err != nil {
+			return nil, "", err
+		}
+		labels[key] = val
+
+		s = strings.TrimLeft(s[n:], " ")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		}
+	}
+}
+
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// unquoteLabelValue reads a quoted label value at the beginning of s.
+// It returns unescaped value and the number of consumed bytes.
+func unquoteLabelValue(s string) (string, int, error) {
+	b := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, errors.New("unterminated label value")
+			}
+
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, errors.New("unterminated label value")
+}
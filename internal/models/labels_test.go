@@ -0,0 +1,84 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesName(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name:   "no labels",
+			metric: "Alloc",
+			labels: nil,
+			want:   "Alloc",
+		},
+		{
+			name:   "sorted labels",
+			metric: "requests",
+			labels: map[string]string{"target": "localhost:8080", "code": "200"},
+			want:   `requests{code="200",target="localhost:8080"}`,
+		},
+		{
+			name:   "escaped value",
+			metric: "requests",
+			labels: map[string]string{"path": `a"b\c`},
+			want:   `requests{path="a\"b\\c"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SeriesName(tt.metric, tt.labels))
+		})
+	}
+}
+
+func TestParseSeriesName(t *testing.T) {
+	tests := []struct {
+		name       string
+		series     string
+		wantName   string
+		wantLabels map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "no labels",
+			series:     "Alloc",
+			wantName:   "Alloc",
+			wantLabels: nil,
+			wantErr:    false,
+		},
+		{
+			name:       "labels",
+			series:     `requests{code="200", path="a\"b\\c"}`,
+			wantName:   "requests",
+			wantLabels: map[string]string{"code": "200", "path": `a"b\c`},
+			wantErr:    false,
+		},
+		{
+			name:    "unterminated label set",
+			series:  `requests{code="200"`,
+			wantErr: true,
+		},
+		{
+			name:    "unquoted value",
+			series:  `requests{code=200}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, labels, err := ParseSeriesName(tt.series)
+
+			assert.Equal(t, tt.wantErr, err != nil, "ParseSeriesName() error = %v", err)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
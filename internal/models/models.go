@@ -1,5 +1,12 @@
 package models
 
+// Temporalities of counters. A delta is the increment since the previous report,
+// a cumulative value is the total since the start of the source.
+const (
+	TemporalityDelta      = "delta"
+	TemporalityCumulative = "cumulative"
+)
+
 // MetricJSON is data structure for JSON request and response.
 type MetricJSON struct {
 	// ID is a name of the metric
@@ -13,6 +20,12 @@ type MetricJSON struct {
 
 	// Value is a value of the metric in case of gauge type
 	Value *float64 `json:"value,omitempty"`
+
+	// Temporality tells whether Delta is a delta or a cumulative value, a delta if empty
+	Temporality string `json:"temporality,omitempty"`
+
+	// Source identifies the reporter of the metric, the address of the client if empty
+	Source string `json:"source,omitempty"`
 }
 
 // MetricDB is data structure for database.
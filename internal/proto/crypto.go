@@ -0,0 +1,46 @@
+package proto
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// EncryptedRequest is a request which may carry its payload encrypted.
+type EncryptedRequest interface {
+	proto.Message
+	GetEncrypted() []byte
+	Decrypt(decrypt func([]byte) ([]byte, error)) error
+}
+
+var _ EncryptedRequest = (*UpdateMetricRequest)(nil)
+
+// Encrypt replaces the metric with its encrypted encoding.
+func (x *UpdateMetricRequest) Encrypt(encrypt func([]byte) ([]byte, error)) error {
+	b, err := proto.Marshal(x.GetMetric())
+	if err != nil {
+		return err
+	}
+
+	enc, err := encrypt(b)
+	if err != nil {
+		return err
+	}
+
+	x.Metric, x.Encrypted = nil, enc
+	return nil
+}
+
+// Decrypt replaces the encrypted payload with the metric it carries.
+func (x *UpdateMetricRequest) Decrypt(decrypt func([]byte) ([]byte, error)) error {
+	b, err := decrypt(x.GetEncrypted())
+	if err != nil {
+		return err
+	}
+
+	m := &Metric{}
+	if err := proto.Unmarshal(b, m); err != nil {
+		return err
+	}
+
+	x.Metric, x.Encrypted = m, nil
+	return nil
+}
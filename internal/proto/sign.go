@@ -0,0 +1,17 @@
+package proto
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// SignedPayload returns the data the signature of a call covers: the full name
+// of the method and the deterministic encoding of the request message.
+// The message is nil for streaming calls, their signature covers the method only.
+func SignedPayload(method string, m proto.Message) ([]byte, error) {
+	b := []byte(method + "\n")
+	if m == nil {
+		return b, nil
+	}
+
+	return proto.MarshalOptions{Deterministic: true}.MarshalAppend(b, m)
+}
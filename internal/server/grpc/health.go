@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthInterval is the period of checks of the repository reported to watchers.
+const healthInterval = 5 * time.Second
+
+// healthServer implements the standard health checking protocol. The server and
+// the Metrics service are serving while the repository is available. A repository
+// which can't check its connection, e.g. the in-memory one, is always available.
+type healthServer struct {
+	*health.Server
+	pinger services.Pinger
+}
+
+func newHealthServer(r repo.Repository) *healthServer {
+	h := &healthServer{Server: health.NewServer()}
+	h.pinger, _ = r.(services.Pinger)
+	h.update()
+
+	return h
+}
+
+// Check checks the repository and returns the status of the service.
+func (h *healthServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	h.update()
+	return h.Server.Check(ctx, in)
+}
+
+// run checks the repository periodically until the context is done, so watchers
+// are notified when the status changes.
+func (h *healthServer) run(ctx context.Context) {
+	t := time.NewTicker(healthInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			h.update()
+		}
+	}
+}
+
+func (h *healthServer) update() {
+	status := healthpb.HealthCheckResponse_SERVING
+	if h.pinger != nil && h.pinger.Ping() != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	h.SetServingStatus("", status)
+	h.SetServingStatus(proto.Metrics_ServiceDesc.ServiceName, status)
+}
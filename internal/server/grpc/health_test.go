@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// pingerRepo is a repository which can check its connection.
+type pingerRepo struct {
+	*mocks.Repository
+	*mocks.Pinger
+}
+
+func TestHealthServer_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		repo    func(t *testing.T) repo.Repository
+		service string
+		want    healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name:    "in-memory repository",
+			repo:    func(t *testing.T) repo.Repository { return repo.NewStorage() },
+			service: proto.Metrics_ServiceDesc.ServiceName,
+			want:    healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name: "available database",
+			repo: func(t *testing.T) repo.Repository {
+				p := mocks.NewPinger(t)
+				p.On("Ping").Return(nil)
+				return pingerRepo{Repository: mocks.NewRepository(t), Pinger: p}
+			},
+			want: healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name: "unavailable database",
+			repo: func(t *testing.T) repo.Repository {
+				p := mocks.NewPinger(t)
+				p.On("Ping").Return(assert.AnError)
+				return pingerRepo{Repository: mocks.NewRepository(t), Pinger: p}
+			},
+			service: proto.Metrics_ServiceDesc.ServiceName,
+			want:    healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHealthServer(tt.repo(t))
+
+			resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tt.service})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, resp.GetStatus())
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// verify verifies the HMAC signature carried in metadata of the call. Signatures use
+// the same metadata keys as the headers of the HTTP server and cover the full method name
+// followed by the request message.
+func (s *Security) verify(ctx context.Context, cfg *SecurityConfig, method string, m proto.Message) error {
+	if cfg.Signer == nil {
+		return nil
+	}
+
+	sig := services.Signature{
+		Hash:      metadataValue(ctx, services.HeaderHash),
+		Timestamp: metadataValue(ctx, services.HeaderTimestamp),
+		Nonce:     metadataValue(ctx, services.HeaderNonce),
+		KeyID:     metadataValue(ctx, services.HeaderKeyID),
+	}
+	if sig.Hash == "" {
+		if cfg.Strict && s.writes[method] {
+			return status.Error(codes.Unauthenticated, "call is not signed")
+		}
+
+		return nil
+	}
+
+	payload, err := pb.SignedPayload(method, m)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if _, err := cfg.Signer.Verify(payload, sig, cfg.Guard, time.Now()); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package interceptors
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestSecurity_Auth(t *testing.T) {
+	const (
+		write = "/metrics.Metrics/UpdateMetric"
+		read  = "/metrics.Metrics/GetMetric"
+	)
+
+	s := services.NewHashSigner("test")
+	req := &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "test", Type: "gauge", Value: 1}}
+
+	sign := func(method string) metadata.MD {
+		payload, err := pb.SignedPayload(method, req)
+		require.NoError(t, err)
+
+		headers, err := s.SignatureHeaders(payload, time.Now())
+		require.NoError(t, err)
+
+		md := metadata.MD{}
+		for k, v := range headers {
+			md.Set(strings.ToLower(k), v)
+		}
+		return md
+	}
+
+	legacy := func(method string) metadata.MD {
+		payload, err := pb.SignedPayload(method, req)
+		require.NoError(t, err)
+
+		hash, err := s.CalcHash(payload)
+		require.NoError(t, err)
+
+		return metadata.Pairs(services.HeaderHash, hex.EncodeToString(hash))
+	}
+
+	tests := []struct {
+		name     string
+		cfg      SecurityConfig
+		method   string
+		md       metadata.MD
+		wantCode codes.Code
+	}{
+		{
+			name:     "signed write",
+			cfg:      SecurityConfig{Signer: s, Strict: true},
+			method:   write,
+			md:       sign(write),
+			wantCode: codes.OK,
+		},
+		{
+			name:     "legacy signature",
+			cfg:      SecurityConfig{Signer: s, Strict: true},
+			method:   write,
+			md:       legacy(write),
+			wantCode: codes.OK,
+		},
+		{
+			name:     "signature of another method",
+			cfg:      SecurityConfig{Signer: s, Strict: true},
+			method:   write,
+			md:       sign(read),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "unsigned write in strict mode",
+			cfg:      SecurityConfig{Signer: s, Strict: true},
+			method:   write,
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "unsigned write",
+			cfg:      SecurityConfig{Signer: s},
+			method:   write,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "unsigned read in strict mode",
+			cfg:      SecurityConfig{Signer: s, Strict: true},
+			method:   read,
+			wantCode: codes.OK,
+		},
+		{
+			name:     "no key",
+			cfg:      SecurityConfig{Strict: true},
+			method:   write,
+			wantCode: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewSecurity(tt.cfg, write)
+
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			info := &grpc.UnaryServerInfo{FullMethod: tt.method}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return req, nil
+			}
+
+			_, err := a.UnaryServerInterceptor()(ctx, req, info, handler)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
+
+func TestSecurity_Store(t *testing.T) {
+	const write = "/metrics.Metrics/UpdateMetric"
+
+	a := NewSecurity(SecurityConfig{}, write)
+	info := &grpc.UnaryServerInfo{FullMethod: write}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return req, nil
+	}
+
+	_, err := a.UnaryServerInterceptor()(context.Background(), &pb.UpdateMetricRequest{}, info, handler)
+	require.NoError(t, err)
+
+	// The new configuration applies to the following calls
+	a.Store(SecurityConfig{Signer: services.NewHashSigner("test"), Strict: true})
+
+	_, err = a.UnaryServerInterceptor()(context.Background(), &pb.UpdateMetricRequest{}, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
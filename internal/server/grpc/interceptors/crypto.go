@@ -0,0 +1,40 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// decrypt decrypts the payload of the request. Requests carrying payloads
+// must be encrypted if the crypto is set. If keys of the crypto are selected
+// by their IDs, the key is selected by the CryptoKeyID metadata.
+func decrypt(ctx context.Context, cr services.Crypto, req any) error {
+	r, ok := req.(pb.EncryptedRequest)
+	if cr == nil || !ok {
+		return nil
+	}
+
+	if len(r.GetEncrypted()) == 0 {
+		return status.Error(codes.InvalidArgument, "request is not encrypted")
+	}
+
+	dec := cr
+	if kc, ok := cr.(services.KeyedCrypto); ok {
+		var err error
+		dec, err = kc.ForKey(metadataValue(ctx, services.HeaderCryptoKeyID), time.Now())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if err := r.Decrypt(dec.Decrypt); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return nil
+}
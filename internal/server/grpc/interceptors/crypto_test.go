@@ -0,0 +1,89 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSecurity_Crypto(t *testing.T) {
+	metric := &pb.Metric{Id: "test", Type: "gauge", Value: 1}
+
+	// The mock "encrypts" by reversing bytes, so decryption reverses them back
+	reverse := func(src []byte) ([]byte, error) {
+		b := make([]byte, len(src))
+		for i := range src {
+			b[len(src)-1-i] = src[i]
+		}
+		return b, nil
+	}
+
+	tests := []struct {
+		name     string
+		req      func() *pb.UpdateMetricRequest
+		err      error
+		wantCode codes.Code
+	}{
+		{
+			name: "encrypted request",
+			req: func() *pb.UpdateMetricRequest {
+				req := &pb.UpdateMetricRequest{Metric: metric}
+				require.NoError(t, req.Encrypt(reverse))
+				return req
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "plain request",
+			req: func() *pb.UpdateMetricRequest {
+				return &pb.UpdateMetricRequest{Metric: metric}
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "decrypt error",
+			req: func() *pb.UpdateMetricRequest {
+				return &pb.UpdateMetricRequest{Encrypted: []byte("test")}
+			},
+			err:      assert.AnError,
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crypto := mocks.NewCrypto(t)
+			crypto.On("Decrypt", mock.Anything).Maybe().
+				Return(func(src []byte) ([]byte, error) {
+					if tt.err != nil {
+						return nil, tt.err
+					}
+
+					return reverse(src)
+				})
+
+			s := NewSecurity(SecurityConfig{Crypto: crypto})
+			handler := func(ctx context.Context, req any) (any, error) {
+				return req, nil
+			}
+
+			resp, err := s.UnaryServerInterceptor()(context.Background(), tt.req(),
+				&grpc.UnaryServerInfo{FullMethod: "/test"}, handler)
+			require.Equal(t, tt.wantCode, status.Code(err))
+
+			if tt.wantCode == codes.OK {
+				req := resp.(*pb.UpdateMetricRequest)
+				assert.True(t, proto.Equal(metric, req.GetMetric()))
+				assert.Empty(t, req.GetEncrypted())
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package interceptors
+
+import (
+	"context"
+
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// headerRealIP is the key of the real IP of the client, as the HTTP server reads it.
+const headerRealIP = "X-Real-IP"
+
+// checkIP rejects calls of clients which are not in the trusted subnet.
+// The real IP is taken from metadata, as the HTTP server takes it from the header.
+func checkIP(ctx context.Context, ip services.IPChecker) error {
+	if ip == nil {
+		return nil
+	}
+
+	trusted, err := ip.IsTrusted(metadataValue(ctx, headerRealIP))
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !trusted {
+		return status.Error(codes.PermissionDenied, "untrusted IP")
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package interceptors
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestSecurity_IPCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		wantCode codes.Code
+	}{
+		{
+			name:     "Trusted IP",
+			ip:       "192.168.1.4",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "Untrusted IP",
+			ip:       "192.168.0.0",
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "Invalid IP",
+			ip:       "192.168.1",
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	prefix, err := netip.ParsePrefix("192.168.1.0/24")
+	require.NoError(t, err)
+
+	s := NewSecurity(SecurityConfig{IP: services.NewIPChecker(prefix)})
+	handler := func(ctx context.Context, req any) (any, error) {
+		return req, nil
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("X-Real-IP", tt.ip))
+
+			_, err := s.UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test"}, handler)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerMetrics counts calls and observes their latency per method and status code.
+func UnaryServerMetrics(reg *telemetry.Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		t := time.Now()
+		resp, err := handler(ctx, req)
+
+		labels := map[string]string{
+			"method": info.FullMethod,
+			"code":   status.Code(err).String(),
+		}
+		reg.Counter(telemetry.ServerPrefix+"grpc_requests_total", labels).Inc()
+		reg.Histogram(telemetry.ServerPrefix+"grpc_request_duration_seconds", labels, nil).
+			Observe(time.Since(t).Seconds())
+
+		return resp, err
+	}
+}
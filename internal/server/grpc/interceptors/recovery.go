@@ -0,0 +1,20 @@
+package interceptors
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RecoveryHandler logs the panic of a handler with its stack and turns it
+// into the Internal error, so a broken call doesn't crash the server.
+func RecoveryHandler(l zerolog.Logger) recovery.RecoveryHandlerFuncContext {
+	return func(ctx context.Context, p any) error {
+		l.Error().Interface("panic", p).Bytes("stack", debug.Stack()).Msg("grpc server - recovered from panic")
+		return status.Error(codes.Internal, "internal error")
+	}
+}
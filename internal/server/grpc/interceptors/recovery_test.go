@@ -0,0 +1,24 @@
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryHandler(t *testing.T) {
+	i := recovery.UnaryServerInterceptor(recovery.WithRecoveryHandlerContext(RecoveryHandler(zerolog.Nop())))
+	handler := func(ctx context.Context, req any) (any, error) {
+		var v any = int64(1)
+		return v.(float64), nil
+	}
+
+	_, err := i(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test"}, handler)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
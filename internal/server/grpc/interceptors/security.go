@@ -0,0 +1,106 @@
+package interceptors
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// SecurityConfig is the configuration of the security checks of calls.
+type SecurityConfig struct {
+	// IP checks the real IP of clients, it is not checked if IP is nil
+	IP services.IPChecker
+
+	// Signer verifies signatures, calls are not authenticated if it is nil
+	Signer *services.HashSigner
+
+	// Guard checks timestamps and nonces of signatures if it is not nil
+	Guard *services.ReplayGuard
+
+	// Strict rejects unsigned calls of write methods
+	Strict bool
+
+	// Crypto decrypts payloads of requests, requests are expected
+	// to be encrypted if it is not nil
+	Crypto services.Crypto
+}
+
+// Security applies the same checks to calls as the middleware of the HTTP server:
+// the real IP of the client is checked, then the signature is verified
+// and the payload is decrypted. The configuration can be replaced at any time, e.g. on reload.
+type Security struct {
+	cfg    atomic.Pointer[SecurityConfig]
+	writes map[string]bool
+}
+
+// NewSecurity creates a new instance of the checks. Unsigned calls of the write methods
+// are rejected in strict mode, other methods may be called without a signature.
+func NewSecurity(cfg SecurityConfig, writeMethods ...string) *Security {
+	s := &Security{
+		writes: make(map[string]bool, len(writeMethods)),
+	}
+	for _, m := range writeMethods {
+		s.writes[m] = true
+	}
+	s.Store(cfg)
+
+	return s
+}
+
+// Store replaces the configuration.
+func (s *Security) Store(cfg SecurityConfig) {
+	s.cfg.Store(&cfg)
+}
+
+// UnaryServerInterceptor checks the call and decrypts the request message.
+func (s *Security) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		cfg := s.cfg.Load()
+		if err := checkIP(ctx, cfg.IP); err != nil {
+			return nil, err
+		}
+
+		m, _ := req.(proto.Message)
+		if err := s.verify(ctx, cfg, info.FullMethod, m); err != nil {
+			return nil, err
+		}
+
+		if err := decrypt(ctx, cfg.Crypto, req); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor checks the call when a stream is opened.
+func (s *Security) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		cfg := s.cfg.Load()
+		if err := checkIP(ss.Context(), cfg.IP); err != nil {
+			return err
+		}
+
+		if err := s.verify(ss.Context(), cfg, info.FullMethod, nil); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// metadataValue returns the first value of the key in incoming metadata. Keys are
+// the names of HTTP headers, they are lowercased as gRPC transmits them.
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(strings.ToLower(key)); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
@@ -2,6 +2,8 @@ package grpc
 
 import (
 	"context"
+	"net"
+	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/models"
 	proto2 "github.com/leonf08/metrics-yp.git/internal/proto"
@@ -9,22 +11,34 @@ import (
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 type metricsServer struct {
 	proto2.UnimplementedMetricsServer
 
-	repo repo.Repository
-	fs   services.FileStore
-	log  zerolog.Logger
+	repo     repo.Repository
+	fs       services.FileStore
+	counters *services.CounterTracker
+	log      zerolog.Logger
 }
 
-func newMetricsServer(repo repo.Repository, fs services.FileStore, log zerolog.Logger) *metricsServer {
+// NewMetricsServer creates the implementation of the Metrics service. It is served
+// by the gRPC server and by the REST gateway of the HTTP server. Reported counters
+// are converted to increments by the tracker.
+func NewMetricsServer(
+	repo repo.Repository,
+	fs services.FileStore,
+	counters *services.CounterTracker,
+	log zerolog.Logger,
+) proto2.MetricsServer {
 	return &metricsServer{
-		repo: repo,
-		fs:   fs,
-		log:  log,
+		repo:     repo,
+		fs:       fs,
+		counters: counters,
+		log:      log,
 	}
 }
 
@@ -48,11 +62,31 @@ func (s *metricsServer) UpdateMetric(ctx context.Context, in *proto2.UpdateMetri
 		return nil, status.Error(codes.InvalidArgument, "invalid metric type")
 	}
 
-	err := s.repo.SetVal(ctx, in.Metric.Id, models.Metric{Type: in.Metric.Type, Val: in.Metric.Value})
+	// Counters are stored as integers, as the HTTP server stores them
+	var (
+		val any = in.Metric.Value
+		u   services.CounterUpdate
+		err error
+	)
+	if in.Metric.Type == "counter" {
+		u, err = s.counters.Observe(source(ctx, in.Metric.Source), in.Metric.Id, in.Metric.Temporality,
+			int64(in.Metric.Value), time.Now())
+		if err != nil {
+			logEntry.Error().Err(err).Msg("invalid counter")
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		val = u.Increment
+	}
+
+	err = s.repo.SetVal(ctx, in.Metric.Id, models.Metric{Type: in.Metric.Type, Val: val})
 	if err != nil {
+		s.counters.Cancel(u)
 		logEntry.Error().Err(err).Msg("failed to set metric")
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	if in.Metric.Type == "counter" {
+		s.counters.Commit(u)
+	}
 
 	if s.fs != nil {
 		err = s.fs.Save(s.repo)
@@ -66,6 +100,27 @@ func (s *metricsServer) UpdateMetric(ctx context.Context, in *proto2.UpdateMetri
 	return &response, nil
 }
 
+// source returns the source of a metric, the real IP of the client or its address if the metric doesn't set it.
+func source(ctx context.Context, source string) string {
+	if source != "" {
+		return source
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("X-Real-IP"); len(v) > 0 && v[0] != "" {
+			return v[0]
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+	}
+
+	return ""
+}
+
 func (s *metricsServer) GetMetric(ctx context.Context, in *proto2.GetMetricRequest) (*proto2.GetMetricResponse, error) {
 	logEntry := s.log.With().Str("method", "GetMetric").Logger()
 
@@ -82,10 +137,21 @@ func (s *metricsServer) GetMetric(ctx context.Context, in *proto2.GetMetricReque
 		return nil, status.Error(codes.NotFound, err.Error())
 	}
 
+	var val float64
+	switch v := metric.Val.(type) {
+	case float64:
+		val = v
+	case int64:
+		val = float64(v)
+	default:
+		logEntry.Error().Msgf("unexpected value type %T", metric.Val)
+		return nil, status.Error(codes.Internal, "unexpected value type")
+	}
+
 	response.Metric = &proto2.Metric{
 		Id:    in.Id,
 		Type:  metric.Type,
-		Value: metric.Val.(float64),
+		Value: val,
 	}
 
 	return &response, nil
@@ -7,8 +7,10 @@ import (
 
 	"github.com/leonf08/metrics-yp.git/internal/models"
 	"github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/services"
 	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/mock"
 )
@@ -129,7 +131,7 @@ func TestMetricsServer_UpdateMetric(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := newMetricsServer(r, fs, zerolog.Nop())
+			s := NewMetricsServer(r, fs, services.NewCounterTracker(telemetry.NewRegistry()), zerolog.Nop())
 			_, err := s.UpdateMetric(tt.args.ctx, tt.args.in)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("UpdateMetric() error = %v, wantErr %v", err, tt.wantErr)
@@ -160,6 +162,16 @@ func TestMetricsServer_GetMetric(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "GetMetric with integer counter",
+			args: args{
+				ctx: context.Background(),
+				in: &proto.GetMetricRequest{
+					Id: "test2",
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "GetMetric with empty id",
 			args: args{
@@ -187,13 +199,16 @@ func TestMetricsServer_GetMetric(t *testing.T) {
 			if id == "test1" {
 				return models.Metric{}, errors.New("error")
 			}
+			if id == "test2" {
+				return models.Metric{Type: "counter", Val: int64(1)}, nil
+			}
 
 			return models.Metric{Type: "counter", Val: 1.0}, nil
 		})
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := newMetricsServer(r, nil, zerolog.Nop())
+			s := NewMetricsServer(r, nil, services.NewCounterTracker(telemetry.NewRegistry()), zerolog.Nop())
 			_, err := s.GetMetric(tt.args.ctx, tt.args.in)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetMetric() error = %v, wantErr %v", err, tt.wantErr)
@@ -1,64 +1,95 @@
 package grpc
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
-	"net/netip"
 
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
-	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/selector"
 	"github.com/leonf08/metrics-yp.git/internal/proto"
 	"github.com/leonf08/metrics-yp.git/internal/server/grpc/interceptors"
 	"github.com/leonf08/metrics-yp.git/internal/services"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 )
 
 type Server struct {
-	server  *grpc.Server
-	repo    repo.Repository
-	fs      services.FileStore
-	log     zerolog.Logger
-	address string
-	err     chan error
+	server   *grpc.Server
+	health   *healthServer
+	cancel   context.CancelFunc
+	repo     repo.Repository
+	fs       services.FileStore
+	counters *services.CounterTracker
+	log      zerolog.Logger
+	address  string
+	err      chan error
 }
 
-func NewServer(repo repo.Repository, fs services.FileStore, log zerolog.Logger, address, trustedSubnet string) *Server {
-	var i []grpc.UnaryServerInterceptor
-
+// NewServer creates a new server. Besides the Metrics service, the server implements
+// the standard health checking protocol and server reflection. Compressed requests
+// are accepted with gzip. The server uses TLS if the TLS configuration is not nil.
+// Calls are checked by the security interceptors if they are not nil, panics of handlers
+// are recovered from. Reported counters are converted to increments by the tracker.
+func NewServer(repo repo.Repository, fs services.FileStore, counters *services.CounterTracker, log zerolog.Logger,
+	address string, tlsConfig *tls.Config, security *interceptors.Security, reg *telemetry.Registry) *Server {
 	logOpts := []logging.Option{
 		logging.WithLogOnEvents(logging.StartCall, logging.FinishCall),
 	}
+	recoveryOpts := []recovery.Option{
+		recovery.WithRecoveryHandlerContext(interceptors.RecoveryHandler(log)),
+	}
 
-	i = append(i, logging.UnaryServerInterceptor(interceptors.InterceptorLogger(log), logOpts...))
+	i := []grpc.UnaryServerInterceptor{
+		interceptors.UnaryServerMetrics(reg),
+		logging.UnaryServerInterceptor(interceptors.InterceptorLogger(log), logOpts...),
+		recovery.UnaryServerInterceptor(recoveryOpts...),
+	}
+	si := []grpc.StreamServerInterceptor{
+		logging.StreamServerInterceptor(interceptors.InterceptorLogger(log), logOpts...),
+		recovery.StreamServerInterceptor(recoveryOpts...),
+	}
 
-	if trustedSubnet != "" {
-		trustedPeers := []netip.Prefix{
-			netip.MustParsePrefix(trustedSubnet),
-		}
+	// Health checks are not secured, load balancers check the server without signatures
+	if security != nil {
+		secured := selector.MatchFunc(func(_ context.Context, c middleware.CallMeta) bool {
+			return c.Service != healthpb.Health_ServiceDesc.ServiceName
+		})
 
-		headers := []string{realip.XRealIp}
-		ipOpts := []realip.Option{
-			realip.WithTrustedPeers(trustedPeers),
-			realip.WithHeaders(headers),
-		}
+		i = append(i, selector.UnaryServerInterceptor(security.UnaryServerInterceptor(), secured))
+		si = append(si, selector.StreamServerInterceptor(security.StreamServerInterceptor(), secured))
+	}
 
-		i = append(i, realip.UnaryServerInterceptorOpts(ipOpts...))
+	serverOpts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(i...), grpc.ChainStreamInterceptor(si...)}
+	if tlsConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
 
-	serverOpt := grpc.ChainUnaryInterceptor(i...)
+	sg := grpc.NewServer(serverOpts...)
 
-	sg := grpc.NewServer(serverOpt)
+	ctx, cancel := context.WithCancel(context.Background())
 
 	s := &Server{
-		server:  sg,
-		repo:    repo,
-		fs:      fs,
-		log:     log,
-		address: address,
-		err:     make(chan error, 1),
+		server:   sg,
+		health:   newHealthServer(repo),
+		cancel:   cancel,
+		repo:     repo,
+		fs:       fs,
+		counters: counters,
+		log:      log,
+		address:  address,
+		err:      make(chan error, 1),
 	}
 
+	go s.health.run(ctx)
 	go s.start()
 
 	return s
@@ -72,7 +103,9 @@ func (s *Server) start() {
 		return
 	}
 
-	proto.RegisterMetricsServer(s.server, newMetricsServer(s.repo, s.fs, s.log))
+	proto.RegisterMetricsServer(s.server, NewMetricsServer(s.repo, s.fs, s.counters, s.log))
+	healthpb.RegisterHealthServer(s.server, s.health)
+	reflection.Register(s.server)
 	s.err <- s.server.Serve(listener)
 	close(s.err)
 }
@@ -81,6 +114,9 @@ func (s *Server) Err() <-chan error {
 	return s.err
 }
 
+// Shutdown reports the server as not serving to health checks and stops it gracefully.
 func (s *Server) Shutdown() {
+	s.cancel()
+	s.health.Shutdown()
 	s.server.GracefulStop()
 }
@@ -5,17 +5,17 @@ import (
 
 	"github.com/leonf08/metrics-yp.git/internal/services"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestNewServer(t *testing.T) {
 	type args struct {
-		repo          repo.Repository
-		fs            services.FileStore
-		log           zerolog.Logger
-		address       string
-		trustedSubnet string
+		repo    repo.Repository
+		fs      services.FileStore
+		log     zerolog.Logger
+		address string
 	}
 	tests := []struct {
 		name string
@@ -25,17 +25,17 @@ func TestNewServer(t *testing.T) {
 		{
 			name: "Test 1",
 			args: args{
-				repo:          &repo.MemStorage{},
-				fs:            &services.FileStorage{},
-				log:           zerolog.Nop(),
-				address:       "localhost:8080",
-				trustedSubnet: "",
+				repo:    &repo.MemStorage{},
+				fs:      &services.FileStorage{},
+				log:     zerolog.Nop(),
+				address: "localhost:8080",
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewServer(tt.args.repo, tt.args.fs, tt.args.log, tt.args.address, tt.args.trustedSubnet)
+			s := NewServer(tt.args.repo, tt.args.fs, services.NewCounterTracker(telemetry.NewRegistry()), tt.args.log, tt.args.address, nil, nil,
+				telemetry.NewRegistry())
 
 			assert.NotNil(t, s.server)
 			assert.NotNil(t, s.repo)
@@ -45,7 +45,8 @@ func TestNewServer(t *testing.T) {
 }
 
 func TestServer_Err(t *testing.T) {
-	s := NewServer(&repo.MemStorage{}, &services.FileStorage{}, zerolog.Nop(), "localhost:8080", "")
+	s := NewServer(&repo.MemStorage{}, &services.FileStorage{}, services.NewCounterTracker(telemetry.NewRegistry()), zerolog.Nop(),
+		"localhost:8080", nil, nil, telemetry.NewRegistry())
 
 	assert.NotNil(t, s.Err())
 }
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/leonf08/metrics-yp.git/internal/proto"
+	"github.com/leonf08/metrics-yp.git/internal/server/grpc"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// gatewayPrefix is the prefix of the routes of the REST gateway.
+const gatewayPrefix = "/api/v1/"
+
+// newGateway creates the REST gateway of the Metrics service. Routes are defined
+// by the annotations of the service in metrics.proto. Calls are served in-process
+// by the same implementation as the gRPC server, behind the middleware of the router.
+func newGateway(
+	repo repo.Repository,
+	fs services.FileStore,
+	counters *services.CounterTracker,
+	l zerolog.Logger,
+) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}),
+		// The real IP of the client is the source of counters which don't set it
+		runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+			if strings.EqualFold(key, "X-Real-IP") {
+				return key, true
+			}
+
+			return runtime.DefaultHeaderMatcher(key)
+		}),
+	)
+
+	err := proto.RegisterMetricsHandlerServer(context.Background(), mux, grpc.NewMetricsServer(repo, fs, counters, l))
+	if err != nil {
+		return nil, err
+	}
+
+	return mux, nil
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGateway(t *testing.T) {
+	reg := telemetry.NewRegistry()
+	r := NewRouter(nil, nil, false, nil, repo.NewStorage(), nil, services.NewCounterTracker(reg), nil, nil, nil, reg, zerolog.Nop())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "update counter",
+			method:   http.MethodPost,
+			path:     "/api/v1/metrics",
+			body:     `{"id":"PollCount","type":"counter","value":2}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"PollCount","type":"counter","value":2,"temporality":"","source":""}`,
+		},
+		{
+			name:     "update counter again",
+			method:   http.MethodPost,
+			path:     "/api/v1/metrics",
+			body:     `{"id":"PollCount","type":"counter","value":3}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"PollCount","type":"counter","value":3,"temporality":"","source":""}`,
+		},
+		{
+			name:     "get counter",
+			method:   http.MethodGet,
+			path:     "/api/v1/metrics/PollCount",
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"PollCount","type":"counter","value":5,"temporality":"","source":""}`,
+		},
+		{
+			name:     "update cumulative counter",
+			method:   http.MethodPost,
+			path:     "/api/v1/metrics",
+			body:     `{"id":"PollCount","type":"counter","value":10,"temporality":"cumulative","source":"agent"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "update cumulative counter again",
+			method:   http.MethodPost,
+			path:     "/api/v1/metrics",
+			body:     `{"id":"PollCount","type":"counter","value":12,"temporality":"cumulative","source":"agent"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "get counter with increments of cumulative values",
+			method:   http.MethodGet,
+			path:     "/api/v1/metrics/PollCount",
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"PollCount","type":"counter","value":7,"temporality":"","source":""}`,
+		},
+		{
+			name:     "invalid temporality",
+			method:   http.MethodPost,
+			path:     "/api/v1/metrics",
+			body:     `{"id":"PollCount","type":"counter","value":1,"temporality":"total"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "update gauge",
+			method:   http.MethodPost,
+			path:     "/api/v1/metrics",
+			body:     `{"id":"Alloc","type":"gauge","value":1.5}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"Alloc","type":"gauge","value":1.5,"temporality":"","source":""}`,
+		},
+		{
+			name:     "invalid type",
+			method:   http.MethodPost,
+			path:     "/api/v1/metrics",
+			body:     `{"id":"Alloc","type":"histogram","value":1}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown metric",
+			method:   http.MethodGet,
+			path:     "/api/v1/metrics/Unknown",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().SetBody(tt.body).Execute(tt.method, ts.URL+tt.path)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode())
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, string(resp.Body()))
+			}
+		})
+	}
+}
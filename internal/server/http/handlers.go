@@ -2,29 +2,53 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/leonf08/metrics-yp.git/internal/models"
 	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/query"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
 	"github.com/rs/zerolog"
 )
 
+// rateJSON is the rate of a counter per second.
+type rateJSON struct {
+	ID   string  `json:"id"`
+	Rate float64 `json:"rate"`
+}
+
 type handler struct {
-	repo repo.Repository
-	fs   services.FileStore
-	log  zerolog.Logger
+	repo     repo.Repository
+	fs       services.FileStore
+	counters *services.CounterTracker
+	history  *services.HistoryQuery
+	engine   *query.Engine
+	log      zerolog.Logger
 }
 
-func newHandler(r *chi.Mux, repo repo.Repository, fs services.FileStore, l zerolog.Logger) {
+func newHandler(
+	r *chi.Mux,
+	repo repo.Repository,
+	fs services.FileStore,
+	counters *services.CounterTracker,
+	history *services.HistoryQuery,
+	engine *query.Engine,
+	l zerolog.Logger,
+) {
 	h := handler{
-		repo: repo,
-		fs:   fs,
-		log:  l,
+		repo:     repo,
+		fs:       fs,
+		counters: counters,
+		history:  history,
+		engine:   engine,
+		log:      l,
 	}
 
 	r.Get("/", h.defaultHandler)
@@ -39,6 +63,17 @@ func newHandler(r *chi.Mux, repo repo.Repository, fs services.FileStore, l zerol
 		r.Post("/", h.updateMetricJSON)
 		r.Post("/{type}/{name}/{val}", h.updateMetric)
 	})
+	r.Get("/api/rates", h.getRates)
+
+	// The history is served only if it is kept
+	if history != nil {
+		r.Get("/api/history/{name}", h.getHistory)
+	}
+
+	if engine != nil {
+		r.Get("/api/query", h.getQuery)
+		r.Get("/api/query_range", h.getQueryRange)
+	}
 }
 
 // getMetric handles GET requests to /value/{type}/{name} endpoint to get metric value.
@@ -88,7 +123,8 @@ func (h handler) getMetric(w http.ResponseWriter, r *http.Request) {
 }
 
 // updateMetric handles POST requests to /update/{type}/{name}/{val} endpoint to update metric value.
-// Type, name and value of metric are passed as URL parameters.
+// Type, name and value of metric are passed as URL parameters. The temporality and the source
+// of a counter may be passed as query parameters.
 func (h handler) updateMetric(w http.ResponseWriter, r *http.Request) {
 	logEntry := h.log.With().Str("component", "handler/updateMetric").Logger()
 
@@ -117,11 +153,26 @@ func (h handler) updateMetric(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err = h.repo.SetVal(r.Context(), name, models.Metric{Type: "counter", Val: v}); err != nil {
+		q := r.URL.Query()
+		u, err := h.observeCounter(r, models.MetricJSON{
+			ID:          name,
+			Delta:       &v,
+			Temporality: q.Get("temporality"),
+			Source:      q.Get("source"),
+		})
+		if err != nil {
+			logEntry.Error().Err(err).Msg("Observe")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err = h.repo.SetVal(r.Context(), name, models.Metric{Type: "counter", Val: u.Increment}); err != nil {
+			h.counters.Cancel(u)
 			logEntry.Error().Err(err).Msg("SetVal")
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
+		h.counters.Commit(u)
 	default:
 		logEntry.Error().Msg("invalid metric type")
 		http.Error(w, "invalid metric type", http.StatusBadRequest)
@@ -247,7 +298,11 @@ func (h handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var v any
+	var (
+		v   any
+		u   services.CounterUpdate
+		err error
+	)
 	switch metric.MType {
 	case "gauge":
 		if metric.Value == nil {
@@ -262,18 +317,28 @@ func (h handler) updateMetricJSON(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid metric type", http.StatusBadRequest)
 			return
 		}
-		v = *(metric.Delta)
+
+		if u, err = h.observeCounter(r, metric); err != nil {
+			logEntry.Error().Err(err).Msg("Observe")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v = u.Increment
 	default:
 		logEntry.Error().Msg("invalid metric type")
 		http.Error(w, "invalid metric type", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.repo.SetVal(r.Context(), metric.ID, models.Metric{Type: metric.MType, Val: v}); err != nil {
+	if err = h.repo.SetVal(r.Context(), metric.ID, models.Metric{Type: metric.MType, Val: v}); err != nil {
+		h.counters.Cancel(u)
 		logEntry.Error().Err(err).Msg("SetVal")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if metric.MType == "counter" {
+		h.counters.Commit(u)
+	}
 
 	if h.fs != nil {
 		logEntry.Info().Msg("Save metrics to file")
@@ -306,7 +371,14 @@ func (h handler) updateMetricsBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Duplicates of a cumulative counter are observed one after another,
+	// so each of them is counted since the previous one
 	metricsDB := make([]models.MetricDB, len(metrics))
+	updates := make([]services.CounterUpdate, 0, len(metrics))
+	defer func() {
+		// Reservations of updates which are not committed are released
+		h.counters.Cancel(updates...)
+	}()
 	for i, v := range metrics {
 		metricsDB[i].Name = v.ID
 		metricsDB[i].Type = v.MType
@@ -324,7 +396,15 @@ func (h handler) updateMetricsBatch(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "invalid metric type", http.StatusBadRequest)
 				return
 			}
-			metricsDB[i].Val = *v.Delta
+
+			u, err := h.observeCounter(r, v)
+			if err != nil {
+				logEntry.Error().Err(err).Msg("Observe")
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			metricsDB[i].Val = u.Increment
+			updates = append(updates, u)
 		default:
 			logEntry.Error().Msg("invalid metric type")
 			http.Error(w, "invalid metric type", http.StatusBadRequest)
@@ -334,9 +414,15 @@ func (h handler) updateMetricsBatch(w http.ResponseWriter, r *http.Request) {
 
 	if err := h.repo.Update(r.Context(), metricsDB); err != nil {
 		logEntry.Error().Err(err).Msg("Update")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Is(err, repo.ErrTypeMismatch) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
+	h.counters.Commit(updates...)
+	updates = nil
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -347,6 +433,38 @@ func (h handler) updateMetricsBatch(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// observeCounter computes the increment of the counter of the request. Cumulative values are
+// tracked per source, the real IP of the client is the source if the metric doesn't set it.
+func (h handler) observeCounter(r *http.Request, m models.MetricJSON) (services.CounterUpdate, error) {
+	source := m.Source
+	if source == "" {
+		source = r.Header.Get("X-Real-IP")
+	}
+	if source == "" {
+		source, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+
+	return h.counters.Observe(source, m.ID, m.Temporality, *m.Delta, time.Now())
+}
+
+// getRates handles GET requests to /api/rates endpoint to get rates of counters per second.
+// Response contains rates of counters reported in the last five minutes in JSON format.
+func (h handler) getRates(w http.ResponseWriter, _ *http.Request) {
+	logEntry := h.log.With().Str("component", "handler/getRates").Logger()
+
+	rates := h.counters.Rates(time.Now())
+	resp := make([]rateJSON, 0, len(rates))
+	for _, r := range rates {
+		resp = append(resp, rateJSON{ID: r.Name, Rate: r.Rate})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logEntry.Error().Err(err).Msg("Encode")
+	}
+}
+
 // pingDB handles GET requests to /ping endpoint to check DB connection.
 func (h handler) pingDB(w http.ResponseWriter, _ *http.Request) {
 	logEntry := h.log.With().Str("component", "handler/pingDB").Logger()
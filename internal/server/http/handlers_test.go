@@ -12,12 +12,15 @@ import (
 
 	"github.com/leonf08/metrics-yp.git/internal/logger"
 	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services"
 	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -242,9 +245,10 @@ func TestUpdateMetric(t *testing.T) {
 			route := chi.NewRouter()
 
 			h := &handler{
-				repo: rp,
-				fs:   nil,
-				log:  zerolog.Logger{},
+				repo:     rp,
+				fs:       nil,
+				counters: services.NewCounterTracker(telemetry.NewRegistry()),
+				log:      zerolog.Logger{},
 			}
 
 			route.Post("/update/{type}/{name}/{val}", h.updateMetric)
@@ -642,9 +646,10 @@ func TestUpdateMetricJSON(t *testing.T) {
 			route := chi.NewRouter()
 
 			h := &handler{
-				repo: rp,
-				fs:   fs,
-				log:  zerolog.Logger{},
+				repo:     rp,
+				fs:       fs,
+				counters: services.NewCounterTracker(telemetry.NewRegistry()),
+				log:      zerolog.Logger{},
 			}
 
 			route.Route("/update", func(r chi.Router) {
@@ -755,6 +760,18 @@ func TestUpdateMetricsBatch(t *testing.T) {
 				body:        "",
 			},
 		},
+		{
+			name:    "test 7, update Metrics by batch, different types of a metric",
+			method:  http.MethodPost,
+			request: "/updates/",
+			body: `[{"id": "Metric4", "type": "gauge", "value": 2.5},
+			{"id": "Metric4", "type": "counter", "delta": 3}]`,
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "text/plain; charset=utf-8",
+				body:        "",
+			},
+		},
 	}
 
 	rp.On("Update", mock.Anything, mock.Anything).
@@ -763,6 +780,9 @@ func TestUpdateMetricsBatch(t *testing.T) {
 			if m[0].Name == "Metric3" {
 				return fmt.Errorf("error")
 			}
+			if m[0].Name == "Metric4" {
+				return fmt.Errorf("metric Metric4: %w", repo.ErrTypeMismatch)
+			}
 
 			return nil
 		})
@@ -772,9 +792,10 @@ func TestUpdateMetricsBatch(t *testing.T) {
 			route := chi.NewRouter()
 
 			h := &handler{
-				repo: rp,
-				fs:   nil,
-				log:  zerolog.Logger{},
+				repo:     rp,
+				fs:       nil,
+				counters: services.NewCounterTracker(telemetry.NewRegistry()),
+				log:      zerolog.Logger{},
 			}
 
 			route.Post("/updates/", h.updateMetricsBatch)
@@ -900,3 +921,51 @@ func Example() {
 
 	log.Fatal().Err(http.ListenAndServe(":8080", nil)).Msg("")
 }
+
+func TestCumulativeCounters(t *testing.T) {
+	reg := telemetry.NewRegistry()
+	st := repo.NewStorage()
+	r := NewRouter(nil, nil, false, nil, st, nil, services.NewCounterTracker(reg), nil, nil, nil, reg, zerolog.Nop())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	client := resty.New().SetHeader("X-Real-IP", "10.0.0.1")
+
+	// The first report is the baseline of the source, the agent is restarted after the second one.
+	// The source is the real IP in query mode
+	for _, v := range []string{"10", "15", "4"} {
+		resp, err := client.R().
+			SetQueryParam("temporality", models.TemporalityCumulative).
+			Post(ts.URL + "/update/counter/PollCount/" + v)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+	}
+
+	resp, err := client.R().
+		SetBody(`[{"id":"PollCount","type":"counter","delta":9,"temporality":"cumulative","source":"10.0.0.1"},
+			{"id":"PollCount","type":"counter","delta":11,"temporality":"cumulative","source":"10.0.0.1"}]`).
+		Post(ts.URL + "/updates/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	m, err := st.GetVal(context.Background(), "PollCount")
+	require.NoError(t, err)
+	// Duplicates in the batch are counted one after another: 5 + 4 + 5 + 2
+	assert.Equal(t, int64(16), m.Val)
+
+	resp, err = client.R().
+		SetBody(`{"id":"PollCount","type":"counter","delta":1,"temporality":"total"}`).
+		Post(ts.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	resp, err = client.R().SetResult(&[]rateJSON{}).Get(ts.URL + "/api/rates")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+
+	rates := *resp.Result().(*[]rateJSON)
+	require.Len(t, rates, 1)
+	assert.Equal(t, "PollCount", rates[0].ID)
+	assert.Equal(t, int64(1), reg.Counter(telemetry.ServerPrefix+"counter_resets_total", nil).Value())
+}
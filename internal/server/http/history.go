@@ -0,0 +1,141 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/leonf08/metrics-yp.git/internal/config/duration"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+)
+
+// defaultHistoryRange is the range of the history returned if the start isn't given.
+const defaultHistoryRange = time.Hour
+
+type (
+	// historyJSON is the history of a metric in JSON format. Points are raw samples,
+	// rollups of gauges or rollups of counters depending on the resolution and the type.
+	historyJSON struct {
+		Name       string `json:"name"`
+		Type       string `json:"type,omitempty"`
+		Resolution string `json:"resolution"`
+		Points     []any  `json:"points"`
+	}
+
+	samplePointJSON struct {
+		Time  time.Time `json:"time"`
+		Value float64   `json:"value"`
+	}
+
+	gaugePointJSON struct {
+		Time  time.Time `json:"time"`
+		Count int64     `json:"count"`
+		Min   float64   `json:"min"`
+		Max   float64   `json:"max"`
+		Avg   float64   `json:"avg"`
+		Last  float64   `json:"last"`
+	}
+
+	counterPointJSON struct {
+		Time     time.Time `json:"time"`
+		Count    int64     `json:"count"`
+		Increase float64   `json:"increase"`
+		Rate     float64   `json:"rate"`
+	}
+)
+
+// getHistory handles GET requests to /api/history/{name} endpoint to get the history of a metric.
+// The range is set by from and to query parameters as RFC 3339 times or Unix seconds,
+// by default it is the last hour. The resolution query parameter is raw or the step of rollups,
+// if it isn't set, the resolution is picked by the length of the range.
+// Response contains points of the history in JSON format.
+func (h handler) getHistory(w http.ResponseWriter, r *http.Request) {
+	logEntry := h.log.With().Str("component", "handler/getHistory").Logger()
+
+	q := r.URL.Query()
+	now := time.Now()
+
+	to, err := parseTime(q.Get("to"), now)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("to: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseTime(q.Get("from"), to.Add(-defaultHistoryRange))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("from: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	var resolution time.Duration
+	switch res := q.Get("resolution"); res {
+	case "":
+		resolution = h.history.Pick(from, to)
+	case "raw":
+	default:
+		if resolution, err = duration.Parse(res); err != nil {
+			http.Error(w, fmt.Sprintf("resolution: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	series, err := h.history.Query(r.Context(), chi.URLParam(r, "name"), from, to, resolution)
+	if errors.Is(err, services.ErrUnknownResolution) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logEntry.Error().Err(err).Msg("Query")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(newHistoryJSON(series)); err != nil {
+		logEntry.Error().Err(err).Msg("Encode")
+	}
+}
+
+func newHistoryJSON(s services.Series) historyJSON {
+	res := historyJSON{Name: s.Name, Type: s.Type, Resolution: "raw", Points: []any{}}
+	if s.Resolution == 0 {
+		for _, m := range s.Samples {
+			res.Points = append(res.Points, samplePointJSON{Time: m.Time, Value: m.Value})
+		}
+
+		return res
+	}
+
+	res.Resolution = s.Resolution.String()
+	for _, r := range s.Rollups {
+		if r.Type == "counter" {
+			res.Points = append(res.Points, counterPointJSON{Time: r.Start, Count: r.Count, Increase: r.Increase, Rate: r.Rate})
+		} else {
+			res.Points = append(res.Points, gaugePointJSON{Time: r.Start, Count: r.Count, Min: r.Min, Max: r.Max, Avg: r.Avg, Last: r.Last})
+		}
+	}
+
+	return res
+}
+
+// parseTime parses a time given as RFC 3339 or Unix seconds, the empty string is the default time.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetHistory(t *testing.T) {
+	ctx := context.Background()
+	st := repo.NewStorageWithHistory(time.Hour)
+	require.NoError(t, st.SetVal(ctx, "Alloc", models.Metric{Type: "gauge", Val: 1.5}))
+
+	start := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, st.WriteRollups(ctx, time.Hour, []models.Rollup{
+		{Name: "Alloc", Type: "gauge", Start: start, Count: 2, Min: 1, Max: 3, Avg: 2, Last: 3},
+	}))
+
+	query := services.NewHistoryQuery(st, []services.Resolution{{Step: time.Minute}, {Step: time.Hour}})
+	r := NewRouter(nil, nil, false, nil, st, nil, nil, query, nil, nil, telemetry.NewRegistry(), zerolog.Nop())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	day := "from=2026-10-19T00:00:00Z&to=" + strconv.FormatInt(start.Add(48*time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantBody string
+		wantLen  int
+	}{
+		{
+			name:     "raw samples of the last hour",
+			wantCode: http.StatusOK,
+			wantLen:  1,
+		},
+		{
+			name:     "resolution is picked by the range",
+			query:    day,
+			wantCode: http.StatusOK,
+			wantBody: `{"name":"Alloc","type":"gauge","resolution":"1h0m0s","points":[` +
+				`{"time":"2026-10-19T00:00:00Z","count":2,"min":1,"max":3,"avg":2,"last":3}]}`,
+		},
+		{
+			name:     "explicit resolution",
+			query:    day + "&resolution=1m",
+			wantCode: http.StatusOK,
+			wantBody: `{"name":"Alloc","resolution":"1m0s","points":[]}`,
+		},
+		{
+			name:     "unknown resolution",
+			query:    day + "&resolution=1d",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid range",
+			query:    "from=2026-10-19T00:00:00Z&to=2026-10-18T00:00:00Z",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid time",
+			query:    "from=yesterday",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().SetQueryString(tt.query).Get(ts.URL + "/api/history/Alloc")
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode())
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, string(resp.Body()))
+			}
+			if tt.wantLen > 0 {
+				var h historyJSON
+				require.NoError(t, json.Unmarshal(resp.Body(), &h))
+				assert.Equal(t, "raw", h.Resolution)
+				assert.Len(t, h.Points, tt.wantLen)
+			}
+		})
+	}
+}
@@ -2,19 +2,32 @@ package middleware
 
 import (
 	"bytes"
-	"encoding/hex"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/services"
 )
 
-// Auth is a middleware that checks the hash of the request body.
-func Auth(s *services.HashSigner) func(next http.Handler) http.Handler {
+// Auth is a middleware that checks the hash of the request body. The hash of requests
+// without a body covers the method and the request target instead, see services.RequestPayload.
+// The hash of requests with the timestamp and the nonce headers covers them as well,
+// the timestamp and the nonce are checked by the guard if it is not nil.
+// The request is verified with the key selected by the KeyID header,
+// the response is signed with the same key.
+//
+// In strict mode write requests without the hash are rejected with 401,
+// otherwise they pass unauthenticated.
+func Auth(s *services.HashSigner, g *services.ReplayGuard, strict bool) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			aw := w
-			hashReq := r.Header.Get("HashSHA256")
+			hashReq := r.Header.Get(services.HeaderHash)
+			if s != nil && hashReq == "" && strict && isWrite(r.Method) {
+				http.Error(w, "request is not signed", http.StatusUnauthorized)
+				return
+			}
+
 			if s != nil && hashReq != "" {
 				body, err := io.ReadAll(r.Body)
 				if err != nil {
@@ -22,25 +35,20 @@ func Auth(s *services.HashSigner) func(next http.Handler) http.Handler {
 					return
 				}
 
-				calcHash, err := s.CalcHash(body)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				getHash, err := hex.DecodeString(hashReq)
+				payload := services.RequestPayload(r.Method, r.RequestURI, body)
+				ks, err := s.Verify(payload, services.Signature{
+					Hash:      hashReq,
+					Timestamp: r.Header.Get(services.HeaderTimestamp),
+					Nonce:     r.Header.Get(services.HeaderNonce),
+					KeyID:     r.Header.Get(services.HeaderKeyID),
+				}, g, time.Now())
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-
-				if ok := s.CheckHash(calcHash, getHash); !ok {
-					http.Error(w, "invalid hash", http.StatusBadRequest)
+					http.Error(w, err.Error(), http.StatusBadRequest)
 					return
 				}
 
-				s.ResponseWriter = w
-				aw = s
+				ks.ResponseWriter = w
+				aw = ks
 				r.Body = io.NopCloser(bytes.NewReader(body))
 			}
 
@@ -48,3 +56,12 @@ func Auth(s *services.HashSigner) func(next http.Handler) http.Handler {
 		})
 	}
 }
+
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-resty/resty/v2"
@@ -36,7 +37,7 @@ func TestAuth(t *testing.T) {
 	require.NoError(t, err)
 
 	r := chi.NewRouter()
-	r.Use(Auth(s))
+	r.Use(Auth(s, nil, false))
 
 	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -60,3 +61,232 @@ func TestAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestAuth_ReplayGuard(t *testing.T) {
+	s := services.NewHashSigner("test")
+	g := services.NewReplayGuard(time.Minute, false, services.NewNonceCache())
+
+	r := chi.NewRouter()
+	r.Use(Auth(s, g, false))
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	signed, err := s.SignatureHeaders([]byte("test"), time.Now())
+	require.NoError(t, err)
+
+	stale, err := s.SignatureHeaders([]byte("test"), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+
+	legacy, err := s.CalcHash([]byte("test"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "signed request",
+			headers:        signed,
+			body:           "test",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "replayed request",
+			headers:        signed,
+			body:           "test",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "stale request",
+			headers:        stale,
+			body:           "test",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "tampered timestamp",
+			headers: map[string]string{
+				services.HeaderHash:      signed[services.HeaderHash],
+				services.HeaderTimestamp: "1",
+				services.HeaderNonce:     signed[services.HeaderNonce],
+			},
+			body:           "test",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "legacy request",
+			headers:        map[string]string{services.HeaderHash: hex.EncodeToString(legacy)},
+			body:           "test",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().SetHeaders(tt.headers).SetBody(tt.body).Post(ts.URL)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode())
+		})
+	}
+}
+
+func TestAuth_KeyID(t *testing.T) {
+	s := services.NewHashSignerWithKeys(
+		services.SigningKey{ID: "v1", Key: "old"},
+		services.SigningKey{ID: "v2", Key: "new"},
+	)
+
+	r := chi.NewRouter()
+	r.Use(Auth(s, nil, false))
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		id             string
+		key            string
+		expectedStatus int
+	}{
+		{name: "old key", id: "v1", key: "old", expectedStatus: http.StatusOK},
+		{name: "new key", id: "v2", key: "new", expectedStatus: http.StatusOK},
+		{name: "key of another id", id: "v1", key: "new", expectedStatus: http.StatusBadRequest},
+		{name: "unknown id", id: "v3", key: "new", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, err := services.NewHashSigner(tt.key).SignatureHeaders([]byte("test"), time.Now())
+			require.NoError(t, err)
+
+			resp, err := resty.New().R().SetHeaders(headers).SetHeader(services.HeaderKeyID, tt.id).
+				SetBody("test").Post(ts.URL)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode())
+
+			if tt.expectedStatus == http.StatusOK {
+				// The response is signed with the key the request is verified with
+				hash, err := services.NewHashSigner(tt.key).CalcHash([]byte("ok"))
+				require.NoError(t, err)
+				assert.Equal(t, hex.EncodeToString(hash), resp.Header().Get(services.HeaderHash))
+			}
+		})
+	}
+}
+
+func TestAuth_Strict(t *testing.T) {
+	tests := []struct {
+		name           string
+		signer         *services.HashSigner
+		method         string
+		sign           bool
+		expectedStatus int
+	}{
+		{
+			name:           "signed write",
+			signer:         services.NewHashSigner("test"),
+			method:         http.MethodPost,
+			sign:           true,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unsigned write",
+			signer:         services.NewHashSigner("test"),
+			method:         http.MethodPost,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "unsigned read",
+			signer:         services.NewHashSigner("test"),
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unsigned write without key",
+			method:         http.MethodPost,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := chi.NewRouter()
+			r.Use(Auth(tt.signer, nil, true))
+			r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			req := resty.New().R().SetBody("test")
+			if tt.sign {
+				headers, err := tt.signer.SignatureHeaders([]byte("test"), time.Now())
+				require.NoError(t, err)
+				req.SetHeaders(headers)
+			}
+
+			resp, err := req.Execute(tt.method, ts.URL)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode())
+		})
+	}
+}
+
+func TestAuth_RequestTarget(t *testing.T) {
+	tests := []struct {
+		name           string
+		signed         string
+		target         string
+		expectedStatus int
+	}{
+		{
+			name:           "signed target",
+			signed:         "/update/gauge/Alloc/1.5?source=a",
+			target:         "/update/gauge/Alloc/1.5?source=a",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "tampered path",
+			signed:         "/update/gauge/Alloc/1.5?source=a",
+			target:         "/update/gauge/Alloc/2.5?source=a",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "tampered query",
+			signed:         "/update/gauge/Alloc/1.5?source=a",
+			target:         "/update/gauge/Alloc/1.5?source=b",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	s := services.NewHashSigner("test")
+
+	r := chi.NewRouter()
+	r.Use(Auth(s, nil, true))
+	r.Post("/update/{type}/{name}/{value}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, err := s.SignatureHeaders(services.RequestPayload(http.MethodPost, tt.signed, nil), time.Now())
+			require.NoError(t, err)
+
+			resp, err := resty.New().R().SetHeaders(headers).Post(ts.URL + tt.target)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode())
+		})
+	}
+}
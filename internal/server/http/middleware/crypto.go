@@ -4,29 +4,45 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/services"
 )
 
+// Crypto is a middleware that decrypts the request body. If keys of the crypto
+// are selected by their IDs, the key is selected by the CryptoKeyID header.
+// Reads and requests without a body, such as updates in the URL, pass as they are.
 func Crypto(cr services.Crypto) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if cr != nil {
-				body, err := io.ReadAll(r.Body)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
+			if cr == nil || !isWrite(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if len(body) > 0 {
+				dec := cr
+				if kc, ok := cr.(services.KeyedCrypto); ok {
+					dec, err = kc.ForKey(r.Header.Get(services.HeaderCryptoKeyID), time.Now())
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
 				}
 
-				decBody, err := cr.Decrypt(body)
-				if err != nil {
+				if body, err = dec.Decrypt(body); err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
-
-				r.Body = io.NopCloser(bytes.NewReader(decBody))
 			}
 
+			r.Body = io.NopCloser(bytes.NewReader(body))
 			next.ServeHTTP(w, r)
 		})
 	}
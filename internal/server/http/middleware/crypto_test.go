@@ -61,3 +61,30 @@ func TestCrypto(t *testing.T) {
 		})
 	}
 }
+
+func TestCrypto_NoBody(t *testing.T) {
+	// Decrypt is not expected to be called
+	crypto := mocks.NewCrypto(t)
+
+	r := chi.NewRouter()
+	r.Use(Crypto(crypto))
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			req, err := http.NewRequest(method, ts.URL, nil)
+			require.NoError(t, err)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+)
+
+// Metrics counts requests and observes their latency per method, route and status.
+// The route is the pattern of the matched route, so requests to the same route
+// with different metric names are counted together.
+func Metrics(reg *telemetry.Registry) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			t := time.Now()
+			next.ServeHTTP(ww, r)
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			labels := map[string]string{
+				"method": r.Method,
+				"route":  route,
+				"status": strconv.Itoa(status),
+			}
+			reg.Counter(telemetry.ServerPrefix+"http_requests_total", labels).Inc()
+			reg.Histogram(telemetry.ServerPrefix+"http_request_duration_seconds", labels, nil).
+				Observe(time.Since(t).Seconds())
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := telemetry.NewRegistry()
+
+	r := chi.NewRouter()
+	r.Use(Metrics(reg))
+
+	r.Get("/value/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, url := range []string{"/value/gauge/a", "/value/gauge/b"} {
+		resp, err := http.Get(ts.URL + url)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	labels := map[string]string{"method": "GET", "route": "/value/{type}/{name}", "status": "404"}
+	assert.Equal(t, int64(2), reg.Counter("server_http_requests_total", labels).Value())
+	assert.Equal(t, int64(2), reg.Histogram("server_http_request_duration_seconds", labels, nil).Count())
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/config/duration"
+	"github.com/leonf08/metrics-yp.git/internal/services/query"
+)
+
+type (
+	// queryResponseJSON is the response of the query API, it follows the format of the Prometheus HTTP API.
+	queryResponseJSON struct {
+		Status    string         `json:"status"`
+		Data      *queryDataJSON `json:"data,omitempty"`
+		ErrorType string         `json:"errorType,omitempty"`
+		Error     string         `json:"error,omitempty"`
+	}
+
+	queryDataJSON struct {
+		ResultType string `json:"resultType"`
+		Result     any    `json:"result"`
+	}
+
+	vectorSampleJSON struct {
+		Metric query.Labels `json:"metric"`
+		Value  [2]any       `json:"value"`
+	}
+
+	matrixSeriesJSON struct {
+		Metric query.Labels `json:"metric"`
+		Values [][2]any     `json:"values"`
+	}
+)
+
+// getQuery handles GET requests to /api/query endpoint to evaluate an instant query.
+// The query is set by the query parameter, the time of evaluation by the time parameter
+// as an RFC 3339 time or Unix seconds. Without the time, current values of metrics are used.
+// Response contains a vector or a scalar in JSON format.
+func (h handler) getQuery(w http.ResponseWriter, r *http.Request) {
+	logEntry := h.log.With().Str("component", "handler/getQuery").Logger()
+
+	q := r.URL.Query()
+	now := time.Now()
+
+	t, err := parseTime(q.Get("time"), now)
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, fmt.Errorf("time: %w", err))
+		return
+	}
+
+	res, err := h.engine.Instant(r.Context(), q.Get("query"), t, q.Get("time") == "")
+	if err != nil {
+		logEntry.Error().Err(err).Msg("Instant")
+		writeQueryError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	data := &queryDataJSON{ResultType: "vector"}
+	if res.Scalar != nil {
+		data.ResultType, data.Result = "scalar", point(res.Time, *res.Scalar)
+	} else {
+		result := make([]vectorSampleJSON, 0, len(res.Vector))
+		for _, s := range res.Vector {
+			result = append(result, vectorSampleJSON{Metric: s.Labels, Value: point(res.Time, s.Value)})
+		}
+		data.Result = result
+	}
+
+	writeQueryResponse(w, http.StatusOK, queryResponseJSON{Status: "success", Data: data})
+}
+
+// getQueryRange handles GET requests to /api/query_range endpoint to evaluate a query over a range.
+// The query is set by the query parameter, the range by start and end parameters as RFC 3339 times
+// or Unix seconds and the step as a duration or seconds. Response contains a matrix in JSON format.
+func (h handler) getQueryRange(w http.ResponseWriter, r *http.Request) {
+	logEntry := h.log.With().Str("component", "handler/getQueryRange").Logger()
+
+	q := r.URL.Query()
+	now := time.Now()
+
+	end, err := parseTime(q.Get("end"), now)
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, fmt.Errorf("end: %w", err))
+		return
+	}
+
+	start, err := parseTime(q.Get("start"), end.Add(-defaultHistoryRange))
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, fmt.Errorf("start: %w", err))
+		return
+	}
+
+	step, err := parseStep(q.Get("step"))
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, fmt.Errorf("step: %w", err))
+		return
+	}
+
+	m, err := h.engine.Range(r.Context(), q.Get("query"), start, end, step)
+	if err != nil {
+		logEntry.Error().Err(err).Msg("Range")
+		writeQueryError(w, queryErrorStatus(err), err)
+		return
+	}
+
+	result := make([]matrixSeriesJSON, 0, len(m))
+	for _, s := range m {
+		values := make([][2]any, 0, len(s.Points))
+		for _, p := range s.Points {
+			values = append(values, point(p.T, p.V))
+		}
+		result = append(result, matrixSeriesJSON{Metric: s.Labels, Values: values})
+	}
+
+	writeQueryResponse(w, http.StatusOK, queryResponseJSON{
+		Status: "success",
+		Data:   &queryDataJSON{ResultType: "matrix", Result: result},
+	})
+}
+
+// point is a value at a time in the format of the Prometheus HTTP API: Unix seconds and the value as a string.
+func point(t time.Time, v float64) [2]any {
+	return [2]any{float64(t.UnixMilli()) / 1000, strconv.FormatFloat(v, 'f', -1, 64)}
+}
+
+// parseStep parses the step of a range query given as a duration or seconds, one minute by default.
+func parseStep(s string) (time.Duration, error) {
+	if s == "" {
+		return time.Minute, nil
+	}
+
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(sec * float64(time.Second)), nil
+	}
+
+	return duration.Parse(s)
+}
+
+func queryErrorStatus(err error) int {
+	if errors.Is(err, query.ErrInvalidQuery) {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeQueryError(w http.ResponseWriter, code int, err error) {
+	errorType := "bad_data"
+	if code == http.StatusInternalServerError {
+		errorType = "internal"
+	}
+
+	writeQueryResponse(w, code, queryResponseJSON{Status: "error", ErrorType: errorType, Error: err.Error()})
+}
+
+func writeQueryResponse(w http.ResponseWriter, code int, resp queryResponseJSON) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/query"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetQuery(t *testing.T) {
+	ctx := context.Background()
+	st := repo.NewStorageWithHistory(time.Hour)
+	require.NoError(t, st.SetVal(ctx, "TotalMemory", models.Metric{Type: "gauge", Val: 200.0}))
+	require.NoError(t, st.SetVal(ctx, "FreeMemory", models.Metric{Type: "gauge", Val: 50.0}))
+
+	r := NewRouter(nil, nil, false, nil, st, nil, nil, nil, query.NewEngine(st, services.NewHistoryQuery(st, nil)), nil, telemetry.NewRegistry(), zerolog.Nop())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		query    map[string]string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "vector",
+			path:     "/api/query",
+			query:    map[string]string{"query": "100 * (1 - FreeMemory / TotalMemory)", "time": "2026-10-19T10:00:00Z"},
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+		},
+		{
+			name:     "scalar",
+			path:     "/api/query",
+			query:    map[string]string{"query": "2 * 3", "time": "1760868000"},
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"success","data":{"resultType":"scalar","result":[1760868000,"6"]}}`,
+		},
+		{
+			name:     "invalid query",
+			path:     "/api/query",
+			query:    map[string]string{"query": "sum("},
+			wantCode: http.StatusBadRequest,
+			wantBody: `{"status":"error","errorType":"bad_data","error":"parse error at char 5: unexpected end of query"}`,
+		},
+		{
+			name:     "matrix",
+			path:     "/api/query_range",
+			query:    map[string]string{"query": "FreeMemory", "start": "1760868000", "end": "1760868060", "step": "30s"},
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+		},
+		{
+			name:     "invalid step",
+			path:     "/api/query_range",
+			query:    map[string]string{"query": "FreeMemory", "step": "often"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too many points",
+			path:     "/api/query_range",
+			query:    map[string]string{"query": "FreeMemory", "step": "0.1"},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := resty.New().R().SetQueryParams(tt.query).Get(ts.URL + tt.path)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantCode, resp.StatusCode())
+			assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, string(resp.Body()))
+			}
+		})
+	}
+
+	t.Run("current values", func(t *testing.T) {
+		resp, err := resty.New().R().
+			SetQueryParam("query", "100 * (1 - FreeMemory / TotalMemory)").
+			SetResult(&queryResponseJSON{}).
+			Get(ts.URL + "/api/query")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+
+		result := resp.Result().(*queryResponseJSON).Data.Result.([]any)
+		require.Len(t, result, 1)
+		assert.Equal(t, "75", result[0].(map[string]any)["value"].([]any)[1])
+	})
+}
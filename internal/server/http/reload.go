@@ -0,0 +1,30 @@
+package http
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// ReloadableHandler is a handler which can be replaced while the server is running.
+// Requests in flight are served by the handler they started with.
+type ReloadableHandler struct {
+	h atomic.Pointer[http.Handler]
+}
+
+// NewReloadableHandler creates a new reloadable handler.
+func NewReloadableHandler(h http.Handler) *ReloadableHandler {
+	rh := &ReloadableHandler{}
+	rh.Store(h)
+
+	return rh
+}
+
+// Store replaces the handler.
+func (rh *ReloadableHandler) Store(h http.Handler) {
+	rh.h.Store(&h)
+}
+
+// ServeHTTP serves the request with the current handler.
+func (rh *ReloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*rh.h.Load()).ServeHTTP(w, r)
+}
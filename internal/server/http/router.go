@@ -5,24 +5,43 @@ import (
 	chiMw "github.com/go-chi/chi/v5/middleware"
 	middleware2 "github.com/leonf08/metrics-yp.git/internal/server/http/middleware"
 	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/query"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/rs/zerolog"
 )
 
-// NewRouter creates a new router and adds middleware.
+// NewRouter creates a new router and adds middleware. Reported counters are converted
+// to increments by the tracker. Besides the legacy routes and rates of counters under /api/rates,
+// the router serves the REST gateway of the Metrics service under /api/v1/,
+// the history of metrics under /api/history/ if the history is not nil and the query API
+// under /api/query and /api/query_range if the engine is not nil.
 func NewRouter(
 	s *services.HashSigner,
+	g *services.ReplayGuard,
+	strict bool,
 	cr services.Crypto,
 	repo repo.Repository,
 	fs services.FileStore,
+	counters *services.CounterTracker,
+	history *services.HistoryQuery,
+	engine *query.Engine,
 	ip services.IPChecker,
+	reg *telemetry.Registry,
 	l zerolog.Logger,
 ) *chi.Mux {
 	r := chi.NewRouter()
-	r.Use(middleware2.Logging(l), middleware2.IPCheck(ip), middleware2.Auth(s),
+	r.Use(middleware2.Metrics(reg), middleware2.Logging(l), middleware2.IPCheck(ip), middleware2.Auth(s, g, strict),
 		middleware2.Crypto(cr), middleware2.Compress, chiMw.Recoverer)
 
-	newHandler(r, repo, fs, l)
+	newHandler(r, repo, fs, counters, history, engine, l)
+
+	gw, err := newGateway(repo, fs, counters, l)
+	if err != nil {
+		l.Error().Err(err).Msg("NewRouter - newGateway")
+	} else {
+		r.Handle(gatewayPrefix+"*", gw)
+	}
 
 	return r
 }
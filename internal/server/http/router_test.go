@@ -0,0 +1,79 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/query"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRouter_Crypto(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privateFile := filepath.Join(dir, "private.pem")
+	publicFile := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+	require.NoError(t, os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)}), 0o600))
+
+	st := repo.NewStorageWithHistory(time.Hour)
+	require.NoError(t, st.SetVal(context.Background(), "Alloc", models.Metric{Type: "gauge", Val: 1.5}))
+
+	history := services.NewHistoryQuery(st, nil)
+	r := NewRouter(nil, nil, false, services.NewCryptoService(privateFile), st, nil,
+		services.NewCounterTracker(telemetry.NewRegistry()), history, query.NewEngine(st, history), nil,
+		telemetry.NewRegistry(), zerolog.Nop())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Reads have no body, they are served with the crypto key set
+	for _, path := range []string{
+		"/value/gauge/Alloc",
+		"/api/rates",
+		"/api/history/Alloc",
+		"/api/query?query=Alloc",
+		"/api/query_range?query=Alloc&start=1760868000&end=1760868060&step=15",
+	} {
+		t.Run(path, func(t *testing.T) {
+			resp, err := ts.Client().Get(ts.URL + path)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+
+	// Bodies of updates are still decrypted
+	body, err := services.NewCryptoService(publicFile).Encrypt([]byte(`{"id":"Alloc","type":"gauge","value":2.5}`))
+	require.NoError(t, err)
+
+	resp, err := ts.Client().Post(ts.URL+"/update/", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	m, err := st.GetVal(context.Background(), "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, m.Val)
+}
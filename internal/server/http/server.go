@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"net/http"
 	"time"
 )
@@ -12,12 +13,14 @@ type Server struct {
 	err    chan error
 }
 
-// NewServer creates a new Server instance.
-func NewServer(h http.Handler, address string) *Server {
+// NewServer creates a new Server instance. The server serves HTTPS
+// if the TLS configuration is not nil.
+func NewServer(h http.Handler, address string, tlsConfig *tls.Config) *Server {
 	s := &Server{
 		server: &http.Server{
-			Addr:    address,
-			Handler: h,
+			Addr:      address,
+			Handler:   h,
+			TLSConfig: tlsConfig,
 		},
 		err: make(chan error, 1),
 	}
@@ -29,7 +32,12 @@ func NewServer(h http.Handler, address string) *Server {
 
 func (s *Server) start() {
 	go func() {
-		s.err <- s.server.ListenAndServe()
+		if s.server.TLSConfig != nil {
+			// Certificates are provided by the TLS configuration
+			s.err <- s.server.ListenAndServeTLS("", "")
+		} else {
+			s.err <- s.server.ListenAndServe()
+		}
 		close(s.err)
 	}()
 }
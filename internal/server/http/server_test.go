@@ -27,7 +27,7 @@ func TestNewServer(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewServer(tt.args.h, tt.args.address)
+			s := NewServer(tt.args.h, tt.args.address, nil)
 
 			assert.Equal(t, tt.args.address, s.server.Addr)
 			assert.NotNil(t, s.server.Handler)
@@ -37,13 +37,13 @@ func TestNewServer(t *testing.T) {
 }
 
 func TestServer_Err(t *testing.T) {
-	s := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "localhost:8080")
+	s := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "localhost:8080", nil)
 
 	assert.NotNil(t, s.Err())
 }
 
 func TestServer_Shutdown(t *testing.T) {
-	s := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "localhost:8080")
+	s := NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "localhost:8080", nil)
 
 	assert.Nil(t, s.Shutdown())
 }
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,20 +17,52 @@ import (
 )
 
 // AgentService is a service for gathering and reporting metrics.
+// Counters are reported as cumulative values, the latest values are reported every time.
+// Collectors return increments of counters whatever temporality their sources use,
+// the increments are added to the counters kept by the agent, so every counter
+// is reported as the cumulative value of the agent.
 type AgentService struct {
-	mode string
-	repo repo.Repository
+	mode       string
+	source     string
+	repo       repo.Repository
+	collectors []Collector
+	filter     *MetricFilter
 }
 
 // NewAgentService creates a new agent service.
-func NewAgentService(mode string, repo repo.Repository) *AgentService {
+// Collectors are additional sources of metrics queried on every gathering.
+func NewAgentService(mode string, repo repo.Repository, collectors ...Collector) *AgentService {
 	return &AgentService{
-		mode: mode,
-		repo: repo,
+		mode:       mode,
+		source:     AgentSource(),
+		repo:       repo,
+		collectors: collectors,
+	}
+}
+
+// AgentSource returns the source of counters reported by the agent, the host name.
+// The server tracks cumulative values of counters per source.
+func AgentSource() string {
+	host, _ := os.Hostname()
+	return host
+}
+
+// ForOutput creates an agent service reporting metrics of the same storage
+// in the given mode. Only metrics selected by the filter are reported.
+// The created service has no collectors, metrics are expected to be gathered
+// by the original service.
+func (a *AgentService) ForOutput(mode string, filter *MetricFilter) *AgentService {
+	return &AgentService{
+		mode:   mode,
+		source: a.source,
+		repo:   a.repo,
+		filter: filter,
 	}
 }
 
 // GatherMetrics gathers metrics. It reads memory stats and updates metrics in the storage.
+// Then it queries collectors and adds their metrics to the storage. Errors of collectors
+// don't stop gathering, they are joined and returned after all collectors are queried.
 func (a *AgentService) GatherMetrics(ctx context.Context) error {
 	var memStats runtime.MemStats
 
@@ -57,7 +90,21 @@ func (a *AgentService) GatherMetrics(ctx context.Context) error {
 		}
 	}
 
-	return nil
+	var errs []error
+	for _, col := range a.collectors {
+		metrics, err := col.Collect(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for k, v := range metrics {
+			if err = a.repo.SetVal(ctx, k, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 // ReportMetrics processes metrics and prepares them for reporting.
@@ -76,9 +123,25 @@ func (a *AgentService) ReportMetrics(ctx context.Context) ([]string, error) {
 	}
 }
 
-// GetMetrics returns all metrics.
+// GetMetrics returns all metrics selected by the filter.
 func (a *AgentService) GetMetrics(ctx context.Context) (map[string]models.Metric, error) {
-	return a.repo.ReadAll(ctx)
+	return a.readMetrics(ctx)
+}
+
+func (a *AgentService) readMetrics(ctx context.Context) (map[string]models.Metric, error) {
+	metrics, err := a.repo.ReadAll(ctx)
+	if err != nil || a.filter == nil {
+		return metrics, err
+	}
+
+	filtered := make(map[string]models.Metric, len(metrics))
+	for k, v := range metrics {
+		if a.filter.Match(k) {
+			filtered[k] = v
+		}
+	}
+
+	return filtered, nil
 }
 
 func (a *AgentService) jsonMetrics(ctx context.Context) ([]string, error) {
@@ -86,7 +149,7 @@ func (a *AgentService) jsonMetrics(ctx context.Context) ([]string, error) {
 	gzWriter := gzip.NewWriter(&str)
 	defer gzWriter.Close()
 
-	metrics, err := a.repo.ReadAll(ctx)
+	metrics, err := a.readMetrics(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -114,9 +177,11 @@ func (a *AgentService) jsonMetrics(ctx context.Context) ([]string, error) {
 			}
 
 			m = models.MetricJSON{
-				ID:    k,
-				MType: "counter",
-				Delta: new(int64),
+				ID:          k,
+				MType:       "counter",
+				Delta:       new(int64),
+				Temporality: models.TemporalityCumulative,
+				Source:      a.source,
 			}
 			*m.Delta = v
 		default:
@@ -142,7 +207,7 @@ func (a *AgentService) jsonMetrics(ctx context.Context) ([]string, error) {
 }
 
 func (a *AgentService) queryMetrics(ctx context.Context) ([]string, error) {
-	metrics, err := a.repo.ReadAll(ctx)
+	metrics, err := a.readMetrics(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +241,7 @@ func (a *AgentService) queryMetrics(ctx context.Context) ([]string, error) {
 }
 
 func (a *AgentService) batchMetrics(ctx context.Context) ([]string, error) {
-	metrics, err := a.repo.ReadAll(ctx)
+	metrics, err := a.readMetrics(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -204,9 +269,11 @@ func (a *AgentService) batchMetrics(ctx context.Context) ([]string, error) {
 			}
 
 			m = append(m, models.MetricJSON{
-				ID:    k,
-				MType: "counter",
-				Delta: new(int64),
+				ID:          k,
+				MType:       "counter",
+				Delta:       new(int64),
+				Temporality: models.TemporalityCumulative,
+				Source:      a.source,
 			})
 			*m[len(m)-1].Delta = val
 		default:
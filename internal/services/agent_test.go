@@ -1,8 +1,11 @@
 package services
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/leonf08/metrics-yp.git/internal/models"
@@ -10,6 +13,7 @@ import (
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type key struct{}
@@ -97,6 +101,77 @@ func TestAgentService_GatherMetrics(t *testing.T) {
 	}
 }
 
+func TestAgentService_GatherMetricsCollectors(t *testing.T) {
+	r := repo.NewStorage()
+
+	ok := mocks.NewCollector(t)
+	ok.On("Collect", mock.Anything).
+		Return(map[string]models.Metric{"up{target=\"a\"}": {Type: "gauge", Val: float64(1)}}, nil)
+
+	failed := mocks.NewCollector(t)
+	failed.On("Collect", mock.Anything).
+		Return(map[string]models.Metric{"up{target=\"b\"}": {Type: "gauge", Val: float64(0)}}, errors.New("error"))
+
+	a := NewAgentService("json", r, failed, ok)
+
+	err := a.GatherMetrics(context.Background())
+	assert.Error(t, err)
+
+	m, err := r.GetVal(context.Background(), "up{target=\"a\"}")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Metric{Type: "gauge", Val: float64(1)}, m)
+
+	m, err = r.GetVal(context.Background(), "up{target=\"b\"}")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Metric{Type: "gauge", Val: float64(0)}, m)
+}
+
+func TestAgentService_ForOutput(t *testing.T) {
+	r := repo.NewStorage()
+	require.NoError(t, r.SetVal(context.Background(), "HeapAlloc", models.Metric{Type: "gauge", Val: 1.5}))
+	require.NoError(t, r.SetVal(context.Background(), "PollCount", models.Metric{Type: "counter", Val: int64(2)}))
+
+	f, err := NewMetricFilter([]string{"Heap*"}, nil)
+	require.NoError(t, err)
+
+	a := NewAgentService("json", r).ForOutput("query", f)
+
+	got, err := a.GetMetrics(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]models.Metric{"HeapAlloc": {Type: "gauge", Val: 1.5}}, got)
+
+	payload, err := a.ReportMetrics(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HeapAlloc/gauge/1.5"}, payload)
+}
+
+func TestAgentService_ReportMetrics_Cumulative(t *testing.T) {
+	r := repo.NewStorage()
+	require.NoError(t, r.SetVal(context.Background(), "HeapAlloc", models.Metric{Type: "gauge", Val: 1.5}))
+	require.NoError(t, r.SetVal(context.Background(), "PollCount", models.Metric{Type: "counter", Val: int64(2)}))
+
+	payload, err := NewAgentService("batch", r).ReportMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, payload, 1)
+
+	gz, err := gzip.NewReader(strings.NewReader(payload[0]))
+	require.NoError(t, err)
+
+	var batch []models.MetricJSON
+	require.NoError(t, json.NewDecoder(gz).Decode(&batch))
+
+	for _, m := range batch {
+		switch m.MType {
+		case "counter":
+			assert.Equal(t, models.TemporalityCumulative, m.Temporality)
+			assert.Equal(t, AgentSource(), m.Source)
+		case "gauge":
+			assert.Empty(t, m.Temporality)
+			assert.Empty(t, m.Source)
+		}
+	}
+}
+
 func TestAgentService_ReportMetrics(t *testing.T) {
 	type fields struct {
 		mode string
@@ -520,8 +595,9 @@ func TestNewAgentService(t *testing.T) {
 				r:    nil,
 			},
 			want: &AgentService{
-				mode: "json",
-				repo: nil,
+				mode:   "json",
+				source: AgentSource(),
+				repo:   nil,
 			},
 		},
 	}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+)
+
+const (
+	// rateStaleness is the time after which a source which stopped reporting a counter
+	// doesn't contribute to its rate.
+	rateStaleness = 5 * time.Minute
+
+	// counterTTL is the time after which the last value of a source which stopped reporting
+	// a counter is forgotten. It is much longer than intervals of reports, so sources
+	// reporting rarely are not taken for new ones.
+	counterTTL = 24 * time.Hour
+)
+
+// ErrInvalidTemporality is the error of a counter reported with an unknown temporality.
+var ErrInvalidTemporality = errors.New("invalid counter temporality")
+
+// counterKey identifies a counter of a source. Cumulative values are tracked apart from deltas,
+// so deltas of the same counter don't interfere.
+type counterKey struct {
+	source     string
+	name       string
+	cumulative bool
+}
+
+// counterState is the last report of a counter by a source.
+type counterState struct {
+	last int64
+	seen time.Time
+	rate float64
+}
+
+// CounterUpdate is the increment of a counter computed from a report.
+// The update is reserved until it is committed or canceled.
+type CounterUpdate struct {
+	// Increment is the value to add to the stored counter
+	Increment int64
+
+	key     counterKey
+	value   int64
+	prev    int64
+	created bool
+	reset   bool
+	time    time.Time
+}
+
+// CounterRate is the rate of a counter per second summed over its sources.
+type CounterRate struct {
+	Name string
+	Rate float64
+}
+
+// CounterTracker converts reports of counters to increments. Deltas are increments as is,
+// cumulative values are converted by subtracting the last value reported by the same source.
+// A decrease of a cumulative value is a reset of the source, the value is the increment then.
+//
+// The first cumulative value of a source unknown to the tracker is the baseline of the source,
+// its increment is zero. The tracker is not persisted, so after a restart of the server sources
+// don't add values they have already reported, at the cost of what they counted between
+// the last report before the restart and the first one after it. Sources which haven't
+// reported a counter for a day are forgotten and start from a baseline again.
+//
+// Rates per second are computed from increments of every source. Sources which haven't
+// reported a counter for five minutes don't contribute to its rate.
+type CounterTracker struct {
+	mu       sync.Mutex
+	counters map[counterKey]*counterState
+	pruned   time.Time
+	resets   *telemetry.Counter
+}
+
+// NewCounterTracker creates a tracker of counters.
+func NewCounterTracker(reg *telemetry.Registry) *CounterTracker {
+	return &CounterTracker{
+		counters: make(map[counterKey]*counterState),
+		resets:   reg.Counter(telemetry.ServerPrefix+"counter_resets_total", nil),
+	}
+}
+
+// Observe computes the increment of the counter reported by the source.
+// Temporality is a delta if empty.
+//
+// The cumulative value is reserved as the last value of the source at once, so a concurrent
+// report of the same counter, as well as a duplicate in the same batch, gets the increment
+// since this one. The update must be committed after the increment is stored
+// or canceled if it is not.
+func (t *CounterTracker) Observe(source, name, temporality string, value int64, now time.Time) (CounterUpdate, error) {
+	u := CounterUpdate{
+		Increment: value,
+		key:       counterKey{source: source, name: name},
+		value:     value,
+		time:      now,
+	}
+
+	switch temporality {
+	case "", models.TemporalityDelta:
+		return u, nil
+	case models.TemporalityCumulative:
+	default:
+		return CounterUpdate{}, ErrInvalidTemporality
+	}
+
+	u.key.cumulative = true
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.counters[u.key]
+	if !ok {
+		u.Increment = 0
+		u.created = true
+		t.counters[u.key] = &counterState{last: value, seen: now}
+		return u, nil
+	}
+
+	u.prev = s.last
+	if value >= s.last {
+		u.Increment = value - s.last
+	} else {
+		u.reset = true
+	}
+	s.last = value
+
+	return u, nil
+}
+
+// Commit applies updates to the tracker after increments are stored.
+func (t *CounterTracker) Commit(updates ...CounterUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, u := range updates {
+		if u.reset {
+			t.resets.Inc()
+		}
+
+		s, ok := t.counters[u.key]
+		if !ok {
+			// The source is pruned before the update is committed
+			if u.key.cumulative {
+				s = &counterState{last: u.value, seen: u.time}
+			} else {
+				s = &counterState{seen: u.time}
+			}
+			t.counters[u.key] = s
+			continue
+		}
+
+		if u.time.After(s.seen) {
+			s.rate = float64(u.Increment) / u.time.Sub(s.seen).Seconds()
+			s.seen = u.time
+		}
+	}
+
+	if len(updates) > 0 {
+		t.prune(updates[len(updates)-1].time)
+	}
+}
+
+// Cancel releases reservations of updates whose increments are not stored.
+// The last value of a source is restored unless a later report has changed it.
+func (t *CounterTracker) Cancel(updates ...CounterUpdate) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, u := range updates {
+		if !u.key.cumulative {
+			continue
+		}
+
+		s, ok := t.counters[u.key]
+		if !ok || s.last != u.value {
+			continue
+		}
+
+		if u.created {
+			delete(t.counters, u.key)
+		} else {
+			s.last = u.prev
+		}
+	}
+}
+
+// prune forgets sources which haven't reported a counter for a day.
+// The tracker is scanned at most once in five minutes. The caller must hold the lock.
+func (t *CounterTracker) prune(now time.Time) {
+	if now.Sub(t.pruned) < rateStaleness {
+		return
+	}
+	t.pruned = now
+
+	for k, s := range t.counters {
+		if now.Sub(s.seen) > counterTTL {
+			delete(t.counters, k)
+		}
+	}
+}
+
+// Rates returns rates of counters ordered by name. Sources which haven't reported
+// a counter for five minutes are skipped.
+func (t *CounterTracker) Rates(now time.Time) []CounterRate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rates := make(map[string]float64)
+	for k, s := range t.counters {
+		if now.Sub(s.seen) > rateStaleness {
+			continue
+		}
+
+		rates[k.name] += s.rate
+	}
+
+	res := make([]CounterRate, 0, len(rates))
+	for name, rate := range rates {
+		res = append(res, CounterRate{Name: name, Rate: rate})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterTracker_Observe(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	type report struct {
+		source      string
+		temporality string
+		value       int64
+	}
+
+	tests := []struct {
+		name       string
+		reports    []report
+		want       []int64
+		wantResets int64
+	}{
+		{
+			name:    "deltas are increments",
+			reports: []report{{"a", "", 2}, {"a", models.TemporalityDelta, 3}},
+			want:    []int64{2, 3},
+		},
+		{
+			name: "cumulative values",
+			reports: []report{
+				{"a", models.TemporalityCumulative, 5},
+				{"a", models.TemporalityCumulative, 8},
+				{"a", models.TemporalityCumulative, 8},
+			},
+			want: []int64{0, 3, 0},
+		},
+		{
+			name: "reset",
+			reports: []report{
+				{"a", models.TemporalityCumulative, 10},
+				{"a", models.TemporalityCumulative, 4},
+				{"a", models.TemporalityCumulative, 6},
+			},
+			want:       []int64{0, 4, 2},
+			wantResets: 1,
+		},
+		{
+			name: "sources are tracked apart",
+			reports: []report{
+				{"a", models.TemporalityCumulative, 10},
+				{"b", models.TemporalityCumulative, 3},
+				{"a", models.TemporalityCumulative, 12},
+				{"b", models.TemporalityCumulative, 7},
+			},
+			want: []int64{0, 0, 2, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := telemetry.NewRegistry()
+			tr := NewCounterTracker(reg)
+
+			for i, r := range tt.reports {
+				u, err := tr.Observe(r.source, "PollCount", r.temporality, r.value, start.Add(time.Duration(i)*time.Second))
+				require.NoError(t, err)
+				assert.Equal(t, tt.want[i], u.Increment, "report %d", i)
+
+				tr.Commit(u)
+			}
+
+			assert.Equal(t, tt.wantResets, reg.Counter(telemetry.ServerPrefix+"counter_resets_total", nil).Value())
+		})
+	}
+}
+
+func TestCounterTracker_Canceled(t *testing.T) {
+	tr := NewCounterTracker(telemetry.NewRegistry())
+	now := time.Now()
+
+	u, err := tr.Observe("a", "PollCount", models.TemporalityCumulative, 5, now)
+	require.NoError(t, err)
+	tr.Commit(u)
+
+	// An update which failed to be stored is computed again on retry
+	u, err = tr.Observe("a", "PollCount", models.TemporalityCumulative, 9, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), u.Increment)
+	tr.Cancel(u)
+
+	u, err = tr.Observe("a", "PollCount", models.TemporalityCumulative, 9, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), u.Increment)
+
+	// The baseline of an unknown source is forgotten as well
+	u, err = tr.Observe("b", "PollCount", models.TemporalityCumulative, 7, now)
+	require.NoError(t, err)
+	tr.Cancel(u)
+
+	u, err = tr.Observe("b", "PollCount", models.TemporalityCumulative, 7, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), u.Increment)
+
+	_, err = tr.Observe("a", "PollCount", "total", 9, now)
+	assert.ErrorIs(t, err, ErrInvalidTemporality)
+}
+
+func TestCounterTracker_Reserved(t *testing.T) {
+	tr := NewCounterTracker(telemetry.NewRegistry())
+	now := time.Now()
+
+	u, err := tr.Observe("a", "PollCount", models.TemporalityCumulative, 5, now)
+	require.NoError(t, err)
+	tr.Commit(u)
+
+	// Reports observed before the previous one is committed are not counted twice
+	first, err := tr.Observe("a", "PollCount", models.TemporalityCumulative, 9, now)
+	require.NoError(t, err)
+	second, err := tr.Observe("a", "PollCount", models.TemporalityCumulative, 12, now)
+	require.NoError(t, err)
+	duplicate, err := tr.Observe("a", "PollCount", models.TemporalityCumulative, 12, now)
+	require.NoError(t, err)
+
+	assert.Equal(t, []int64{4, 3, 0}, []int64{first.Increment, second.Increment, duplicate.Increment})
+	tr.Commit(first, second, duplicate)
+
+	// Canceling a report superseded by a later one keeps the later value
+	tr.Cancel(first)
+	u, err = tr.Observe("a", "PollCount", models.TemporalityCumulative, 15, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), u.Increment)
+}
+
+func TestCounterTracker_Prune(t *testing.T) {
+	tr := NewCounterTracker(telemetry.NewRegistry())
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	observe := func(source string, value int64, at time.Time) int64 {
+		u, err := tr.Observe(source, "PollCount", models.TemporalityCumulative, value, at)
+		require.NoError(t, err)
+		tr.Commit(u)
+		return u.Increment
+	}
+
+	observe("a", 10, start)
+	observe("b", 10, start)
+
+	// Sources reporting less often than the rates go stale are still tracked
+	assert.Equal(t, int64(5), observe("a", 15, start.Add(10*time.Minute)))
+	assert.Equal(t, int64(10), observe("b", 20, start.Add(10*time.Minute)))
+	assert.Len(t, tr.counters, 2)
+
+	observe("b", 30, start.Add(25*time.Hour))
+	assert.Len(t, tr.counters, 1)
+
+	// A forgotten source starts from a baseline
+	assert.Equal(t, int64(0), observe("a", 30, start.Add(26*time.Hour)))
+}
+
+func TestCounterTracker_Rates(t *testing.T) {
+	tr := NewCounterTracker(telemetry.NewRegistry())
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	observe := func(source, temporality string, value int64, at time.Time) {
+		u, err := tr.Observe(source, "PollCount", temporality, value, at)
+		require.NoError(t, err)
+		tr.Commit(u)
+	}
+
+	observe("a", models.TemporalityCumulative, 10, start)
+	observe("a", models.TemporalityCumulative, 30, start.Add(10*time.Second))
+	observe("b", models.TemporalityDelta, 5, start)
+	observe("b", models.TemporalityDelta, 5, start.Add(10*time.Second))
+	observe("c", models.TemporalityDelta, 100, start.Add(-time.Hour))
+	observe("c", models.TemporalityDelta, 100, start.Add(-time.Hour+time.Second))
+
+	assert.Equal(t, []CounterRate{{Name: "PollCount", Rate: 2.5}}, tr.Rates(start.Add(10*time.Second)))
+	assert.Empty(t, tr.Rates(start.Add(time.Hour)))
+}
@@ -7,11 +7,33 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"os"
+	"time"
 )
 
+// HeaderCryptoKeyID selects the private key the request body is decrypted with,
+// the default key without an ID is used if it is empty.
+const HeaderCryptoKeyID = "CryptoKeyID"
+
+var _ KeyedCrypto = (*CryptoService)(nil)
+
 type CryptoService struct {
 	cryptoKeyFile string
+	keys          map[string]CryptoKey
+}
+
+// CryptoKey is a named RSA key. Keys are rotated like signing keys: agents switch
+// to the new public key while the server accepts both private keys.
+type CryptoKey struct {
+	// ID is sent by clients in the CryptoKeyID header, the key with empty ID is the default one
+	ID string
+
+	// File is a path to the file with the key
+	File string
+
+	// Expires is the time the key is not accepted after, zero time means the key doesn't expire
+	Expires time.Time
 }
 
 func NewCryptoService(cryptoKeyFile string) *CryptoService {
@@ -20,6 +42,54 @@ func NewCryptoService(cryptoKeyFile string) *CryptoService {
 	}
 }
 
+// NewCryptoServiceWithKeys creates instance of the service decrypting requests
+// with the key selected by its ID. Keys without files are skipped,
+// it returns nil if there are no keys.
+func NewCryptoServiceWithKeys(keys ...CryptoKey) *CryptoService {
+	ring := make(map[string]CryptoKey, len(keys))
+	for _, k := range keys {
+		if k.File != "" {
+			ring[k.ID] = k
+		}
+	}
+
+	if len(ring) == 0 {
+		return nil
+	}
+
+	c := &CryptoService{
+		keys: ring,
+	}
+	if k, ok := ring[""]; ok {
+		c.cryptoKeyFile = k.File
+	}
+
+	return c
+}
+
+// ForKey returns the service with the key selected by its ID at the time.
+// The service created with a single key has the default key only.
+func (c *CryptoService) ForKey(id string, now time.Time) (Crypto, error) {
+	if c.keys == nil {
+		if id != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+		}
+
+		return CryptoService{cryptoKeyFile: c.cryptoKeyFile}, nil
+	}
+
+	k, ok := c.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	if !k.Expires.IsZero() && now.After(k.Expires) {
+		return nil, fmt.Errorf("%w: %s", ErrExpiredKey, id)
+	}
+
+	return CryptoService{cryptoKeyFile: k.File}, nil
+}
+
 func (c CryptoService) Decrypt(src []byte) ([]byte, error) {
 	b, err := os.ReadFile(c.cryptoKeyFile)
 	if err != nil {
@@ -6,7 +6,9 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -60,7 +62,7 @@ func TestCryptoService_Decrypt(t *testing.T) {
 			err := generateKeyPair("private.pem", "public.pem", tt.args.t, "RSA PUBLIC KEY")
 			require.NoError(t, err)
 
-			src, err := CryptoService{"public.pem"}.Encrypt(tt.args.src)
+			src, err := CryptoService{cryptoKeyFile: "public.pem"}.Encrypt(tt.args.src)
 			require.NoError(t, err)
 
 			_, err = c.Decrypt(src)
@@ -205,3 +207,41 @@ func TestNewCryptoService(t *testing.T) {
 		})
 	}
 }
+
+func TestCryptoService_ForKey(t *testing.T) {
+	dir := t.TempDir()
+	oldPr, oldPub := filepath.Join(dir, "old.pem"), filepath.Join(dir, "old.pub.pem")
+	newPr, newPub := filepath.Join(dir, "new.pem"), filepath.Join(dir, "new.pub.pem")
+	require.NoError(t, generateKeyPair(oldPr, oldPub, "RSA PRIVATE KEY", "RSA PUBLIC KEY"))
+	require.NoError(t, generateKeyPair(newPr, newPub, "RSA PRIVATE KEY", "RSA PUBLIC KEY"))
+
+	now := time.Unix(1700000000, 0)
+	c := NewCryptoServiceWithKeys(
+		CryptoKey{ID: "v1", File: oldPr, Expires: now.Add(time.Hour)},
+		CryptoKey{ID: "v2", File: newPr},
+	)
+
+	for _, id := range []string{"v1", "v2"} {
+		pub := map[string]string{"v1": oldPub, "v2": newPub}[id]
+		src, err := NewCryptoService(pub).Encrypt([]byte("test"))
+		require.NoError(t, err)
+
+		dec, err := c.ForKey(id, now)
+		require.NoError(t, err)
+
+		got, err := dec.Decrypt(src)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("test"), got)
+	}
+
+	_, err := c.ForKey("v1", now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, ErrExpiredKey)
+
+	_, err = c.ForKey("", now)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	_, err = NewCryptoService(oldPr).ForKey("v1", now)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	assert.Nil(t, NewCryptoServiceWithKeys(CryptoKey{ID: "v1"}))
+}
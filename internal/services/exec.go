@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+)
+
+const (
+	execErrorMetric = "ExecError"
+
+	// execWaitDelay limits waiting for the output of processes
+	// started by the command after the command itself is killed.
+	execWaitDelay = time.Second
+)
+
+var _ Collector = (*ExecCollector)(nil)
+
+// ExecCollector collects metrics from the output of external commands.
+type ExecCollector struct {
+	commands []string
+	timeout  time.Duration
+
+	// counters holds cumulative counters of every command
+	counters []*cumulativeCounters
+}
+
+// NewExecCollector creates a new exec collector. Every command is run by the shell
+// and is killed if it doesn't finish within the timeout.
+func NewExecCollector(commands []string, timeout time.Duration) *ExecCollector {
+	counters := make([]*cumulativeCounters, len(commands))
+	for i := range counters {
+		counters[i] = newCumulativeCounters()
+	}
+
+	return &ExecCollector{
+		commands: commands,
+		timeout:  timeout,
+		counters: counters,
+	}
+}
+
+// Collect runs all commands concurrently and parses their output.
+// The output is either lines in the format "name type value"
+// or JSON objects (or arrays of them) in the format of models.MetricJSON.
+//
+// Counters in lines are increments since the previous run of the command. Counters in JSON
+// are increments as well unless their temporality is cumulative, cumulative values
+// are converted to increments since the previous value reported by the command.
+//
+// Failures of commands don't fail the collection. For every command
+// the ExecError gauge labeled with the index of the command in the configuration
+// is set to 1 if the command failed or its output couldn't be parsed, and to 0 otherwise.
+// Commands themselves aren't used as labels, they may hold secrets in arguments.
+func (e *ExecCollector) Collect(ctx context.Context) (map[string]models.Metric, error) {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	metrics := make(map[string]models.Metric)
+	for i, c := range e.commands {
+		wg.Add(1)
+		go func(i int, c string) {
+			defer wg.Done()
+
+			m, err := e.run(ctx, c, e.counters[i])
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			var failed float64
+			if err != nil {
+				failed = 1
+			}
+			metrics[models.SeriesName(execErrorMetric, map[string]string{"index": strconv.Itoa(i)})] =
+				models.Metric{Type: "gauge", Val: failed}
+
+			for k, v := range m {
+				metrics[k] = v
+			}
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	return metrics, nil
+}
+
+func (e *ExecCollector) run(ctx context.Context, command string, counters *cumulativeCounters) (map[string]models.Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.WaitDelay = execWaitDelay
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseExecOutput(out, counters)
+}
+
+// parseExecOutput parses the output of a command. Cumulative counters are converted
+// to increments by the counters of the command.
+func parseExecOutput(out []byte, counters *cumulativeCounters) (map[string]models.Metric, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) > 0 && (out[0] == '{' || out[0] == '[') {
+		return parseExecJSON(out, counters)
+	}
+
+	metrics := make(map[string]models.Metric)
+
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line: %q", sc.Text())
+		}
+
+		switch fields[1] {
+		case "gauge":
+			v, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, err
+			}
+
+			metrics[fields[0]] = models.Metric{Type: "gauge", Val: v}
+		case "counter":
+			v, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			metrics[fields[0]] = models.Metric{Type: "counter", Val: v}
+		default:
+			return nil, errors.New("invalid metric type")
+		}
+	}
+
+	return metrics, sc.Err()
+}
+
+func parseExecJSON(out []byte, counters *cumulativeCounters) (map[string]models.Metric, error) {
+	metrics := make(map[string]models.Metric)
+
+	// Cumulative values are converted after the whole output is parsed,
+	// so values of an invalid output don't move the baselines
+	cumulative := make(map[string]int64)
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				for k, v := range cumulative {
+					metrics[k] = models.Metric{Type: "counter", Val: counters.increment(k, v)}
+				}
+
+				return metrics, nil
+			}
+
+			return nil, err
+		}
+
+		var batch []models.MetricJSON
+		if raw[0] == '[' {
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				return nil, err
+			}
+		} else {
+			var m models.MetricJSON
+			if err := json.Unmarshal(raw, &m); err != nil {
+				return nil, err
+			}
+
+			batch = append(batch, m)
+		}
+
+		for _, m := range batch {
+			if m.ID == "" {
+				return nil, errors.New("metric id is required")
+			}
+
+			switch {
+			case m.MType == "gauge" && m.Value != nil:
+				metrics[m.ID] = models.Metric{Type: "gauge", Val: *m.Value}
+			case m.MType == "counter" && m.Delta != nil:
+				switch m.Temporality {
+				case "", models.TemporalityDelta:
+					metrics[m.ID] = models.Metric{Type: "counter", Val: *m.Delta}
+				case models.TemporalityCumulative:
+					cumulative[m.ID] = *m.Delta
+				default:
+					return nil, fmt.Errorf("metric %s: %w", m.ID, ErrInvalidTemporality)
+				}
+			default:
+				return nil, errors.New("invalid metric")
+			}
+		}
+	}
+}
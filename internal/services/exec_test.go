@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecCollector_Collect(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		want     map[string]models.Metric
+	}{
+		{
+			name:     "text output",
+			commands: []string{`printf 'QueueDepth gauge 12.5\nBackups counter 3\n'`},
+			want: map[string]models.Metric{
+				"QueueDepth":           {Type: "gauge", Val: 12.5},
+				"Backups":              {Type: "counter", Val: int64(3)},
+				`ExecError{index="0"}`: {Type: "gauge", Val: float64(0)},
+			},
+		},
+		{
+			name:     "json output",
+			commands: []string{`echo '[{"id":"BackupAge","type":"gauge","value":3600}]'`},
+			want: map[string]models.Metric{
+				"BackupAge":            {Type: "gauge", Val: float64(3600)},
+				`ExecError{index="0"}`: {Type: "gauge", Val: float64(0)},
+			},
+		},
+		{
+			name:     "failed command",
+			commands: []string{"exit 1"},
+			want: map[string]models.Metric{
+				`ExecError{index="0"}`: {Type: "gauge", Val: float64(1)},
+			},
+		},
+		{
+			name:     "commands are labeled by index",
+			commands: []string{"curl --user admin:secret http://localhost:1", "true"},
+			want: map[string]models.Metric{
+				`ExecError{index="0"}`: {Type: "gauge", Val: float64(1)},
+				`ExecError{index="1"}`: {Type: "gauge", Val: float64(0)},
+			},
+		},
+		{
+			name:     "timeout",
+			commands: []string{"sleep 5"},
+			want: map[string]models.Metric{
+				`ExecError{index="0"}`: {Type: "gauge", Val: float64(1)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewExecCollector(tt.commands, 500*time.Millisecond)
+
+			got, err := e.Collect(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExecCollector_CollectCumulative(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sent")
+	command := `printf '{"id":"Sent","type":"counter","delta":%s,"temporality":"cumulative"}' "$(cat ` + file + `)"`
+	e := NewExecCollector([]string{command}, time.Second)
+
+	// The first value is the baseline, then increments since the previous value are collected
+	// and a decrease is a reset of the counter
+	for _, step := range []struct {
+		value string
+		want  int64
+	}{
+		{value: "10", want: 0},
+		{value: "15", want: 5},
+		{value: "15", want: 0},
+		{value: "4", want: 4},
+	} {
+		require.NoError(t, os.WriteFile(file, []byte(step.value), 0o600))
+
+		got, err := e.Collect(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, models.Metric{Type: "counter", Val: step.want}, got["Sent"], "value %s", step.value)
+	}
+}
+
+func Test_parseExecOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    map[string]models.Metric
+		wantErr bool
+	}{
+		{
+			name: "json stream",
+			out:  `{"id":"a","type":"counter","delta":2} {"id":"b","type":"gauge","value":1.5}`,
+			want: map[string]models.Metric{
+				"a": {Type: "counter", Val: int64(2)},
+				"b": {Type: "gauge", Val: 1.5},
+			},
+			wantErr: false,
+		},
+		{
+			name: "json cumulative counter",
+			out:  `{"id":"a","type":"counter","delta":7,"temporality":"cumulative"} {"id":"b","type":"counter","delta":2,"temporality":"delta"}`,
+			want: map[string]models.Metric{
+				"a": {Type: "counter", Val: int64(0)},
+				"b": {Type: "counter", Val: int64(2)},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "json invalid temporality",
+			out:     `{"id":"a","type":"counter","delta":7,"temporality":"gauge"}`,
+			wantErr: true,
+		},
+		{
+			name:    "json without value",
+			out:     `{"id":"a","type":"gauge"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid type",
+			out:     "a histogram 1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid counter value",
+			out:     "a counter 1.5",
+			wantErr: true,
+		},
+		{
+			name:    "invalid line",
+			out:     "a gauge",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExecOutput([]byte(tt.out), newCumulativeCounters())
+
+			assert.Equal(t, tt.wantErr, err != nil, "parseExecOutput() error = %v", err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -84,7 +84,7 @@ func newLoader(path string) (*loader, error) {
 
 // Save saves metrics to the file in JSON format.
 func (fs *FileStorage) Save(r repo.Repository) error {
-	m, ok := r.(*repo.MemStorage)
+	m, ok := unwrap(r).(*repo.MemStorage)
 	if !ok {
 		return errors.New("invalid type assertion for in-memory storage")
 	}
@@ -106,7 +106,7 @@ func (fs *FileStorage) Save(r repo.Repository) error {
 
 // Load loads metrics from the file.
 func (fs *FileStorage) Load(r repo.Repository) error {
-	m, ok := r.(*repo.MemStorage)
+	m, ok := unwrap(r).(*repo.MemStorage)
 	if !ok {
 		return errors.New("invalid type assertion for in-memory storage")
 	}
@@ -125,6 +125,15 @@ func (fs *FileStorage) Load(r repo.Repository) error {
 	return nil
 }
 
+// unwrap returns the repository wrapped by an instrumented repository.
+func unwrap(r repo.Repository) repo.Repository {
+	if u, ok := r.(interface{ Unwrap() repo.Repository }); ok {
+		return u.Unwrap()
+	}
+
+	return r
+}
+
 // Close closes the file.
 func (fs *FileStorage) Close() {
 	fs.s.file.Close()
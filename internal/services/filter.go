@@ -0,0 +1,63 @@
+package services
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+)
+
+// MetricFilter selects metrics by name using glob patterns in path.Match syntax.
+// Patterns are matched against the name of a metric without labels.
+type MetricFilter struct {
+	include []string
+	exclude []string
+}
+
+// NewMetricFilter creates a new metric filter. If include is empty, all metrics
+// not matching exclude patterns are selected. It returns nil if both lists are empty.
+func NewMetricFilter(include, exclude []string) (*MetricFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	for _, p := range append(include[:len(include):len(include)], exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+
+	return &MetricFilter{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+// Match returns true if the metric is selected by the filter.
+// Nil filter selects all metrics.
+func (f *MetricFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	base, _, err := models.ParseSeriesName(name)
+	if err != nil {
+		base = name
+	}
+
+	if len(f.include) > 0 && !matchAny(f.include, base) {
+		return false
+	}
+
+	return !matchAny(f.exclude, base)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
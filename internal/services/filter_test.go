@@ -0,0 +1,66 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricFilter_Match(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		metric  string
+		want    bool
+	}{
+		{
+			name:   "no patterns",
+			metric: "Alloc",
+			want:   true,
+		},
+		{
+			name:    "included",
+			include: []string{"Heap*"},
+			metric:  "HeapAlloc",
+			want:    true,
+		},
+		{
+			name:    "not included",
+			include: []string{"Heap*"},
+			metric:  "Alloc",
+			want:    false,
+		},
+		{
+			name:    "excluded",
+			include: []string{"Heap*"},
+			exclude: []string{"HeapIdle"},
+			metric:  "HeapIdle",
+			want:    false,
+		},
+		{
+			name:    "labels are ignored",
+			include: []string{"http_*"},
+			metric:  `http_requests_total{target="localhost:9090/metrics"}`,
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewMetricFilter(tt.include, tt.exclude)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, f.Match(tt.metric))
+		})
+	}
+}
+
+func TestNewMetricFilter(t *testing.T) {
+	f, err := NewMetricFilter(nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	_, err = NewMetricFilter([]string{"Heap["}, nil)
+	assert.Error(t, err)
+}
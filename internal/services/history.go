@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+)
+
+const (
+	// maxRawRange is the longest range served by raw samples
+	maxRawRange = time.Hour
+
+	// maxPoints is the number of points of the range, above which a coarser resolution is picked
+	maxPoints = 1000
+)
+
+// ErrUnknownResolution is returned if rollups aren't kept at the requested resolution.
+var ErrUnknownResolution = errors.New("rollups at the resolution are not kept")
+
+// Series is the history of a metric over a range. It holds raw samples
+// if the resolution is zero and rollups otherwise.
+type Series struct {
+	Name       string
+	Type       string
+	Resolution time.Duration
+	Samples    []models.Sample
+	Rollups    []models.Rollup
+}
+
+// HistoryQuery serves the history of metrics from raw samples and their rollups.
+type HistoryQuery struct {
+	history     repo.History
+	resolutions []Resolution
+}
+
+// NewHistoryQuery creates queries of the history with rollups at the resolutions.
+func NewHistoryQuery(h repo.History, resolutions []Resolution) *HistoryQuery {
+	return &HistoryQuery{history: h, resolutions: resolutions}
+}
+
+// Pick returns the resolution for the range: raw samples for short ranges,
+// otherwise the finest resolution giving at most maxPoints points, or the coarsest one.
+func (q *HistoryQuery) Pick(from, to time.Time) time.Duration {
+	rng := to.Sub(from)
+	if rng <= maxRawRange || len(q.resolutions) == 0 {
+		return 0
+	}
+
+	for _, r := range q.resolutions {
+		if rng/r.Step <= maxPoints {
+			return r.Step
+		}
+	}
+
+	return q.resolutions[len(q.resolutions)-1].Step
+}
+
+// Query returns the history of the metric in [from, to) at the resolution,
+// zero resolution returns raw samples.
+func (q *HistoryQuery) Query(ctx context.Context, name string, from, to time.Time, resolution time.Duration) (Series, error) {
+	s := Series{Name: name, Resolution: resolution}
+
+	if resolution == 0 {
+		samples, err := q.history.Samples(ctx, name, from, to)
+		if err != nil {
+			return Series{}, err
+		}
+
+		s.Samples = samples
+		if len(samples) > 0 {
+			s.Type = samples[0].Type
+		}
+
+		return s, nil
+	}
+
+	if !q.hasResolution(resolution) {
+		return Series{}, fmt.Errorf("%w: %s", ErrUnknownResolution, resolution)
+	}
+
+	// The rollup covering the start of the range is included
+	rollups, err := q.history.Rollups(ctx, name, resolution, from.Truncate(resolution), to)
+	if err != nil {
+		return Series{}, err
+	}
+
+	s.Rollups = rollups
+	if len(rollups) > 0 {
+		s.Type = rollups[0].Type
+	}
+
+	return s, nil
+}
+
+func (q *HistoryQuery) hasResolution(resolution time.Duration) bool {
+	for _, r := range q.resolutions {
+		if r.Step == resolution {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryQuery_Pick(t *testing.T) {
+	to := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	resolutions := []Resolution{{Step: time.Minute}, {Step: time.Hour}, {Step: 24 * time.Hour}}
+
+	tests := []struct {
+		name        string
+		resolutions []Resolution
+		rng         time.Duration
+		want        time.Duration
+	}{
+		{name: "short range is raw", resolutions: resolutions, rng: 30 * time.Minute, want: 0},
+		{name: "hours", resolutions: resolutions, rng: 12 * time.Hour, want: time.Minute},
+		{name: "weeks", resolutions: resolutions, rng: 14 * 24 * time.Hour, want: time.Hour},
+		{name: "years", resolutions: resolutions, rng: 5 * 365 * 24 * time.Hour, want: 24 * time.Hour},
+		{name: "no rollups", rng: 14 * 24 * time.Hour, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewHistoryQuery(repo.NewStorageWithHistory(time.Hour), tt.resolutions)
+			assert.Equal(t, tt.want, q.Pick(to.Add(-tt.rng), to))
+		})
+	}
+}
+
+func TestHistoryQuery_Query(t *testing.T) {
+	ctx := context.Background()
+	st := repo.NewStorageWithHistory(time.Hour)
+	require.NoError(t, st.SetVal(ctx, "hits", models.Metric{Type: "counter", Val: int64(2)}))
+	require.NoError(t, st.SetVal(ctx, "hits", models.Metric{Type: "counter", Val: int64(3)}))
+
+	start := time.Now().Truncate(time.Minute)
+	require.NoError(t, st.WriteRollups(ctx, time.Minute, []models.Rollup{
+		{Name: "hits", Type: "counter", Start: start, Count: 2, Increase: 3, Rate: 0.05},
+	}))
+
+	q := NewHistoryQuery(st, []Resolution{{Step: time.Minute}})
+	from, to := start.Add(-time.Minute), time.Now().Add(time.Second)
+
+	s, err := q.Query(ctx, "hits", from, to, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "counter", s.Type)
+	require.Len(t, s.Samples, 2)
+	assert.Equal(t, 5.0, s.Samples[1].Value)
+
+	s, err = q.Query(ctx, "hits", from, to, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, s.Resolution)
+	require.Len(t, s.Rollups, 1)
+	assert.Equal(t, 3.0, s.Rollups[0].Increase)
+
+	_, err = q.Query(ctx, "hits", from, to, time.Hour)
+	assert.ErrorIs(t, err, ErrUnknownResolution)
+}
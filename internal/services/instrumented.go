@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+)
+
+var _ Collector = (*InstrumentedCollector)(nil)
+
+// InstrumentedCollector wraps a collector and tracks its self-metrics:
+// duration of the last collection, number of failed collections and
+// number of dropped metrics. Metrics with names starting with the prefix
+// reserved for self-metrics are dropped.
+type InstrumentedCollector struct {
+	collector Collector
+	duration  *telemetry.Gauge
+	errors    *telemetry.Counter
+	dropped   *telemetry.Counter
+}
+
+// NewInstrumentedCollector creates a new instrumented collector.
+// Its self-metrics are labeled with the given name.
+func NewInstrumentedCollector(name string, c Collector, reg *telemetry.Registry) *InstrumentedCollector {
+	labels := map[string]string{"collector": name}
+
+	return &InstrumentedCollector{
+		collector: c,
+		duration:  reg.Gauge(telemetry.AgentPrefix+"collector_duration_seconds", labels),
+		errors:    reg.Counter(telemetry.AgentPrefix+"collector_errors_total", labels),
+		dropped:   reg.Counter(telemetry.AgentPrefix+"collector_dropped_metrics_total", labels),
+	}
+}
+
+// Collect collects metrics with the wrapped collector.
+func (i *InstrumentedCollector) Collect(ctx context.Context) (map[string]models.Metric, error) {
+	start := time.Now()
+	metrics, err := i.collector.Collect(ctx)
+	i.duration.Set(time.Since(start).Seconds())
+
+	if err != nil {
+		i.errors.Inc()
+	}
+
+	for k := range metrics {
+		if strings.HasPrefix(k, telemetry.AgentPrefix) {
+			delete(metrics, k)
+			i.dropped.Inc()
+		}
+	}
+
+	return metrics, err
+}
+
+var _ FileStore = (*InstrumentedFileStore)(nil)
+
+// InstrumentedFileStore wraps a file store and tracks duration and errors of saving metrics.
+type InstrumentedFileStore struct {
+	FileStore
+	duration *telemetry.Histogram
+	errors   *telemetry.Counter
+}
+
+// NewInstrumentedFileStore creates a new instrumented file store.
+func NewInstrumentedFileStore(fs FileStore, reg *telemetry.Registry) *InstrumentedFileStore {
+	return &InstrumentedFileStore{
+		FileStore: fs,
+		duration:  reg.Histogram(telemetry.ServerPrefix+"file_save_duration_seconds", nil, nil),
+		errors:    reg.Counter(telemetry.ServerPrefix+"file_save_errors_total", nil),
+	}
+}
+
+// Save saves metrics with the wrapped file store.
+func (i *InstrumentedFileStore) Save(r repo.Repository) error {
+	t := time.Now()
+	err := i.FileStore.Save(r)
+	i.duration.Observe(time.Since(t).Seconds())
+
+	if err != nil {
+		i.errors.Inc()
+	}
+
+	return err
+}
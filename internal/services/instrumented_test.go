@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestInstrumentedCollector_Collect(t *testing.T) {
+	labels := map[string]string{"collector": "test"}
+
+	tests := []struct {
+		name        string
+		metrics     map[string]models.Metric
+		err         error
+		want        map[string]models.Metric
+		wantErrors  int64
+		wantDropped int64
+	}{
+		{
+			name: "reserved names are dropped",
+			metrics: map[string]models.Metric{
+				"Requests":             {Type: "counter", Val: int64(1)},
+				"agent_requests_total": {Type: "counter", Val: int64(2)},
+			},
+			want: map[string]models.Metric{
+				"Requests": {Type: "counter", Val: int64(1)},
+			},
+			wantDropped: 1,
+		},
+		{
+			name:       "collector error",
+			err:        errors.New("failed"),
+			want:       nil,
+			wantErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mocks.NewCollector(t)
+			c.On("Collect", mock.Anything).Return(tt.metrics, tt.err)
+
+			reg := telemetry.NewRegistry()
+			got, err := NewInstrumentedCollector("test", c, reg).Collect(context.Background())
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantErrors, reg.Counter("agent_collector_errors_total", labels).Value())
+			assert.Equal(t, tt.wantDropped, reg.Counter("agent_collector_dropped_metrics_total", labels).Value())
+		})
+	}
+}
+
+func TestInstrumentedFileStore_Save(t *testing.T) {
+	fs := mocks.NewFileStore(t)
+	fs.On("Save", mock.Anything).Return(errors.New("failed")).Once()
+	fs.On("Save", mock.Anything).Return(nil).Once()
+
+	reg := telemetry.NewRegistry()
+	i := NewInstrumentedFileStore(fs, reg)
+
+	assert.Error(t, i.Save(repo.NewStorage()))
+	assert.NoError(t, i.Save(repo.NewStorage()))
+	assert.Equal(t, int64(2), reg.Histogram("server_file_save_duration_seconds", nil, nil).Count())
+	assert.Equal(t, int64(1), reg.Counter("server_file_save_errors_total", nil).Value())
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/models"
 	"github.com/leonf08/metrics-yp.git/internal/services/repo"
@@ -11,6 +12,7 @@ import (
 //go:generate mockery --name Crypto --output ./mocks --filename crypto_mock.go
 //go:generate mockery --name Pinger --output ./mocks --filename pinger_mock.go
 //go:generate mockery --name Agent --output ./mocks --filename agent_mock.go
+//go:generate mockery --name Collector --output ./mocks --filename collector_mock.go
 type (
 	// Agent is an interface for gathering and reporting metrics.
 	Agent interface {
@@ -19,6 +21,13 @@ type (
 		GetMetrics(context.Context) (map[string]models.Metric, error)
 	}
 
+	// Collector is an interface for additional sources of metrics gathered by the agent.
+	// Values of counters are increments since the previous collection,
+	// they are added to counters of the agent.
+	Collector interface {
+		Collect(context.Context) (map[string]models.Metric, error)
+	}
+
 	// FileStore is an interface for file storage.
 	FileStore interface {
 		Save(repo.Repository) error
@@ -32,6 +41,12 @@ type (
 		Encrypt(src []byte) ([]byte, error)
 	}
 
+	// KeyedCrypto is an interface for encryption and decryption with keys selected by their IDs.
+	KeyedCrypto interface {
+		Crypto
+		ForKey(id string, now time.Time) (Crypto, error)
+	}
+
 	// Pinger is an interface for checking connection to the database.
 	Pinger interface {
 		Ping() error
@@ -0,0 +1,348 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+)
+
+var _ Collector = (*LogTailCollector)(nil)
+
+type (
+	// LogRule describes how lines of a log file are turned into a metric.
+	LogRule struct {
+		// Name of the metric
+		Name string
+
+		// File is a path to the log file
+		File string
+
+		// Pattern is a regular expression matched against every line
+		Pattern string
+
+		// Type is a type of the metric. Counter counts matching lines since the previous
+		// collection, gauge takes the value of the first capture group of the last matching line.
+		Type string
+	}
+
+	// LogTailCollector collects metrics from log files. It follows files
+	// the way tail -F does: if a file is rotated or truncated, it is reopened
+	// from the beginning. Offsets of files are saved to the state file,
+	// so lines counted before a restart are not counted again.
+	LogTailCollector struct {
+		mu        sync.Mutex
+		files     map[string]*tailedFile
+		counters  map[string]int64
+		gauges    map[string]float64
+		stateFile string
+	}
+
+	tailedFile struct {
+		path   string
+		rules  []logRule
+		file   *os.File
+		info   os.FileInfo
+		offset int64
+
+		// known is true if the offset points to the position to continue reading from,
+		// either restored from the state file or set after the file is opened
+		known bool
+	}
+
+	logRule struct {
+		name string
+		typ  string
+		re   *regexp.Regexp
+	}
+
+	logTailState struct {
+		Offsets map[string]int64 `json:"offsets"`
+	}
+)
+
+// NewLogTailCollector creates a new log tail collector. If state file is not empty,
+// the state saved by the previous run is loaded from it.
+func NewLogTailCollector(rules []LogRule, stateFile string) (*LogTailCollector, error) {
+	l := &LogTailCollector{
+		files:     make(map[string]*tailedFile),
+		counters:  make(map[string]int64),
+		gauges:    make(map[string]float64),
+		stateFile: stateFile,
+	}
+
+	for _, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+
+		switch r.Type {
+		case "counter":
+			l.counters[r.Name] = 0
+		case "gauge":
+			if re.NumSubexp() == 0 {
+				return nil, fmt.Errorf("rule %s: gauge pattern must have a capture group", r.Name)
+			}
+		default:
+			return nil, fmt.Errorf("rule %s: invalid metric type", r.Name)
+		}
+
+		f, ok := l.files[r.File]
+		if !ok {
+			f = &tailedFile{path: r.File}
+			l.files[r.File] = f
+		}
+		f.rules = append(f.rules, logRule{name: r.Name, typ: r.Type, re: re})
+	}
+
+	if err := l.loadState(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Collect reads lines appended to the files since the previous call and returns metrics:
+// counters of lines matched since the previous call and the latest values of gauges.
+// Errors of some files don't prevent reading the other files.
+func (l *LogTailCollector) Collect(_ context.Context) (map[string]models.Metric, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var errs []error
+	for _, f := range l.files {
+		if err := l.tail(f); err != nil {
+			errs = append(errs, fmt.Errorf("tail %s: %w", f.path, err))
+		}
+	}
+
+	if err := l.saveState(); err != nil {
+		errs = append(errs, err)
+	}
+
+	metrics := make(map[string]models.Metric, len(l.counters)+len(l.gauges))
+	for k, v := range l.counters {
+		metrics[k] = models.Metric{Type: "counter", Val: v}
+		l.counters[k] = 0
+	}
+	for k, v := range l.gauges {
+		metrics[k] = models.Metric{Type: "gauge", Val: v}
+	}
+
+	return metrics, errors.Join(errs...)
+}
+
+// Close saves the state and closes the files.
+func (l *LogTailCollector) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, f := range l.files {
+		if f.file != nil {
+			f.file.Close()
+			f.file = nil
+		}
+	}
+
+	return l.saveState()
+}
+
+func (l *LogTailCollector) tail(f *tailedFile) error {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// The file may be created later, then it is read from the beginning
+				f.known = true
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	if err := l.readLines(f); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// The file is rotated, but the new one is not created yet
+			return nil
+		}
+
+		return err
+	}
+
+	switch {
+	case !os.SameFile(f.info, info):
+		// The file is rotated, the rest of the old file is already read
+		f.file.Close()
+		f.file = nil
+		f.offset = 0
+		f.known = true
+
+		if err = f.open(); err != nil {
+			return err
+		}
+
+		return l.readLines(f)
+	case info.Size() < f.offset:
+		// The file is truncated
+		f.offset = 0
+		if _, err = f.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return l.readLines(f)
+	}
+
+	return nil
+}
+
+// open opens the file and seeks to the saved offset. A file seen for the first time
+// is read from the end, so existing lines are not counted.
+func (f *tailedFile) open() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if !f.known || f.offset > info.Size() {
+		f.offset = 0
+		if !f.known {
+			f.offset = info.Size()
+		}
+	}
+	f.known = true
+
+	if _, err = file.Seek(f.offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.info = info
+
+	return nil
+}
+
+// readLines reads complete lines from the current offset to the end of the file.
+// An incomplete last line is left to be read next time.
+func (l *LogTailCollector) readLines(f *tailedFile) error {
+	r := bufio.NewReader(f.file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			_, err = f.file.Seek(f.offset, io.SeekStart)
+			return err
+		}
+
+		if err != nil {
+			return err
+		}
+
+		f.offset += int64(len(line))
+		l.match(f.rules, bytes.TrimRight(line, "\r\n"))
+	}
+}
+
+func (l *LogTailCollector) match(rules []logRule, line []byte) {
+	for _, r := range rules {
+		sub := r.re.FindSubmatch(line)
+		if sub == nil {
+			continue
+		}
+
+		switch r.typ {
+		case "counter":
+			l.counters[r.name]++
+		case "gauge":
+			v, err := strconv.ParseFloat(string(sub[1]), 64)
+			if err != nil {
+				continue
+			}
+
+			l.gauges[r.name] = v
+		}
+	}
+}
+
+func (l *LogTailCollector) loadState() error {
+	if l.stateFile == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(l.stateFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	var st logTailState
+	if err = json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+
+	for path, offset := range st.Offsets {
+		if f, ok := l.files[path]; ok {
+			f.offset = offset
+			f.known = true
+		}
+	}
+
+	return nil
+}
+
+// saveState writes the state to a temporary file and renames it,
+// so the state file is never left partially written.
+func (l *LogTailCollector) saveState() error {
+	if l.stateFile == "" {
+		return nil
+	}
+
+	st := logTailState{
+		Offsets: make(map[string]int64, len(l.files)),
+	}
+	for path, f := range l.files {
+		if f.known {
+			st.Offsets[path] = f.offset
+		}
+	}
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(l.stateFile), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := l.stateFile + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, l.stateFile)
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendLines(t *testing.T, path, lines string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o666)
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.WriteString(lines)
+	require.NoError(t, err)
+}
+
+func TestLogTailCollector_Collect(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	stateFile := filepath.Join(dir, "state", "logtail.json")
+
+	rules := []LogRule{
+		{Name: "AppErrors", File: logFile, Pattern: "ERROR", Type: "counter"},
+		{Name: "RequestTime", File: logFile, Pattern: `took (\d+)ms`, Type: "gauge"},
+	}
+
+	// Existing lines are not counted
+	appendLines(t, logFile, "ERROR old\n")
+
+	l, err := NewLogTailCollector(rules, stateFile)
+	require.NoError(t, err)
+
+	got, err := l.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]models.Metric{"AppErrors": {Type: "counter", Val: int64(0)}}, got)
+
+	// Incomplete line is left for the next time
+	appendLines(t, logFile, "ERROR one\nrequest took 15ms\nERROR tw")
+
+	got, err = l.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]models.Metric{
+		"AppErrors":   {Type: "counter", Val: int64(1)},
+		"RequestTime": {Type: "gauge", Val: float64(15)},
+	}, got)
+
+	// Rotation, lines matched since the previous collection are counted
+	appendLines(t, logFile, "o\n")
+	require.NoError(t, os.Rename(logFile, logFile+".1"))
+	appendLines(t, logFile, "ERROR three\n")
+
+	got, err = l.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got["AppErrors"].Val)
+
+	// Truncation
+	require.NoError(t, os.Truncate(logFile, 0))
+	appendLines(t, logFile, "ERROR\n")
+
+	got, err = l.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got["AppErrors"].Val)
+
+	got, err = l.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), got["AppErrors"].Val)
+
+	require.NoError(t, l.Close())
+
+	// Restart continues from the saved offsets, lines are not counted twice
+	appendLines(t, logFile, "ERROR five\n")
+
+	l, err = NewLogTailCollector(rules, stateFile)
+	require.NoError(t, err)
+
+	got, err = l.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), got["AppErrors"].Val)
+}
+
+func TestNewLogTailCollector(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []LogRule
+		wantErr bool
+	}{
+		{
+			name:    "valid rules",
+			rules:   []LogRule{{Name: "a", File: "a.log", Pattern: "ERROR", Type: "counter"}},
+			wantErr: false,
+		},
+		{
+			name:    "invalid pattern",
+			rules:   []LogRule{{Name: "a", File: "a.log", Pattern: "(", Type: "counter"}},
+			wantErr: true,
+		},
+		{
+			name:    "gauge without capture group",
+			rules:   []LogRule{{Name: "a", File: "a.log", Pattern: "took", Type: "gauge"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid type",
+			rules:   []LogRule{{Name: "a", File: "a.log", Pattern: "ERROR", Type: "histogram"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLogTailCollector(tt.rules, "")
+			assert.Equal(t, tt.wantErr, err != nil, "NewLogTailCollector() error = %v", err)
+		})
+	}
+}
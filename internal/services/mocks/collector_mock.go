@@ -0,0 +1,55 @@
+// Code generated by mockery v2.37.1. DO NOT EDIT.
+
+package mocks
+
+import (
+	context "context"
+
+	models "github.com/leonf08/metrics-yp.git/internal/models"
+	mock "github.com/stretchr/testify/mock"
+)
+
+// Collector is an autogenerated mock type for the Collector type
+type Collector struct {
+	mock.Mock
+}
+
+// Collect provides a mock function with given fields: _a0
+func (_m *Collector) Collect(_a0 context.Context) (map[string]models.Metric, error) {
+	ret := _m.Called(_a0)
+
+	var r0 map[string]models.Metric
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (map[string]models.Metric, error)); ok {
+		return rf(_a0)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) map[string]models.Metric); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[string]models.Metric)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NewCollector creates a new instance of Collector. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+// The first argument is typically a *testing.T value.
+func NewCollector(t interface {
+	mock.TestingT
+	Cleanup(func())
+}) *Collector {
+	mock := &Collector{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,604 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+)
+
+const (
+	// nameLabel is the label holding the name of a series
+	nameLabel = "__name__"
+
+	// lookback is how old the latest sample of a series may be to be selected
+	lookback = 5 * time.Minute
+
+	// maxSteps limits the number of points of a series of a range query
+	maxSteps = 11000
+
+	// maxSamples limits the number of samples and rollups loaded to evaluate a query
+	maxSamples = 1000000
+)
+
+type (
+	// Labels are labels of a series, the name of the series is the __name__ label.
+	Labels map[string]string
+
+	// Sample is a value of a series at the time of evaluation.
+	Sample struct {
+		Labels Labels
+		Value  float64
+	}
+
+	// Vector is a set of samples of series at the same time.
+	Vector []Sample
+
+	// Point is a value of a series at a time.
+	Point struct {
+		T time.Time
+		V float64
+	}
+
+	// Series is a series of points.
+	Series struct {
+		Labels Labels
+		Points []Point
+	}
+
+	// Matrix is the result of a range query.
+	Matrix []Series
+
+	// Result is the result of an instant query, a vector unless the query evaluates to a scalar.
+	Result struct {
+		Scalar *float64
+		Vector Vector
+		Time   time.Time
+	}
+)
+
+// signature returns the identity of labels, the name is excluded if withoutName is set.
+func (l Labels) signature(withoutName bool) string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		if withoutName && k == nameLabel {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b := strings.Builder{}
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(l[k])
+		b.WriteByte(0)
+	}
+
+	return b.String()
+}
+
+// withoutName returns a copy of labels without the name.
+func (l Labels) withoutName() Labels {
+	res := make(Labels, len(l))
+	for k, v := range l {
+		if k != nameLabel {
+			res[k] = v
+		}
+	}
+
+	return res
+}
+
+// Engine evaluates queries over the repository and the history of metrics.
+type Engine struct {
+	repo       repo.Repository
+	history    *services.HistoryQuery
+	maxSamples int
+}
+
+// NewEngine creates an engine of queries. Instant queries of the current time use
+// values of the repository, other queries use the history. The history is not kept if h is nil.
+func NewEngine(r repo.Repository, h *services.HistoryQuery) *Engine {
+	return &Engine{repo: r, history: h, maxSamples: maxSamples}
+}
+
+// Instant evaluates the query at the time. If current is set, current values of the repository
+// are the latest samples, so queries are answered even if the history isn't kept.
+func (e *Engine) Instant(ctx context.Context, q string, t time.Time, current bool) (Result, error) {
+	expr, err := Parse(q)
+	if err != nil {
+		return Result{}, err
+	}
+
+	ev, err := e.load(ctx, expr, t, t, current)
+	if err != nil {
+		return Result{}, err
+	}
+
+	v, err := ev.eval(expr, t)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := Result{Time: t}
+	switch v := v.(type) {
+	case float64:
+		res.Scalar = &v
+	case Vector:
+		res.Vector = v
+	}
+
+	return res, nil
+}
+
+// Range evaluates the query at every step from the start to the end.
+func (e *Engine) Range(ctx context.Context, q string, start, end time.Time, step time.Duration) (Matrix, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("%w: step must be positive", ErrInvalidQuery)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: end must not be before start", ErrInvalidQuery)
+	}
+	if end.Sub(start)/step >= maxSteps {
+		return nil, fmt.Errorf("%w: too many points, increase the step", ErrInvalidQuery)
+	}
+
+	expr, err := Parse(q)
+	if err != nil {
+		return nil, err
+	}
+
+	ev, err := e.load(ctx, expr, start, end, false)
+	if err != nil {
+		return nil, err
+	}
+
+	series := make(map[string]*Series)
+	for t := start; !t.After(end); t = t.Add(step) {
+		v, err := ev.eval(expr, t)
+		if err != nil {
+			return nil, err
+		}
+
+		vec, ok := v.(Vector)
+		if !ok {
+			vec = Vector{{Labels: Labels{}, Value: v.(float64)}}
+		}
+
+		for _, s := range vec {
+			sig := s.Labels.signature(false)
+			ser, ok := series[sig]
+			if !ok {
+				ser = &Series{Labels: s.Labels}
+				series[sig] = ser
+			}
+			ser.Points = append(ser.Points, Point{T: t, V: s.Value})
+		}
+	}
+
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	m := make(Matrix, 0, len(keys))
+	for _, k := range keys {
+		m = append(m, *series[k])
+	}
+
+	return m, nil
+}
+
+// load reads samples needed to evaluate the expression from the start to the end.
+// Series are looked up among metrics of the repository, only series matching selectors
+// of the expression are read from the history. Long ranges are read from rollups
+// at the resolution picked by the history, a rollup is the last value of its interval then.
+func (e *Engine) load(ctx context.Context, expr Expr, start, end time.Time, current bool) (*evaluator, error) {
+	ev := &evaluator{series: make(map[string]*storedSeries), lookback: lookback}
+
+	if e.history == nil && !current {
+		return nil, errors.New("history of metrics is not kept")
+	}
+
+	metrics, err := e.repo.ReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sels := selectors(expr)
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		if labels, ok := seriesLabels(name); ok && matchesAny(sels, labels) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	if e.history != nil {
+		from, to := start.Add(-lookback-maxRange(expr)), end.Add(time.Nanosecond)
+		resolution := e.history.Pick(from, to)
+		// Rollups are selected as long as the latest one is in the lookback
+		ev.lookback = max(lookback, resolution)
+
+		var loaded int
+		for _, name := range names {
+			s, err := e.history.Query(ctx, name, from, to, resolution)
+			if err != nil {
+				return nil, err
+			}
+
+			loaded += len(s.Samples) + len(s.Rollups)
+			if loaded > e.maxSamples {
+				return nil, fmt.Errorf("%w: too many samples, narrow selectors or the range", ErrInvalidQuery)
+			}
+
+			for _, smp := range s.Samples {
+				ev.add(name, Point{T: smp.Time, V: smp.Value})
+			}
+			for _, r := range s.Rollups {
+				ev.add(name, Point{T: r.Start.Add(resolution), V: r.Last})
+			}
+		}
+	}
+
+	if current {
+		for _, name := range names {
+			v, ok := toFloat(metrics[name].Val)
+			if !ok {
+				continue
+			}
+
+			ev.add(name, Point{T: end, V: v})
+		}
+	}
+
+	return ev, nil
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// maxRange returns the longest range of calls of the expression.
+func maxRange(expr Expr) time.Duration {
+	switch e := expr.(type) {
+	case *Call:
+		return e.Range
+	case *AggregateExpr:
+		return maxRange(e.Expr)
+	case *BinaryExpr:
+		return max(maxRange(e.LHS), maxRange(e.RHS))
+	default:
+		return 0
+	}
+}
+
+// selectors returns selectors of the expression.
+func selectors(expr Expr) []*VectorSelector {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		return []*VectorSelector{e}
+	case *Call:
+		return []*VectorSelector{e.Selector}
+	case *AggregateExpr:
+		return selectors(e.Expr)
+	case *BinaryExpr:
+		return append(selectors(e.LHS), selectors(e.RHS)...)
+	default:
+		return nil
+	}
+}
+
+// matchesAny reports whether labels match all matchers of any of the selectors.
+func matchesAny(sels []*VectorSelector, labels Labels) bool {
+	for _, sel := range sels {
+		if sel.matches(labels) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seriesLabels returns labels of the series with the name, false if the name is invalid.
+func seriesLabels(name string) (Labels, bool) {
+	base, labels, err := models.ParseSeriesName(name)
+	if err != nil {
+		return nil, false
+	}
+
+	l := Labels{nameLabel: base}
+	for k, v := range labels {
+		l[k] = v
+	}
+
+	return l, true
+}
+
+// storedSeries holds points of a series ordered by time.
+type storedSeries struct {
+	labels Labels
+	points []Point
+}
+
+type evaluator struct {
+	series map[string]*storedSeries
+
+	// lookback is how old the latest point of a series may be to be selected
+	lookback time.Duration
+}
+
+func (ev *evaluator) add(name string, p Point) {
+	s, ok := ev.series[name]
+	if !ok {
+		l, ok := seriesLabels(name)
+		if !ok {
+			return
+		}
+
+		s = &storedSeries{labels: l}
+		ev.series[name] = s
+	}
+
+	s.points = append(s.points, p)
+}
+
+// matching returns series matching all matchers of the selector.
+func (ev *evaluator) matching(sel *VectorSelector) []*storedSeries {
+	var res []*storedSeries
+
+	for _, s := range ev.series {
+		if sel.matches(s.labels) {
+			res = append(res, s)
+		}
+	}
+
+	return res
+}
+
+// matches reports whether labels match all matchers of the selector.
+func (sel *VectorSelector) matches(labels Labels) bool {
+	for _, m := range sel.Matchers {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// eval evaluates the expression at the time. The value is a float64 scalar or a Vector.
+func (ev *evaluator) eval(expr Expr, t time.Time) (any, error) {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return e.Val, nil
+	case *VectorSelector:
+		return ev.selectVector(e, t), nil
+	case *Call:
+		return ev.call(e, t), nil
+	case *AggregateExpr:
+		v, err := ev.eval(e.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+
+		vec, ok := v.(Vector)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s expects a vector", ErrInvalidQuery, e.Op)
+		}
+
+		return aggregate(e, vec), nil
+	case *BinaryExpr:
+		lhs, err := ev.eval(e.LHS, t)
+		if err != nil {
+			return nil, err
+		}
+
+		rhs, err := ev.eval(e.RHS, t)
+		if err != nil {
+			return nil, err
+		}
+
+		return binary(e.Op, lhs, rhs)
+	default:
+		return nil, fmt.Errorf("%w: unknown expression %T", ErrInvalidQuery, expr)
+	}
+}
+
+// selectVector returns the latest points of matching series not older than the lookback.
+func (ev *evaluator) selectVector(sel *VectorSelector, t time.Time) Vector {
+	var vec Vector
+	for _, s := range ev.matching(sel) {
+		// Index of the first point after the time
+		i := sort.Search(len(s.points), func(i int) bool { return s.points[i].T.After(t) })
+		if i == 0 || !s.points[i-1].T.After(t.Add(-ev.lookback)) {
+			continue
+		}
+
+		vec = append(vec, Sample{Labels: s.labels, Value: s.points[i-1].V})
+	}
+
+	sortVector(vec)
+	return vec
+}
+
+// call evaluates rate or increase over points of matching series in (t-range, t].
+// A decrease of a counter is a reset, the value after the reset is the increase.
+func (ev *evaluator) call(c *Call, t time.Time) Vector {
+	var vec Vector
+	for _, s := range ev.matching(c.Selector) {
+		from := sort.Search(len(s.points), func(i int) bool { return s.points[i].T.After(t.Add(-c.Range)) })
+		to := sort.Search(len(s.points), func(i int) bool { return s.points[i].T.After(t) })
+		if to-from < 2 {
+			continue
+		}
+
+		var increase float64
+		for i := from + 1; i < to; i++ {
+			if d := s.points[i].V - s.points[i-1].V; d >= 0 {
+				increase += d
+			} else {
+				increase += s.points[i].V
+			}
+		}
+
+		v := increase
+		if c.Func == "rate" {
+			v = increase / c.Range.Seconds()
+		}
+
+		vec = append(vec, Sample{Labels: s.labels.withoutName(), Value: v})
+	}
+
+	sortVector(vec)
+	return vec
+}
+
+func aggregate(e *AggregateExpr, vec Vector) Vector {
+	type group struct {
+		labels Labels
+		value  float64
+		count  int
+	}
+
+	groups := make(map[string]*group)
+	var order []string
+
+	for _, s := range vec {
+		labels := Labels{}
+		if e.Without {
+			labels = s.Labels.withoutName()
+			for _, l := range e.Grouping {
+				delete(labels, l)
+			}
+		} else {
+			for _, l := range e.Grouping {
+				if v, ok := s.Labels[l]; ok {
+					labels[l] = v
+				}
+			}
+		}
+
+		sig := labels.signature(false)
+		g, ok := groups[sig]
+		if !ok {
+			g = &group{labels: labels, value: s.Value}
+			groups[sig] = g
+			order = append(order, sig)
+		} else {
+			switch e.Op {
+			case "sum", "avg":
+				g.value += s.Value
+			case "min":
+				g.value = min(g.value, s.Value)
+			case "max":
+				g.value = max(g.value, s.Value)
+			}
+		}
+		g.count++
+	}
+
+	res := make(Vector, 0, len(groups))
+	for _, sig := range order {
+		g := groups[sig]
+		switch e.Op {
+		case "avg":
+			g.value /= float64(g.count)
+		case "count":
+			g.value = float64(g.count)
+		}
+
+		res = append(res, Sample{Labels: g.labels, Value: g.value})
+	}
+
+	sortVector(res)
+	return res
+}
+
+// binary applies the operator. Samples of vectors are matched one-to-one by labels except the name,
+// the result doesn't have the name.
+func binary(op byte, lhs, rhs any) (any, error) {
+	ls, lScalar := lhs.(float64)
+	rs, rScalar := rhs.(float64)
+
+	switch {
+	case lScalar && rScalar:
+		return apply(op, ls, rs), nil
+	case lScalar:
+		return mapVector(rhs.(Vector), func(v float64) float64 { return apply(op, ls, v) }), nil
+	case rScalar:
+		return mapVector(lhs.(Vector), func(v float64) float64 { return apply(op, v, rs) }), nil
+	}
+
+	right := make(map[string]Sample)
+	for _, s := range rhs.(Vector) {
+		sig := s.Labels.signature(true)
+		if _, ok := right[sig]; ok {
+			return nil, fmt.Errorf("%w: many-to-many matching, series of the right side have the same labels", ErrInvalidQuery)
+		}
+		right[sig] = s
+	}
+
+	var res Vector
+	matched := make(map[string]bool)
+	for _, l := range lhs.(Vector) {
+		sig := l.Labels.signature(true)
+		r, ok := right[sig]
+		if !ok {
+			continue
+		}
+		if matched[sig] {
+			return nil, fmt.Errorf("%w: many-to-many matching, series of the left side have the same labels", ErrInvalidQuery)
+		}
+		matched[sig] = true
+
+		res = append(res, Sample{Labels: l.Labels.withoutName(), Value: apply(op, l.Value, r.Value)})
+	}
+
+	sortVector(res)
+	return res, nil
+}
+
+func mapVector(vec Vector, f func(float64) float64) Vector {
+	res := make(Vector, 0, len(vec))
+	for _, s := range vec {
+		res = append(res, Sample{Labels: s.Labels.withoutName(), Value: f(s.Value)})
+	}
+
+	return res
+}
+
+func apply(op byte, a, b float64) float64 {
+	switch op {
+	case '+':
+		return a + b
+	case '-':
+		return a - b
+	case '*':
+		return a * b
+	default:
+		return a / b
+	}
+}
+
+func sortVector(vec Vector) {
+	sort.Slice(vec, func(i, j int) bool { return vec[i].Labels.signature(false) < vec[j].Labels.signature(false) })
+}
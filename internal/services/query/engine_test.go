@@ -0,0 +1,232 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+
+	ctx := context.Background()
+	st := repo.NewStorageWithHistory(time.Hour)
+
+	set := func(name, typ string, val any) {
+		require.NoError(t, st.SetVal(ctx, name, models.Metric{Type: typ, Val: val}))
+	}
+
+	set("TotalMemory", "gauge", 200.0)
+	set("FreeMemory", "gauge", 100.0)
+	set("FreeMemory", "gauge", 50.0)
+	set(models.SeriesName("Alloc", map[string]string{"host": "a", "dc": "x"}), "gauge", 1.0)
+	set(models.SeriesName("Alloc", map[string]string{"host": "b", "dc": "x"}), "gauge", 3.0)
+	set(models.SeriesName("Alloc", map[string]string{"host": "c", "dc": "y"}), "gauge", 5.0)
+	set("PollCount", "counter", int64(10))
+	set("PollCount", "counter", int64(15))
+	// A value written by a restarted agent, the decrease is a reset
+	set("Uptime", "gauge", 10.0)
+	set("Uptime", "gauge", 15.0)
+	set("Uptime", "gauge", 3.0)
+
+	return NewEngine(st, services.NewHistoryQuery(st, nil))
+}
+
+func TestEngine_Instant(t *testing.T) {
+	e := newTestEngine(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		current    bool
+		want       Vector
+		wantScalar float64
+		wantErr    bool
+	}{
+		{
+			name:  "memory usage percent",
+			query: "100 * (1 - FreeMemory / TotalMemory)",
+			want:  Vector{{Labels: Labels{}, Value: 75}},
+		},
+		{
+			name:  "selector keeps the name",
+			query: `Alloc{host="c"}`,
+			want:  Vector{{Labels: Labels{nameLabel: "Alloc", "host": "c", "dc": "y"}, Value: 5}},
+		},
+		{
+			name:  "sum by label",
+			query: "sum by (dc) (Alloc)",
+			want: Vector{
+				{Labels: Labels{"dc": "x"}, Value: 4},
+				{Labels: Labels{"dc": "y"}, Value: 5},
+			},
+		},
+		{
+			name:  "max without label",
+			query: "max without (host) (Alloc)",
+			want: Vector{
+				{Labels: Labels{"dc": "x"}, Value: 3},
+				{Labels: Labels{"dc": "y"}, Value: 5},
+			},
+		},
+		{
+			name:  "avg of all",
+			query: `avg(Alloc{dc=~"x|y"})`,
+			want:  Vector{{Labels: Labels{}, Value: 3}},
+		},
+		{
+			name:  "increase",
+			query: "increase(PollCount[5m])",
+			want:  Vector{{Labels: Labels{}, Value: 15}},
+		},
+		{
+			name:  "increase over a reset",
+			query: "increase(Uptime[5m])",
+			want:  Vector{{Labels: Labels{}, Value: 8}},
+		},
+		{
+			name:  "rate",
+			query: "rate(PollCount[1m])",
+			want:  Vector{{Labels: Labels{}, Value: 0.25}},
+		},
+		{
+			name:    "current values",
+			query:   "FreeMemory",
+			current: true,
+			want:    Vector{{Labels: Labels{nameLabel: "FreeMemory"}, Value: 50}},
+		},
+		{
+			name:       "scalar",
+			query:      "2 * 3",
+			wantScalar: 6,
+		},
+		{
+			name:  "unmatched series are dropped",
+			query: "Alloc / sum(Alloc)",
+			want:  nil,
+		},
+		{
+			name:    "many-to-many matching",
+			query:   `FreeMemory / {__name__=~".*Memory"}`,
+			wantErr: true,
+		},
+		{
+			name:    "aggregation of a scalar",
+			query:   "sum(1)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := e.Instant(context.Background(), tt.query, time.Now(), tt.current)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+				return
+			}
+
+			require.NoError(t, err)
+			if tt.wantScalar != 0 {
+				require.NotNil(t, res.Scalar)
+				assert.Equal(t, tt.wantScalar, *res.Scalar)
+				return
+			}
+
+			assert.Nil(t, res.Scalar)
+			assert.Equal(t, tt.want, res.Vector)
+		})
+	}
+}
+
+func TestEngine_Range(t *testing.T) {
+	e := newTestEngine(t)
+	ctx := context.Background()
+
+	// Samples are written before the start, they are selected within the lookback
+	start := time.Now()
+	end := start.Add(time.Minute)
+
+	m, err := e.Range(ctx, "FreeMemory / TotalMemory", start, end, 30*time.Second)
+	require.NoError(t, err)
+	require.Len(t, m, 1)
+	assert.Equal(t, Labels{}, m[0].Labels)
+	assert.Equal(t, []Point{
+		{T: start, V: 0.25},
+		{T: start.Add(30 * time.Second), V: 0.25},
+		{T: end, V: 0.25},
+	}, m[0].Points)
+
+	_, err = e.Range(ctx, "FreeMemory", start, end, 0)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+
+	_, err = e.Range(ctx, "FreeMemory", start, start.Add(24*time.Hour), time.Second)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+// recordingHistory records names of metrics whose samples are read.
+type recordingHistory struct {
+	*repo.MemStorage
+	names []string
+}
+
+func (h *recordingHistory) Samples(ctx context.Context, name string, from, to time.Time) ([]models.Sample, error) {
+	h.names = append(h.names, name)
+	return h.MemStorage.Samples(ctx, name, from, to)
+}
+
+func TestEngine_LoadMatching(t *testing.T) {
+	e := newTestEngine(t)
+	h := &recordingHistory{MemStorage: e.repo.(*repo.MemStorage)}
+	e.history = services.NewHistoryQuery(h, nil)
+
+	res, err := e.Instant(context.Background(), `sum(Alloc{dc="x"}) + sum(TotalMemory)`, time.Now(), false)
+	require.NoError(t, err)
+	assert.Equal(t, Vector{{Labels: Labels{}, Value: 204}}, res.Vector)
+
+	assert.Equal(t, []string{
+		models.SeriesName("Alloc", map[string]string{"host": "a", "dc": "x"}),
+		models.SeriesName("Alloc", map[string]string{"host": "b", "dc": "x"}),
+		"TotalMemory",
+	}, h.names)
+}
+
+func TestEngine_LoadRollups(t *testing.T) {
+	ctx := context.Background()
+	st := repo.NewStorageWithHistory(time.Hour)
+	require.NoError(t, st.SetVal(ctx, "Alloc", models.Metric{Type: "gauge", Val: 5.0}))
+
+	base := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, st.WriteRollups(ctx, time.Hour, []models.Rollup{
+		{Name: "Alloc", Type: "gauge", Start: base, Count: 1, Last: 1},
+		{Name: "Alloc", Type: "gauge", Start: base.Add(time.Hour), Count: 1, Last: 2},
+		{Name: "Alloc", Type: "gauge", Start: base.Add(2 * time.Hour), Count: 1, Last: 3},
+	}))
+
+	e := NewEngine(st, services.NewHistoryQuery(st, []services.Resolution{{Step: time.Hour}}))
+
+	// A rollup is the last value at the end of its interval
+	m, err := e.Range(ctx, "Alloc", base.Add(time.Hour), base.Add(3*time.Hour), time.Hour)
+	require.NoError(t, err)
+	require.Len(t, m, 1)
+	assert.Equal(t, []Point{
+		{T: base.Add(time.Hour), V: 1},
+		{T: base.Add(2 * time.Hour), V: 2},
+		{T: base.Add(3 * time.Hour), V: 3},
+	}, m[0].Points)
+}
+
+func TestEngine_LoadLimit(t *testing.T) {
+	e := newTestEngine(t)
+	e.maxSamples = 2
+
+	_, err := e.Instant(context.Background(), "FreeMemory", time.Now(), false)
+	require.NoError(t, err)
+
+	_, err = e.Instant(context.Background(), `{__name__=~".*Memory"}`, time.Now(), false)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
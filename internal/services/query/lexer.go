@@ -0,0 +1,201 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDuration
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokAdd
+	tokSub
+	tokMul
+	tokDiv
+	tokEq
+	tokNeq
+	tokRe
+	tokNre
+)
+
+// token is a lexical token of a query, pos is its offset in the query.
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+
+	return strconv.Quote(t.val)
+}
+
+// lex splits the query into tokens. The text between brackets is a duration.
+func lex(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, val: s[i:j], pos: i})
+			i = j
+			continue
+		case c >= '0' && c <= '9' || c == '.':
+			j := lexNumber(s, i)
+			if _, err := strconv.ParseFloat(s[i:j], 64); err != nil {
+				return nil, parseErrorf(i, "invalid number %q", s[i:j])
+			}
+			tokens = append(tokens, token{kind: tokNumber, val: s[i:j], pos: i})
+			i = j
+			continue
+		case c == '"' || c == '\'':
+			val, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, parseErrorf(i, "%s", err)
+			}
+			tokens = append(tokens, token{kind: tokString, val: val, pos: i})
+			i += n
+			continue
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, parseErrorf(i, "unterminated range")
+			}
+			tokens = append(tokens,
+				token{kind: tokLBracket, val: "[", pos: i},
+				token{kind: tokDuration, val: strings.TrimSpace(s[i+1 : i+end]), pos: i + 1},
+				token{kind: tokRBracket, val: "]", pos: i + end})
+			i += end + 1
+			continue
+		}
+
+		kind, n := lexOperator(s[i:])
+		if n == 0 {
+			return nil, parseErrorf(i, "unexpected character %q", c)
+		}
+		tokens = append(tokens, token{kind: kind, val: s[i : i+n], pos: i})
+		i += n
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+func lexOperator(s string) (tokenKind, int) {
+	if len(s) >= 2 {
+		switch s[:2] {
+		case "!=":
+			return tokNeq, 2
+		case "=~":
+			return tokRe, 2
+		case "!~":
+			return tokNre, 2
+		}
+	}
+
+	switch s[0] {
+	case '(':
+		return tokLParen, 1
+	case ')':
+		return tokRParen, 1
+	case '{':
+		return tokLBrace, 1
+	case '}':
+		return tokRBrace, 1
+	case ']':
+		return tokRBracket, 1
+	case ',':
+		return tokComma, 1
+	case '+':
+		return tokAdd, 1
+	case '-':
+		return tokSub, 1
+	case '*':
+		return tokMul, 1
+	case '/':
+		return tokDiv, 1
+	case '=':
+		return tokEq, 1
+	}
+
+	return tokEOF, 0
+}
+
+// lexNumber returns the end of the number starting at i, an exponent is allowed.
+func lexNumber(s string, i int) int {
+	j := i
+	for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+		j++
+	}
+	if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+		k := j + 1
+		if k < len(s) && (s[k] == '+' || s[k] == '-') {
+			k++
+		}
+		if k < len(s) && s[k] >= '0' && s[k] <= '9' {
+			for k < len(s) && s[k] >= '0' && s[k] <= '9' {
+				k++
+			}
+			j = k
+		}
+	}
+
+	return j
+}
+
+// lexString returns the value of the quoted string at the start of s and its length.
+// Double-quoted strings may contain Go escape sequences, single-quoted ones are taken as is.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			if quote == '\'' {
+				return s[1:i], i + 1, nil
+			}
+
+			val, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid string %s", s[:i+1])
+			}
+
+			return val, i + 1, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || c < unicode.MaxASCII && unicode.IsLetter(rune(c))
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,484 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/config/duration"
+)
+
+// ErrInvalidQuery is the error of a query which can't be parsed or evaluated.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ParseError is an error of parsing at the offset of the query.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at char %d: %s", e.Pos+1, e.Msg)
+}
+
+// Unwrap makes parse errors match ErrInvalidQuery.
+func (e *ParseError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+func parseErrorf(pos int, format string, args ...any) error {
+	return &ParseError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+type (
+	// Expr is an expression of the query language.
+	Expr interface {
+		expr()
+	}
+
+	// NumberLiteral is a scalar number.
+	NumberLiteral struct {
+		Val float64
+	}
+
+	// VectorSelector selects the latest samples of series matching all matchers.
+	// The name of a series is matched by the __name__ label.
+	VectorSelector struct {
+		Matchers []*Matcher
+	}
+
+	// Call is a call of rate or increase over samples of series in the range before the time of evaluation.
+	Call struct {
+		Func     string
+		Selector *VectorSelector
+		Range    time.Duration
+	}
+
+	// AggregateExpr aggregates samples of the vector into groups with the same values of grouping labels.
+	// If Without is set, the groups are formed by all labels except the grouping ones.
+	AggregateExpr struct {
+		Op       string
+		Grouping []string
+		Without  bool
+		Expr     Expr
+	}
+
+	// BinaryExpr is an arithmetic operation. Samples of vectors are matched by labels except the name.
+	BinaryExpr struct {
+		Op       byte
+		LHS, RHS Expr
+	}
+)
+
+func (*NumberLiteral) expr()  {}
+func (*VectorSelector) expr() {}
+func (*Call) expr()           {}
+func (*AggregateExpr) expr()  {}
+func (*BinaryExpr) expr()     {}
+
+// MatchType is the type of a label matcher.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher matches values of a label. A missing label has an empty value.
+type Matcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates a matcher, regular expressions are anchored to the whole value.
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		m.re = re
+	}
+
+	return m, nil
+}
+
+// Matches returns true if the value of the label matches.
+func (m *Matcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+var (
+	aggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+	functions    = map[string]bool{"rate": true, "increase": true}
+)
+
+// Parse parses the query. The language is a subset of PromQL: numbers, selectors with label matchers,
+// rate and increase of range selectors, sum, avg, min, max and count aggregations with by or without
+// grouping, and arithmetic operators +, -, * and /.
+func Parse(q string) (Expr, error) {
+	tokens, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, parseErrorf(t.pos, "unexpected %s", t)
+	}
+
+	return e, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+
+	return p.tokens[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, parseErrorf(t.pos, "expected %s, got %s", what, t)
+	}
+
+	return t, nil
+}
+
+// parseExpr parses additive operators, they have the lowest precedence.
+func (p *parser) parseExpr() (Expr, error) {
+	lhs, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for k := p.peek().kind; k == tokAdd || k == tokSub; k = p.peek().kind {
+		op := p.next().val[0]
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+
+	return lhs, nil
+}
+
+func (p *parser) parseTerm() (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for k := p.peek().kind; k == tokMul || k == tokDiv; k = p.peek().kind {
+		op := p.next().val[0]
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+
+	return lhs, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	switch p.peek().kind {
+	case tokAdd:
+		p.next()
+		return p.parseUnary()
+	case tokSub:
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		if n, ok := e.(*NumberLiteral); ok {
+			return &NumberLiteral{Val: -n.Val}, nil
+		}
+
+		return &BinaryExpr{Op: '*', LHS: &NumberLiteral{Val: -1}, RHS: e}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, parseErrorf(t.pos, "invalid number %s", t)
+		}
+
+		return &NumberLiteral{Val: v}, nil
+	case tokLParen:
+		p.next()
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	case tokIdent:
+		next := p.peekAt(1)
+		if aggregations[t.val] && (next.kind == tokLParen || next.val == "by" || next.val == "without") {
+			return p.parseAggregation()
+		}
+		if functions[t.val] && next.kind == tokLParen {
+			return p.parseCall()
+		}
+	case tokLBrace:
+	default:
+		return nil, parseErrorf(t.pos, "unexpected %s", t)
+	}
+
+	sel, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind == tokLBracket {
+		return nil, parseErrorf(t.pos, "range selector is allowed only in rate and increase")
+	}
+
+	return sel, nil
+}
+
+func (p *parser) parseAggregation() (Expr, error) {
+	agg := &AggregateExpr{Op: p.next().val}
+
+	if p.peek().kind == tokIdent {
+		if err := p.parseGrouping(agg); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := p.expect(tokLParen, `"("`); err != nil {
+		return nil, err
+	}
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	agg.Expr = e
+
+	if _, err := p.expect(tokRParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	// Grouping may follow the argument as well
+	if t := p.peek(); t.kind == tokIdent && (t.val == "by" || t.val == "without") {
+		if agg.Grouping != nil {
+			return nil, parseErrorf(t.pos, "grouping is already set")
+		}
+
+		if err := p.parseGrouping(agg); err != nil {
+			return nil, err
+		}
+	}
+
+	return agg, nil
+}
+
+func (p *parser) parseGrouping(agg *AggregateExpr) error {
+	t := p.next()
+	switch t.val {
+	case "by":
+	case "without":
+		agg.Without = true
+	default:
+		return parseErrorf(t.pos, `expected "by" or "without", got %s`, t)
+	}
+
+	if _, err := p.expect(tokLParen, `"("`); err != nil {
+		return err
+	}
+
+	agg.Grouping = []string{}
+	for p.peek().kind != tokRParen {
+		label, err := p.expect(tokIdent, "label name")
+		if err != nil {
+			return err
+		}
+		agg.Grouping = append(agg.Grouping, label.val)
+
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+
+	_, err := p.expect(tokRParen, `")"`)
+	return err
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	call := &Call{Func: p.next().val}
+
+	if _, err := p.expect(tokLParen, `"("`); err != nil {
+		return nil, err
+	}
+
+	sel, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+	call.Selector = sel
+
+	if _, err := p.expect(tokLBracket, fmt.Sprintf("range selector in %s", call.Func)); err != nil {
+		return nil, err
+	}
+
+	d, err := p.expect(tokDuration, "duration")
+	if err != nil {
+		return nil, err
+	}
+	if call.Range, err = parseDuration(d.val); err != nil || call.Range <= 0 {
+		return nil, parseErrorf(d.pos, "invalid range %q", d.val)
+	}
+
+	if _, err := p.expect(tokRBracket, `"]"`); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.expect(tokRParen, `")"`); err != nil {
+		return nil, err
+	}
+
+	return call, nil
+}
+
+// parseSelector parses a selector: a name followed by optional label matchers in braces,
+// or label matchers only.
+func (p *parser) parseSelector() (*VectorSelector, error) {
+	sel := &VectorSelector{}
+
+	t := p.peek()
+	if t.kind == tokIdent {
+		p.next()
+		m, _ := NewMatcher(MatchEqual, nameLabel, t.val)
+		sel.Matchers = append(sel.Matchers, m)
+	}
+
+	if p.peek().kind == tokLBrace {
+		p.next()
+		for p.peek().kind != tokRBrace {
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, m)
+
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+
+		if _, err := p.expect(tokRBrace, `"}"`); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(sel.Matchers) == 0 {
+		return nil, parseErrorf(t.pos, "selector must have a name or a label matcher")
+	}
+
+	return sel, nil
+}
+
+func (p *parser) parseMatcher() (*Matcher, error) {
+	label, err := p.expect(tokIdent, "label name")
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	switch op.kind {
+	case tokEq, tokNeq, tokRe, tokNre:
+	default:
+		return nil, parseErrorf(op.pos, "expected label matching operator, got %s", op)
+	}
+
+	val, err := p.expect(tokString, "label value")
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := NewMatcher(MatchType(op.val), label.val, val.val)
+	if err != nil {
+		return nil, parseErrorf(val.pos, "invalid regular expression: %s", err)
+	}
+
+	return m, nil
+}
+
+// parseDuration parses a duration, numbers of days and weeks like 1d and 2w are accepted.
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseUint(n, 10, 32)
+			if err != nil {
+				return 0, err
+			}
+
+			return time.Duration(v) * unit, nil
+		}
+	}
+
+	return duration.Parse(s)
+}
@@ -0,0 +1,125 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	name := func(n string) *Matcher {
+		m, _ := NewMatcher(MatchEqual, nameLabel, n)
+		return m
+	}
+	sel := func(n string) *VectorSelector {
+		return &VectorSelector{Matchers: []*Matcher{name(n)}}
+	}
+	host, _ := NewMatcher(MatchRegexp, "host", "web-.*")
+
+	tests := []struct {
+		name    string
+		query   string
+		want    Expr
+		wantErr bool
+	}{
+		{
+			name:  "number",
+			query: "1.5e2",
+			want:  &NumberLiteral{Val: 150},
+		},
+		{
+			name:  "selector with matchers",
+			query: `Alloc{host=~"web-.*"}`,
+			want:  &VectorSelector{Matchers: []*Matcher{name("Alloc"), host}},
+		},
+		{
+			name:  "multiplication binds tighter",
+			query: "100 * (1 - FreeMemory / TotalMemory)",
+			want: &BinaryExpr{Op: '*', LHS: &NumberLiteral{Val: 100}, RHS: &BinaryExpr{
+				Op:  '-',
+				LHS: &NumberLiteral{Val: 1},
+				RHS: &BinaryExpr{Op: '/', LHS: sel("FreeMemory"), RHS: sel("TotalMemory")},
+			}},
+		},
+		{
+			name:  "unary minus",
+			query: "-PollCount",
+			want:  &BinaryExpr{Op: '*', LHS: &NumberLiteral{Val: -1}, RHS: sel("PollCount")},
+		},
+		{
+			name:  "rate",
+			query: "rate(PollCount[5m])",
+			want:  &Call{Func: "rate", Selector: sel("PollCount"), Range: 5 * time.Minute},
+		},
+		{
+			name:  "grouping before the argument",
+			query: "sum by (host) (increase(PollCount[1d]))",
+			want: &AggregateExpr{Op: "sum", Grouping: []string{"host"}, Expr: &Call{
+				Func: "increase", Selector: sel("PollCount"), Range: 24 * time.Hour,
+			}},
+		},
+		{
+			name:  "grouping after the argument",
+			query: "avg(Alloc) without (host)",
+			want:  &AggregateExpr{Op: "avg", Grouping: []string{"host"}, Without: true, Expr: sel("Alloc")},
+		},
+		{
+			name:  "aggregation name as a metric",
+			query: "count",
+			want:  sel("count"),
+		},
+		{
+			name:    "range selector outside of a call",
+			query:   "Alloc[5m]",
+			wantErr: true,
+		},
+		{
+			name:    "rate without a range",
+			query:   "rate(PollCount)",
+			wantErr: true,
+		},
+		{
+			name:    "invalid regular expression",
+			query:   `Alloc{host=~"("}`,
+			wantErr: true,
+		},
+		{
+			name:    "empty selector",
+			query:   "{}",
+			wantErr: true,
+		},
+		{
+			name:    "trailing tokens",
+			query:   "Alloc Alloc",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			query:   `Alloc{host="web}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.query)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMatcher_Matches(t *testing.T) {
+	m, err := NewMatcher(MatchNotRegexp, "host", "web|db")
+	require.NoError(t, err)
+
+	assert.False(t, m.Matches("web"))
+	assert.True(t, m.Matches("web-1"))
+	assert.True(t, m.Matches(""))
+}
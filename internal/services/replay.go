@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMaxNonces = 100000
+
+var (
+	// ErrLegacySignature is returned for requests signed without a timestamp and a nonce
+	// if legacy signatures are not accepted.
+	ErrLegacySignature = errors.New("signature without timestamp and nonce")
+
+	// ErrStaleRequest is returned for requests with the timestamp outside the clock skew window.
+	ErrStaleRequest = errors.New("request timestamp is outside the allowed window")
+
+	// ErrReplayedRequest is returned for requests with a nonce which is already seen.
+	ErrReplayedRequest = errors.New("request nonce is already used")
+)
+
+// ReplayGuard rejects signed requests which are too old or too far in the future
+// and requests which reuse a nonce of a recent request.
+type ReplayGuard struct {
+	skew   time.Duration
+	legacy bool
+	nonces *NonceCache
+	now    func() time.Time
+}
+
+// NewReplayGuard creates a new guard. Requests are accepted if their timestamps differ
+// from the current time by less than the skew. If legacy is true, requests signed
+// without a timestamp and a nonce are accepted as well, they are not protected from replay.
+// Nonces are remembered in the cache, so the cache can be shared by guards
+// created with different settings.
+func NewReplayGuard(skew time.Duration, legacy bool, nonces *NonceCache) *ReplayGuard {
+	return &ReplayGuard{
+		skew:   skew,
+		legacy: legacy,
+		nonces: nonces,
+		now:    time.Now,
+	}
+}
+
+// Check checks the timestamp and the nonce of the request. Empty timestamp and nonce
+// mean the request is signed by an old agent.
+func (g *ReplayGuard) Check(timestamp, nonce string) error {
+	if timestamp == "" && nonce == "" {
+		if g.legacy {
+			return nil
+		}
+
+		return ErrLegacySignature
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		return ErrStaleRequest
+	}
+
+	now := g.now()
+	ts := time.Unix(sec, 0)
+	if ts.Before(now.Add(-g.skew)) || ts.After(now.Add(g.skew)) {
+		return ErrStaleRequest
+	}
+
+	// The nonce has to be remembered while the timestamp is in the window
+	if !g.nonces.Add(nonce, ts.Add(g.skew), now) {
+		return ErrReplayedRequest
+	}
+
+	return nil
+}
+
+// NonceCache remembers nonces until they expire.
+type NonceCache struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+	max     int
+}
+
+// NewNonceCache creates a new cache.
+func NewNonceCache() *NonceCache {
+	return &NonceCache{
+		entries: make(map[string]time.Time),
+		max:     defaultMaxNonces,
+	}
+}
+
+// Add remembers the nonce until it expires. It returns false if the nonce is already
+// remembered or there is no room for it, so requests are rejected rather than
+// accepted without protection when the cache is full.
+func (c *NonceCache) Add(nonce string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if exp, ok := c.entries[nonce]; ok && exp.After(now) {
+		return false
+	}
+
+	if len(c.entries) >= c.max {
+		for n, exp := range c.entries {
+			if !exp.After(now) {
+				delete(c.entries, n)
+			}
+		}
+
+		if len(c.entries) >= c.max {
+			return false
+		}
+	}
+
+	c.entries[nonce] = expires
+
+	return true
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayGuard_Check(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	tests := []struct {
+		name      string
+		legacy    bool
+		timestamp string
+		nonce     string
+		wantErr   error
+	}{
+		{
+			name:      "valid request",
+			timestamp: ts(0),
+			nonce:     "a",
+		},
+		{
+			name:      "replayed nonce",
+			timestamp: ts(0),
+			nonce:     "seen",
+			wantErr:   ErrReplayedRequest,
+		},
+		{
+			name:      "old timestamp",
+			timestamp: ts(-2 * time.Minute),
+			nonce:     "b",
+			wantErr:   ErrStaleRequest,
+		},
+		{
+			name:      "future timestamp",
+			timestamp: ts(2 * time.Minute),
+			nonce:     "c",
+			wantErr:   ErrStaleRequest,
+		},
+		{
+			name:      "timestamp within skew",
+			timestamp: ts(-30 * time.Second),
+			nonce:     "d",
+		},
+		{
+			name:      "invalid timestamp",
+			timestamp: "yesterday",
+			nonce:     "e",
+			wantErr:   ErrStaleRequest,
+		},
+		{
+			name:      "missing nonce",
+			timestamp: ts(0),
+			wantErr:   ErrStaleRequest,
+		},
+		{
+			name:    "legacy request rejected",
+			wantErr: ErrLegacySignature,
+		},
+		{
+			name:   "legacy request accepted",
+			legacy: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nonces := NewNonceCache()
+			nonces.Add("seen", now.Add(time.Minute), now)
+
+			g := NewReplayGuard(time.Minute, tt.legacy, nonces)
+			g.now = func() time.Time { return now }
+
+			assert.ErrorIs(t, g.Check(tt.timestamp, tt.nonce), tt.wantErr)
+		})
+	}
+}
+
+func TestNonceCache_Add(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	c := NewNonceCache()
+	c.max = 2
+
+	assert.True(t, c.Add("a", now.Add(time.Minute), now))
+	assert.False(t, c.Add("a", now.Add(time.Minute), now))
+	assert.True(t, c.Add("b", now.Add(2*time.Minute), now))
+
+	// The cache is full until the nonce a expires
+	assert.False(t, c.Add("c", now.Add(time.Minute), now))
+
+	later := now.Add(90 * time.Second)
+	assert.True(t, c.Add("c", later.Add(time.Minute), later))
+	assert.True(t, c.Add("a", later.Add(time.Minute), later.Add(time.Minute)))
+}
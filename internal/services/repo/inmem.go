@@ -7,18 +7,30 @@ import (
 	"fmt"
 	"math/rand"
 	"runtime"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/models"
 )
 
+var errNoHistory = errors.New("history of metrics is not kept")
+
 // MemStorage is an in-memory storage for metrics.
 type MemStorage struct {
 	Storage map[string]models.Metric
 	counter int64
+	history *memHistory
 	sync.RWMutex
 }
 
+// memHistory is the history of metrics kept in memory.
+type memHistory struct {
+	retention time.Duration
+	samples   []models.Sample
+	rollups   map[time.Duration][]models.Rollup
+}
+
 // NewStorage creates a new in-memory storage.
 func NewStorage() *MemStorage {
 	return &MemStorage{
@@ -26,17 +38,49 @@ func NewStorage() *MemStorage {
 	}
 }
 
-// Update updates metrics in the storage.
+// NewStorageWithHistory creates a new in-memory storage which keeps the history of metrics.
+// Samples are kept for the retention, rollups are kept until they are deleted.
+func NewStorageWithHistory(retention time.Duration) *MemStorage {
+	st := NewStorage()
+	st.history = &memHistory{
+		retention: retention,
+		rollups:   make(map[time.Duration][]models.Rollup),
+	}
+
+	return st
+}
+
+// Update updates metrics in the storage. The agent updates the storage with
+// runtime statistics, the server updates it with batches of received metrics.
 func (st *MemStorage) Update(_ context.Context, v any) error {
 	st.Lock()
 	defer st.Unlock()
 
+	if batch, ok := v.([]models.MetricDB); ok {
+		if err := checkTypes(batch); err != nil {
+			return err
+		}
+
+		for _, m := range batch {
+			if err := st.set(m.Name, m.Metric); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	m, ok := v.(runtime.MemStats)
 	if !ok {
 		return errors.New("invalid input data")
 	}
 
-	st.Storage = map[string]models.Metric{
+	if st.Storage == nil {
+		st.Storage = make(map[string]models.Metric, 30)
+	}
+
+	// Runtime statistics are set in place, so metrics of collectors are kept
+	for k, v := range map[string]models.Metric{
 		"Alloc":         {Type: "gauge", Val: float64(m.Alloc)},
 		"BuckHashSys":   {Type: "gauge", Val: float64(m.BuckHashSys)},
 		"Frees":         {Type: "gauge", Val: float64(m.Frees)},
@@ -64,6 +108,8 @@ func (st *MemStorage) Update(_ context.Context, v any) error {
 		"StackSys":      {Type: "gauge", Val: float64(m.StackSys)},
 		"Sys":           {Type: "gauge", Val: float64(m.Sys)},
 		"TotalAlloc":    {Type: "gauge", Val: float64(m.TotalAlloc)},
+	} {
+		st.Storage[k] = v
 	}
 
 	val := rand.Float64()
@@ -80,6 +126,10 @@ func (st *MemStorage) SetVal(_ context.Context, k string, m models.Metric) error
 	st.Lock()
 	defer st.Unlock()
 
+	return st.set(k, m)
+}
+
+func (st *MemStorage) set(k string, m models.Metric) error {
 	switch m.Type {
 	case "gauge":
 		st.Storage[k] = m
@@ -94,6 +144,27 @@ func (st *MemStorage) SetVal(_ context.Context, k string, m models.Metric) error
 		return errors.New("invalid metric type")
 	}
 
+	if st.history != nil {
+		return st.history.add(k, st.Storage[k], time.Now())
+	}
+
+	return nil
+}
+
+// add appends the sample and drops samples older than the retention.
+func (h *memHistory) add(k string, m models.Metric, now time.Time) error {
+	v, err := toFloat(m.Val)
+	if err != nil {
+		return err
+	}
+
+	h.samples = append(h.samples, models.Sample{Name: k, Type: m.Type, Value: v, Time: now})
+
+	cutoff := now.Add(-h.retention)
+	if i := sort.Search(len(h.samples), func(i int) bool { return !h.samples[i].Time.Before(cutoff) }); i > 0 {
+		h.samples = h.samples[i:]
+	}
+
 	return nil
 }
 
@@ -139,3 +210,116 @@ func (st *MemStorage) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
+
+// Samples returns samples stored in [from, to) ordered by time.
+// Samples of all metrics are returned if the name is empty.
+func (st *MemStorage) Samples(_ context.Context, name string, from, to time.Time) ([]models.Sample, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	if st.history == nil {
+		return nil, errNoHistory
+	}
+
+	samples := st.history.samples
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].Time.Before(from) })
+
+	var res []models.Sample
+	for ; i < len(samples) && samples[i].Time.Before(to); i++ {
+		if name == "" || samples[i].Name == name {
+			res = append(res, samples[i])
+		}
+	}
+
+	return res, nil
+}
+
+// WriteRollups stores rollups replacing the ones of the same metric and interval.
+func (st *MemStorage) WriteRollups(_ context.Context, resolution time.Duration, rollups []models.Rollup) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if st.history == nil {
+		return errNoHistory
+	}
+
+	type key struct {
+		name  string
+		start int64
+	}
+
+	stored := st.history.rollups[resolution]
+	idx := make(map[key]int, len(stored))
+	for i, r := range stored {
+		idx[key{r.Name, r.Start.UnixNano()}] = i
+	}
+
+	for _, r := range rollups {
+		k := key{r.Name, r.Start.UnixNano()}
+		if i, ok := idx[k]; ok {
+			stored[i] = r
+			continue
+		}
+
+		idx[k] = len(stored)
+		stored = append(stored, r)
+	}
+
+	sort.SliceStable(stored, func(i, j int) bool { return stored[i].Start.Before(stored[j].Start) })
+	st.history.rollups[resolution] = stored
+
+	return nil
+}
+
+// Rollups returns rollups of the metric starting in [from, to) ordered by start.
+// Rollups of all metrics are returned if the name is empty.
+func (st *MemStorage) Rollups(_ context.Context, name string, resolution time.Duration, from, to time.Time) ([]models.Rollup, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	if st.history == nil {
+		return nil, errNoHistory
+	}
+
+	var res []models.Rollup
+	for _, r := range st.history.rollups[resolution] {
+		if (name == "" || r.Name == name) && !r.Start.Before(from) && r.Start.Before(to) {
+			res = append(res, r)
+		}
+	}
+
+	return res, nil
+}
+
+// LastRollup returns the start of the latest rollup, zero time if there are none.
+func (st *MemStorage) LastRollup(_ context.Context, resolution time.Duration) (time.Time, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	if st.history == nil {
+		return time.Time{}, errNoHistory
+	}
+
+	stored := st.history.rollups[resolution]
+	if len(stored) == 0 {
+		return time.Time{}, nil
+	}
+
+	return stored[len(stored)-1].Start, nil
+}
+
+// DeleteRollups deletes rollups starting before the time.
+func (st *MemStorage) DeleteRollups(_ context.Context, resolution time.Duration, before time.Time) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if st.history == nil {
+		return errNoHistory
+	}
+
+	stored := st.history.rollups[resolution]
+	i := sort.Search(len(stored), func(i int) bool { return !stored[i].Start.Before(before) })
+	st.history.rollups[resolution] = stored[i:]
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/models"
 )
@@ -253,6 +254,35 @@ func TestMemStorage_Update(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "test 3, batch",
+			args: args{
+				v: []models.MetricDB{
+					{Name: "counter", Metric: models.Metric{Type: "counter", Val: int64(1)}},
+					{Name: "counter", Metric: models.Metric{Type: "counter", Val: int64(2)}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "test 4, batch with invalid type",
+			args: args{
+				v: []models.MetricDB{
+					{Name: "metric", Metric: models.Metric{Type: "invalid", Val: int64(1)}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "test 5, batch with different types of a metric",
+			args: args{
+				v: []models.MetricDB{
+					{Name: "mixed", Metric: models.Metric{Type: "gauge", Val: 1.5}},
+					{Name: "mixed", Metric: models.Metric{Type: "counter", Val: int64(1)}},
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	st := &MemStorage{
@@ -266,4 +296,99 @@ func TestMemStorage_Update(t *testing.T) {
 			}
 		})
 	}
+
+	// Counters of the batch are summed
+	if got := st.Storage["counter"].Val; got != int64(3) {
+		t.Errorf("Update() counter = %v, want 3", got)
+	}
+}
+
+func TestMemStorage_UpdateKeepsCollected(t *testing.T) {
+	st := NewStorage()
+	ctx := context.Background()
+
+	// Counters of collectors are accumulated across updates of runtime statistics
+	for _, v := range []int64{2, 3} {
+		if err := st.SetVal(ctx, "Backups", models.Metric{Type: "counter", Val: v}); err != nil {
+			t.Fatalf("SetVal() error = %v", err)
+		}
+		if err := st.Update(ctx, runtime.MemStats{}); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+
+	if got := st.Storage["Backups"].Val; got != int64(5) {
+		t.Errorf("Update() Backups = %v, want 5", got)
+	}
+	if got := st.Storage["PollCount"].Val; got != int64(2) {
+		t.Errorf("Update() PollCount = %v, want 2", got)
+	}
+}
+
+func TestMemStorage_History(t *testing.T) {
+	ctx := context.Background()
+	st := NewStorageWithHistory(time.Hour)
+
+	if err := st.SetVal(ctx, "hits", models.Metric{Type: "counter", Val: int64(2)}); err != nil {
+		t.Fatalf("SetVal() error = %v", err)
+	}
+	if err := st.SetVal(ctx, "hits", models.Metric{Type: "counter", Val: int64(3)}); err != nil {
+		t.Fatalf("SetVal() error = %v", err)
+	}
+
+	now := time.Now()
+	samples, err := st.Samples(ctx, "hits", now.Add(-time.Minute), now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Samples() error = %v", err)
+	}
+	// Values of counters are their totals
+	if len(samples) != 2 || samples[0].Value != 2 || samples[1].Value != 5 {
+		t.Errorf("Samples() got = %v", samples)
+	}
+
+	// Samples older than the retention are dropped
+	if err := st.history.add("hits", models.Metric{Type: "counter", Val: int64(6)}, now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("add() error = %v", err)
+	}
+	if len(st.history.samples) != 1 {
+		t.Errorf("samples after retention = %d, want 1", len(st.history.samples))
+	}
+
+	start := now.Truncate(time.Minute)
+	rollups := []models.Rollup{
+		{Name: "hits", Type: "counter", Start: start.Add(time.Minute), Increase: 1},
+		{Name: "hits", Type: "counter", Start: start, Increase: 2},
+	}
+	if err := st.WriteRollups(ctx, time.Minute, rollups); err != nil {
+		t.Fatalf("WriteRollups() error = %v", err)
+	}
+	// Rollups of the same metric and interval are replaced
+	if err := st.WriteRollups(ctx, time.Minute, rollups[:1]); err != nil {
+		t.Fatalf("WriteRollups() error = %v", err)
+	}
+
+	got, err := st.Rollups(ctx, "hits", time.Minute, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Rollups() error = %v", err)
+	}
+	if len(got) != 2 || !got[0].Start.Equal(start) {
+		t.Errorf("Rollups() got = %v", got)
+	}
+
+	last, err := st.LastRollup(ctx, time.Minute)
+	if err != nil || !last.Equal(start.Add(time.Minute)) {
+		t.Errorf("LastRollup() got = %v, %v", last, err)
+	}
+
+	if err := st.DeleteRollups(ctx, time.Minute, start.Add(time.Minute)); err != nil {
+		t.Fatalf("DeleteRollups() error = %v", err)
+	}
+	if got, _ := st.Rollups(ctx, "hits", time.Minute, start, start.Add(time.Hour)); len(got) != 1 {
+		t.Errorf("Rollups() after DeleteRollups got = %v", got)
+	}
+
+	// The storage without history doesn't keep it
+	if _, err := NewStorage().Samples(ctx, "", now, now); err == nil {
+		t.Errorf("Samples() of the storage without history error = nil")
+	}
 }
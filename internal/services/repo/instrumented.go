@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+)
+
+type (
+	// instrumented observes latency and counts errors of repository operations.
+	instrumented struct {
+		repo Repository
+		reg  *telemetry.Registry
+	}
+
+	// instrumentedPinger is an instrumented repository which can check the connection.
+	instrumentedPinger struct {
+		instrumented
+		pinger interface{ Ping() error }
+	}
+)
+
+// Instrument wraps the repository to track latency and errors of its operations
+// in the registry. If the repository can check the connection, the returned one can do it too.
+func Instrument(r Repository, reg *telemetry.Registry) Repository {
+	i := instrumented{repo: r, reg: reg}
+	if p, ok := r.(interface{ Ping() error }); ok {
+		return &instrumentedPinger{instrumented: i, pinger: p}
+	}
+
+	return &i
+}
+
+func (i *instrumented) ReadAll(ctx context.Context) (map[string]models.Metric, error) {
+	t := time.Now()
+	metrics, err := i.repo.ReadAll(ctx)
+	i.observe("ReadAll", t, err)
+
+	return metrics, err
+}
+
+func (i *instrumented) Update(ctx context.Context, v any) error {
+	t := time.Now()
+	err := i.repo.Update(ctx, v)
+	i.observe("Update", t, err)
+
+	return err
+}
+
+func (i *instrumented) SetVal(ctx context.Context, k string, m models.Metric) error {
+	t := time.Now()
+	err := i.repo.SetVal(ctx, k, m)
+	i.observe("SetVal", t, err)
+
+	return err
+}
+
+func (i *instrumented) GetVal(ctx context.Context, k string) (models.Metric, error) {
+	t := time.Now()
+	m, err := i.repo.GetVal(ctx, k)
+	i.observe("GetVal", t, err)
+
+	return m, err
+}
+
+// Unwrap returns the wrapped repository.
+func (i *instrumented) Unwrap() Repository {
+	return i.repo
+}
+
+func (i *instrumentedPinger) Ping() error {
+	t := time.Now()
+	err := i.pinger.Ping()
+	i.observe("Ping", t, err)
+
+	return err
+}
+
+func (i *instrumented) observe(op string, t time.Time, err error) {
+	labels := map[string]string{"operation": op}
+	i.reg.Histogram(telemetry.ServerPrefix+"repo_operation_duration_seconds", labels, nil).
+		Observe(time.Since(t).Seconds())
+
+	if err != nil {
+		i.reg.Counter(telemetry.ServerPrefix+"repo_errors_total", labels).Inc()
+	}
+}
@@ -0,0 +1,31 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument(t *testing.T) {
+	reg := telemetry.NewRegistry()
+	r := Instrument(NewStorage(), reg)
+
+	_, isPinger := r.(interface{ Ping() error })
+	assert.False(t, isPinger)
+
+	ctx := context.Background()
+	assert.NoError(t, r.SetVal(ctx, "a", models.Metric{Type: "gauge", Val: 1.0}))
+	_, err := r.GetVal(ctx, "b")
+	assert.Error(t, err)
+
+	assert.Equal(t, int64(1),
+		reg.Histogram("server_repo_operation_duration_seconds", map[string]string{"operation": "SetVal"}, nil).Count())
+	assert.Equal(t, int64(0), reg.Counter("server_repo_errors_total", map[string]string{"operation": "SetVal"}).Value())
+	assert.Equal(t, int64(1), reg.Counter("server_repo_errors_total", map[string]string{"operation": "GetVal"}).Value())
+
+	_, isPinger = Instrument(&PGStorage{}, reg).(interface{ Ping() error })
+	assert.True(t, isPinger)
+}
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+)
+
+// PoolConfig holds settings of the connection pool of the database. Zero values
+// keep the values set by pool_* parameters of the DSN or the defaults of pgxpool.
+type PoolConfig struct {
+	// MaxConns is the maximum number of connections
+	MaxConns int32
+
+	// MinConns is the number of connections kept open when the pool is idle
+	MinConns int32
+
+	// MaxConnLifetime is the time after which a connection is closed and replaced
+	MaxConnLifetime time.Duration
+
+	// MaxConnIdleTime is the time after which an idle connection is closed
+	MaxConnIdleTime time.Duration
+
+	// HealthCheckPeriod is the period of checks of idle connections
+	HealthCheckPeriod time.Duration
+
+	// StatementCache is the mode queries are executed in, one of cache_statement,
+	// cache_describe, describe_exec, exec and simple_protocol. Modes without
+	// the statement cache are needed behind poolers like PgBouncer in transaction mode
+	StatementCache string
+}
+
+// ParseQueryExecMode parses the mode queries are executed in, empty mode is the default one.
+func ParseQueryExecMode(s string) (pgx.QueryExecMode, error) {
+	switch s {
+	case "", "cache_statement":
+		return pgx.QueryExecModeCacheStatement, nil
+	case "cache_describe":
+		return pgx.QueryExecModeCacheDescribe, nil
+	case "describe_exec":
+		return pgx.QueryExecModeDescribeExec, nil
+	case "exec":
+		return pgx.QueryExecModeExec, nil
+	case "simple_protocol":
+		return pgx.QueryExecModeSimpleProtocol, nil
+	default:
+		return 0, fmt.Errorf("invalid statement cache mode: %s", s)
+	}
+}
+
+// newPoolConfig parses the DSN and applies the settings over it.
+func newPoolConfig(dsn string, p PoolConfig) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.MaxConns > 0 {
+		cfg.MaxConns = p.MaxConns
+	}
+	if p.MinConns > 0 {
+		cfg.MinConns = p.MinConns
+	}
+	if p.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = p.MaxConnLifetime
+	}
+	if p.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = p.MaxConnIdleTime
+	}
+	if p.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = p.HealthCheckPeriod
+	}
+	if p.StatementCache != "" {
+		mode, err := ParseQueryExecMode(p.StatementCache)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ConnConfig.DefaultQueryExecMode = mode
+	}
+
+	return cfg, nil
+}
+
+// poolStats sets self-metrics of the pool from its statistics.
+func poolStats(pool *pgxpool.Pool, reg *telemetry.Registry) func() {
+	const prefix = telemetry.ServerPrefix + "db_pool_"
+
+	counter := func(name string, v int64) {
+		c := reg.Counter(prefix+name, nil)
+		c.Add(v - c.Value())
+	}
+
+	// Counters are advanced to the totals of the pool, concurrent scrapes must not add twice
+	var mu sync.Mutex
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		s := pool.Stat()
+
+		reg.Gauge(prefix+"max_conns", nil).Set(float64(s.MaxConns()))
+		reg.Gauge(prefix+"total_conns", nil).Set(float64(s.TotalConns()))
+		reg.Gauge(prefix+"acquired_conns", nil).Set(float64(s.AcquiredConns()))
+		reg.Gauge(prefix+"idle_conns", nil).Set(float64(s.IdleConns()))
+		reg.Gauge(prefix+"constructing_conns", nil).Set(float64(s.ConstructingConns()))
+		reg.Gauge(prefix+"acquire_duration_seconds_total", nil).Set(s.AcquireDuration().Seconds())
+
+		counter("acquires_total", s.AcquireCount())
+		counter("empty_acquires_total", s.EmptyAcquireCount())
+		counter("canceled_acquires_total", s.CanceledAcquireCount())
+		counter("new_conns_total", s.NewConnsCount())
+		counter("max_lifetime_destroys_total", s.MaxLifetimeDestroyCount())
+		counter("max_idle_destroys_total", s.MaxIdleDestroyCount())
+	}
+}
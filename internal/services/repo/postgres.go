@@ -2,35 +2,59 @@ package repo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jmoiron/sqlx"
 	"github.com/leonf08/metrics-yp.git/internal/database/migrations/postgres"
 	"github.com/leonf08/metrics-yp.git/internal/errorhandling"
 	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
 )
 
 // PGStorage is database implementation of metrics storage.
 type PGStorage struct {
-	db *sqlx.DB
+	db   *sqlx.DB
+	pool *pgxpool.Pool
+	log  zerolog.Logger
+	reg  *telemetry.Registry
 }
 
-// NewDB creates a new database connection.
-func NewDB(sourceName string) (*PGStorage, error) {
+// NewDB creates a new pool of connections to the database configured by the pool settings.
+// Migrations of the schema are applied if autoMigrate is set.
+// Failures to append to the history of metrics are logged.
+// Retries of operations and statistics of the pool are exposed in the registry if it is not nil.
+func NewDB(sourceName string, p PoolConfig, autoMigrate bool, log zerolog.Logger,
+	reg *telemetry.Registry) (*PGStorage, error) {
 	if sourceName == "" {
 		return nil, nil
 	}
 
-	db, err := postgres.NewConnection(sourceName)
+	cfg, err := newPoolConfig(sourceName, p)
 	if err != nil {
 		return nil, err
 	}
 
+	db, pool, err := postgres.NewConnection(cfg, autoMigrate)
+	if err != nil {
+		return nil, err
+	}
+
+	if reg != nil {
+		reg.OnCollect(poolStats(pool, reg))
+	}
+
 	return &PGStorage{
-		db: db,
+		db:   db,
+		pool: pool,
+		log:  log,
+		reg:  reg,
 	}, nil
 }
 
@@ -39,7 +63,11 @@ func (st *PGStorage) Ping() error {
 	return st.db.Ping()
 }
 
-// Update updates metrics in the storage.
+// Update updates metrics in the storage. The batch is written with a single
+// multi-row upsert, so it costs one round trip regardless of its size.
+// The updated values are appended to the history of metrics, see appendHistory.
+// Values of counters with the same name are summed before the upsert,
+// the last value of a gauge wins.
 func (st *PGStorage) Update(ctx context.Context, v any) error {
 	metrics, ok := v.([]models.MetricDB)
 	if !ok {
@@ -48,55 +76,86 @@ func (st *PGStorage) Update(ctx context.Context, v any) error {
 
 	const queryStr = `
 		INSERT INTO metrics (NAME, TYPE, VALUE)
-		VALUES ($1, $2, $3)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::double precision[])
 		ON CONFLICT (NAME)
 		DO UPDATE SET
 		VALUE = CASE
-			WHEN $2 = 'counter' THEN metrics.VALUE + $3
-			ELSE $3
+			WHEN EXCLUDED.TYPE = 'counter' THEN metrics.VALUE + EXCLUDED.VALUE
+			ELSE EXCLUDED.VALUE
 		END
-		WHERE metrics.NAME = $1`
+		RETURNING NAME, TYPE, VALUE`
 
-	fn := func() error {
-		tx, err := st.db.BeginTxx(ctx, nil)
-		if err != nil {
-			return err
-		}
-
-		defer tx.Rollback()
+	names, types, values, err := aggregate(metrics)
+	if err != nil {
+		return err
+	}
 
-		stmt, err := tx.PreparexContext(ctx, queryStr)
-		if err != nil {
-			return err
-		}
+	if len(names) == 0 {
+		return nil
+	}
 
-		defer stmt.Close()
+	var updated []models.Sample
+	err = st.retry(ctx, "Update", func() error {
+		updated = nil
+		return retriable(st.db.SelectContext(ctx, &updated, queryStr, names, types, values))
+	})
+	if err != nil {
+		return err
+	}
 
-		for _, m := range metrics {
-			_, err = stmt.ExecContext(ctx, m.Name, m.Type, m.Val)
-			if err != nil {
-				return err
-			}
-		}
+	st.appendHistory(ctx, updated)
+	return nil
+}
 
-		return tx.Commit()
+// aggregate merges metrics with the same name, as a row can't be upserted twice
+// by one statement. It returns columns of the merged rows in the order of first
+// occurrence of their names. Batches updating a metric with different types are rejected.
+func aggregate(metrics []models.MetricDB) ([]string, []string, []float64, error) {
+	if err := checkTypes(metrics); err != nil {
+		return nil, nil, nil, err
 	}
 
-	err := errorhandling.Retry(ctx, func() error {
-		err := fn()
+	idx := make(map[string]int, len(metrics))
+	names := make([]string, 0, len(metrics))
+	types := make([]string, 0, len(metrics))
+	values := make([]float64, 0, len(metrics))
+
+	for _, m := range metrics {
+		val, err := toFloat(m.Val)
 		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) &&
-				(pgerrcode.IsInsufficientResources(pgErr.Code) ||
-					pgerrcode.IsConnectionException(pgErr.Code)) {
-				err = errorhandling.ErrRetriable
-			}
+			return nil, nil, nil, fmt.Errorf("metric %s: %w", m.Name, err)
 		}
 
-		return err
-	})
+		i, ok := idx[m.Name]
+		if !ok {
+			idx[m.Name] = len(names)
+			names = append(names, m.Name)
+			types = append(types, m.Type)
+			values = append(values, val)
+			continue
+		}
 
-	return err
+		if m.Type == "counter" {
+			values[i] += val
+		} else {
+			values[i] = val
+		}
+	}
+
+	return names, types, values, nil
+}
+
+func toFloat(v any) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("invalid value type %T", v)
+	}
 }
 
 // ReadAll returns all metrics.
@@ -104,7 +163,7 @@ func (st *PGStorage) ReadAll(ctx context.Context) (map[string]models.Metric, err
 	const queryStr = `SELECT * FROM metrics`
 
 	var rows *sqlx.Rows
-	err := errorhandling.Retry(ctx, func() error {
+	err := st.retry(ctx, "ReadAll", func() error {
 		var err error
 		r, err := st.db.QueryxContext(ctx, queryStr)
 		if err != nil {
@@ -155,7 +214,8 @@ func (st *PGStorage) ReadAll(ctx context.Context) (map[string]models.Metric, err
 	return metrics, nil
 }
 
-// SetVal sets a value for a metric.
+// SetVal sets a value for a metric. The updated value is appended to the history of metrics,
+// see appendHistory.
 func (st *PGStorage) SetVal(ctx context.Context, k string, m models.Metric) error {
 	const queryStr = `
 		INSERT INTO metrics (NAME, TYPE, VALUE)
@@ -166,23 +226,48 @@ func (st *PGStorage) SetVal(ctx context.Context, k string, m models.Metric) erro
 			WHEN $2 = 'counter' THEN metrics.VALUE + $3
 			ELSE $3
 		END
-		WHERE metrics.NAME = $1`
-
-	err := errorhandling.Retry(ctx, func() error {
-		_, err := st.db.ExecContext(ctx, queryStr, k, m.Type, m.Val)
-		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) &&
-				(pgerrcode.IsInsufficientResources(pgErr.Code) ||
-					pgerrcode.IsConnectionException(pgErr.Code)) {
-				err = errorhandling.ErrRetriable
-			}
-		}
+		WHERE metrics.NAME = $1
+		RETURNING NAME, TYPE, VALUE`
 
-		return err
+	var updated []models.Sample
+	err := st.retry(ctx, "SetVal", func() error {
+		updated = nil
+		return retriable(st.db.SelectContext(ctx, &updated, queryStr, k, m.Type, m.Val))
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	st.appendHistory(ctx, updated)
+	return nil
+}
+
+// appendHistory appends updated values of metrics to the history. The history is written
+// by a separate statement, so updates don't fail if it can't be written, for example
+// if maintenance has not created the partition for the current time. Such failures
+// are logged and counted.
+func (st *PGStorage) appendHistory(ctx context.Context, updated []models.Sample) {
+	const queryStr = `
+		INSERT INTO metric_samples (NAME, TYPE, VALUE)
+		SELECT * FROM unnest($1::text[], $2::text[], $3::double precision[])`
+
+	if len(updated) == 0 {
+		return
+	}
+
+	names := make([]string, len(updated))
+	types := make([]string, len(updated))
+	values := make([]float64, len(updated))
+	for i, u := range updated {
+		names[i], types[i], values[i] = u.Name, u.Type, u.Value
+	}
+
+	if _, err := st.db.ExecContext(ctx, queryStr, names, types, values); err != nil {
+		st.log.Error().Err(err).Int("samples", len(updated)).Msg("repo - PGStorage - appendHistory")
+		if st.reg != nil {
+			st.reg.Counter(telemetry.ServerPrefix+"repo_history_errors_total", nil).Inc()
+		}
+	}
 }
 
 // GetVal returns a value for a metric.
@@ -191,7 +276,7 @@ func (st *PGStorage) GetVal(ctx context.Context, k string) (models.Metric, error
 
 	var m models.Metric
 
-	err := errorhandling.Retry(ctx, func() error {
+	err := st.retry(ctx, "GetVal", func() error {
 		err := st.db.GetContext(ctx, &m, queryStr, k)
 		if err != nil {
 			var pgErr *pgconn.PgError
@@ -208,7 +293,128 @@ func (st *PGStorage) GetVal(ctx context.Context, k string) (models.Metric, error
 	return m, err
 }
 
-// Close closes the database connection.
+// Samples returns samples stored in [from, to) ordered by time.
+// Samples of all metrics are returned if the name is empty.
+func (st *PGStorage) Samples(ctx context.Context, name string, from, to time.Time) ([]models.Sample, error) {
+	const queryStr = `
+		SELECT NAME, TYPE, VALUE, TS FROM metric_samples
+		WHERE TS >= $1 AND TS < $2 AND ($3 = '' OR NAME = $3)
+		ORDER BY TS`
+
+	var samples []models.Sample
+	err := st.retry(ctx, "Samples", func() error {
+		samples = nil
+		return retriable(st.db.SelectContext(ctx, &samples, queryStr, from, to, name))
+	})
+
+	return samples, err
+}
+
+// WriteRollups stores rollups with a single multi-row upsert replacing the ones
+// of the same metric and interval.
+func (st *PGStorage) WriteRollups(ctx context.Context, resolution time.Duration, rollups []models.Rollup) error {
+	const queryStr = `
+		INSERT INTO metric_rollups (RESOLUTION, NAME, TYPE, START, COUNT, MIN, MAX, AVG, LAST, INCREASE, RATE)
+		SELECT $1::bigint, * FROM unnest($2::text[], $3::text[], $4::timestamptz[], $5::bigint[],
+			$6::double precision[], $7::double precision[], $8::double precision[], $9::double precision[],
+			$10::double precision[], $11::double precision[])
+		ON CONFLICT (RESOLUTION, NAME, START)
+		DO UPDATE SET
+		TYPE = EXCLUDED.TYPE, COUNT = EXCLUDED.COUNT,
+		MIN = EXCLUDED.MIN, MAX = EXCLUDED.MAX, AVG = EXCLUDED.AVG, LAST = EXCLUDED.LAST,
+		INCREASE = EXCLUDED.INCREASE, RATE = EXCLUDED.RATE`
+
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	var (
+		names, types                              []string
+		starts                                    []time.Time
+		counts                                    []int64
+		mins, maxs, avgs, lasts, increases, rates []float64
+	)
+	for _, r := range rollups {
+		names, types = append(names, r.Name), append(types, r.Type)
+		starts, counts = append(starts, r.Start), append(counts, r.Count)
+		mins, maxs = append(mins, r.Min), append(maxs, r.Max)
+		avgs, lasts = append(avgs, r.Avg), append(lasts, r.Last)
+		increases, rates = append(increases, r.Increase), append(rates, r.Rate)
+	}
+
+	return st.retry(ctx, "WriteRollups", func() error {
+		_, err := st.db.ExecContext(ctx, queryStr, int64(resolution.Seconds()), names, types, starts, counts,
+			mins, maxs, avgs, lasts, increases, rates)
+		return retriable(err)
+	})
+}
+
+// Rollups returns rollups of the metric starting in [from, to) ordered by start.
+// Rollups of all metrics are returned if the name is empty.
+func (st *PGStorage) Rollups(ctx context.Context, name string, resolution time.Duration, from, to time.Time) ([]models.Rollup, error) {
+	const queryStr = `
+		SELECT NAME, TYPE, START, COUNT, MIN, MAX, AVG, LAST, INCREASE, RATE FROM metric_rollups
+		WHERE RESOLUTION = $1 AND ($2 = '' OR NAME = $2) AND START >= $3 AND START < $4
+		ORDER BY START`
+
+	var rollups []models.Rollup
+	err := st.retry(ctx, "Rollups", func() error {
+		rollups = nil
+		return retriable(st.db.SelectContext(ctx, &rollups, queryStr, int64(resolution.Seconds()), name, from, to))
+	})
+
+	return rollups, err
+}
+
+// LastRollup returns the start of the latest rollup, zero time if there are none.
+func (st *PGStorage) LastRollup(ctx context.Context, resolution time.Duration) (time.Time, error) {
+	const queryStr = `SELECT max(START) FROM metric_rollups WHERE RESOLUTION = $1`
+
+	var last sql.NullTime
+	err := st.retry(ctx, "LastRollup", func() error {
+		return retriable(st.db.GetContext(ctx, &last, queryStr, int64(resolution.Seconds())))
+	})
+
+	return last.Time, err
+}
+
+// DeleteRollups deletes rollups starting before the time.
+func (st *PGStorage) DeleteRollups(ctx context.Context, resolution time.Duration, before time.Time) error {
+	const queryStr = `DELETE FROM metric_rollups WHERE RESOLUTION = $1 AND START < $2`
+
+	return st.retry(ctx, "DeleteRollups", func() error {
+		_, err := st.db.ExecContext(ctx, queryStr, int64(resolution.Seconds()), before)
+		return retriable(err)
+	})
+}
+
+// retriable replaces errors caused by lack of resources or connection problems with ErrRetriable.
+func retriable(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) &&
+		(pgerrcode.IsInsufficientResources(pgErr.Code) ||
+			pgerrcode.IsConnectionException(pgErr.Code)) {
+		return errorhandling.ErrRetriable
+	}
+
+	return err
+}
+
+// retry retries the operation and counts retries.
+func (st *PGStorage) retry(ctx context.Context, op string, f func() error) error {
+	return errorhandling.RetryNotify(ctx, f, func(error) {
+		if st.reg != nil {
+			st.reg.Counter(telemetry.ServerPrefix+"repo_retries_total", map[string]string{"operation": op}).Inc()
+		}
+	})
+}
+
+// Close closes the database connection and the pool.
 func (st *PGStorage) Close() error {
-	return st.db.Close()
+	err := st.db.Close()
+	if st.pool != nil {
+		st.pool.Close()
+	}
+
+	return err
 }
@@ -2,16 +2,29 @@ package repo
 
 import (
 	"context"
+	"database/sql/driver"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jmoiron/sqlx"
 	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
 	"github.com/stretchr/testify/assert"
 )
 
+// anyConverter passes arguments to the mocked driver as they are, like the pgx driver
+// which accepts slices for array parameters.
+type anyConverter struct{}
+
+func (anyConverter) ConvertValue(v any) (driver.Value, error) {
+	return v, nil
+}
+
 func TestPGStorage_Update(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyConverter{}))
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
@@ -19,12 +32,14 @@ func TestPGStorage_Update(t *testing.T) {
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
 
-	mock.ExpectBegin()
-	prep := mock.ExpectPrepare("INSERT INTO metrics")
-	prep.ExpectExec().
-		WithArgs("name", "counter", 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectCommit()
+	mock.ExpectQuery("INSERT INTO metrics").
+		WithArgs([]string{"name", "gauge"}, []string{"counter", "gauge"}, []float64{3, 2.5}).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "type", "value"}).
+			AddRow("name", "counter", 10).
+			AddRow("gauge", "gauge", 2.5))
+	mock.ExpectExec("INSERT INTO metric_samples").
+		WithArgs([]string{"name", "gauge"}, []string{"counter", "gauge"}, []float64{10, 2.5}).
+		WillReturnResult(sqlmock.NewResult(0, 2))
 
 	st := &PGStorage{db: sqlxDB}
 
@@ -33,7 +48,28 @@ func TestPGStorage_Update(t *testing.T) {
 			Name: "name",
 			Metric: models.Metric{
 				Type: "counter",
-				Val:  1,
+				Val:  int64(1),
+			},
+		},
+		{
+			Name: "gauge",
+			Metric: models.Metric{
+				Type: "gauge",
+				Val:  1.5,
+			},
+		},
+		{
+			Name: "name",
+			Metric: models.Metric{
+				Type: "counter",
+				Val:  int64(2),
+			},
+		},
+		{
+			Name: "gauge",
+			Metric: models.Metric{
+				Type: "gauge",
+				Val:  2.5,
 			},
 		},
 	}
@@ -47,7 +83,7 @@ func TestPGStorage_Update(t *testing.T) {
 }
 
 func TestPGStorage_UpdateExecErr(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyConverter{}))
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
@@ -55,10 +91,9 @@ func TestPGStorage_UpdateExecErr(t *testing.T) {
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
 
-	mock.ExpectBegin()
-	prep := mock.ExpectPrepare("INSERT INTO metrics")
-	prep.ExpectExec().WithArgs("name", "counter", 1).WillReturnError(assert.AnError)
-	mock.ExpectRollback()
+	mock.ExpectQuery("INSERT INTO metrics").
+		WithArgs([]string{"name"}, []string{"counter"}, []float64{1}).
+		WillReturnError(assert.AnError)
 
 	st := &PGStorage{db: sqlxDB}
 
@@ -80,8 +115,8 @@ func TestPGStorage_UpdateExecErr(t *testing.T) {
 	}
 }
 
-func TestPGStorage_UpdateBeginErr(t *testing.T) {
-	db, mock, err := sqlmock.New()
+func TestPGStorage_UpdateRetry(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyConverter{}))
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
@@ -89,7 +124,13 @@ func TestPGStorage_UpdateBeginErr(t *testing.T) {
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
 
-	mock.ExpectBegin().WillReturnError(assert.AnError)
+	mock.ExpectQuery("INSERT INTO metrics").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.ConnectionFailure})
+	mock.ExpectQuery("INSERT INTO metrics").
+		WithArgs([]string{"name"}, []string{"counter"}, []float64{1}).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "type", "value"}).AddRow("name", "counter", 1))
+	mock.ExpectExec("INSERT INTO metric_samples").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	st := &PGStorage{db: sqlxDB}
 
@@ -98,85 +139,104 @@ func TestPGStorage_UpdateBeginErr(t *testing.T) {
 			Name: "name",
 			Metric: models.Metric{
 				Type: "counter",
-				Val:  1,
+				Val:  int64(1),
 			},
 		},
 	}
 
 	err = st.Update(context.Background(), m)
-	assert.Error(t, err)
+	assert.NoError(t, err)
 
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
 
-func TestPGStorage_UpdatePrepareErr(t *testing.T) {
+func TestPGStorage_UpdateEmpty(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
 
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	st := &PGStorage{db: sqlx.NewDb(db, "sqlmock")}
 
-	mock.ExpectBegin()
-	mock.ExpectPrepare("INSERT INTO metrics").WillReturnError(assert.AnError)
-	mock.ExpectRollback()
+	err = st.Update(context.Background(), []models.MetricDB{})
+	assert.NoError(t, err)
 
-	st := &PGStorage{db: sqlxDB}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStorage_UpdateInvalidValueErr(t *testing.T) {
+	st := &PGStorage{}
 
 	m := []models.MetricDB{
 		{
 			Name: "name",
 			Metric: models.Metric{
 				Type: "counter",
-				Val:  1,
+				Val:  "1",
 			},
 		},
 	}
 
-	err = st.Update(context.Background(), m)
+	err := st.Update(context.Background(), m)
 	assert.Error(t, err)
-
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
-	}
 }
 
-func TestPGStorage_UpdateCommitErr(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	defer db.Close()
-
-	sqlxDB := sqlx.NewDb(db, "sqlmock")
-
-	mock.ExpectBegin()
-	prep := mock.ExpectPrepare("INSERT INTO metrics")
-	prep.ExpectExec().
-		WithArgs("name", "counter", 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectCommit().WillReturnError(assert.AnError)
-
-	st := &PGStorage{db: sqlxDB}
-
-	m := []models.MetricDB{
+func Test_aggregate(t *testing.T) {
+	tests := []struct {
+		name       string
+		metrics    []models.MetricDB
+		wantNames  []string
+		wantTypes  []string
+		wantValues []float64
+		wantErr    error
+	}{
 		{
-			Name: "name",
-			Metric: models.Metric{
-				Type: "counter",
-				Val:  1,
+			name: "counters are summed, the last gauge wins",
+			metrics: []models.MetricDB{
+				{Name: "counter", Metric: models.Metric{Type: "counter", Val: int64(1)}},
+				{Name: "gauge", Metric: models.Metric{Type: "gauge", Val: 1.5}},
+				{Name: "counter", Metric: models.Metric{Type: "counter", Val: int64(2)}},
+				{Name: "gauge", Metric: models.Metric{Type: "gauge", Val: 2.5}},
 			},
+			wantNames:  []string{"counter", "gauge"},
+			wantTypes:  []string{"counter", "gauge"},
+			wantValues: []float64{3, 2.5},
+		},
+		{
+			name: "gauge and counter of the same name",
+			metrics: []models.MetricDB{
+				{Name: "mixed", Metric: models.Metric{Type: "gauge", Val: 1.5}},
+				{Name: "mixed", Metric: models.Metric{Type: "counter", Val: int64(1)}},
+			},
+			wantErr: ErrTypeMismatch,
+		},
+		{
+			name: "counter and gauge of the same name",
+			metrics: []models.MetricDB{
+				{Name: "mixed", Metric: models.Metric{Type: "counter", Val: int64(1)}},
+				{Name: "mixed", Metric: models.Metric{Type: "gauge", Val: 1.5}},
+			},
+			wantErr: ErrTypeMismatch,
 		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, types, values, err := aggregate(tt.metrics)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
 
-	err = st.Update(context.Background(), m)
-	assert.Error(t, err)
-
-	if err := mock.ExpectationsWereMet(); err != nil {
-		t.Errorf("there were unfulfilled expectations: %s", err)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantNames, names)
+			assert.Equal(t, tt.wantTypes, types)
+			assert.Equal(t, tt.wantValues, values)
+		})
 	}
 }
 
@@ -223,7 +283,7 @@ func TestPGStorage_PingErr(t *testing.T) {
 }
 
 func TestPGStorage_SetVal(t *testing.T) {
-	db, mock, err := sqlmock.New()
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyConverter{}))
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
@@ -231,8 +291,11 @@ func TestPGStorage_SetVal(t *testing.T) {
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
 
-	mock.ExpectExec("INSERT INTO metrics").
+	mock.ExpectQuery("INSERT INTO metrics").
 		WithArgs("name", "counter", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "type", "value"}).AddRow("name", "counter", 5))
+	mock.ExpectExec("INSERT INTO metric_samples").
+		WithArgs([]string{"name"}, []string{"counter"}, []float64{5}).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	st := &PGStorage{db: sqlxDB}
@@ -254,7 +317,7 @@ func TestPGStorage_SetValExecErr(t *testing.T) {
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
 
-	mock.ExpectExec("INSERT INTO metrics").
+	mock.ExpectQuery("INSERT INTO metrics").
 		WithArgs("name", "counter", 1).
 		WillReturnError(assert.AnError)
 
@@ -268,6 +331,35 @@ func TestPGStorage_SetValExecErr(t *testing.T) {
 	}
 }
 
+func TestPGStorage_SetValHistoryErr(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyConverter{}))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+
+	// There is no partition for the current time, the update is kept anyway
+	mock.ExpectQuery("INSERT INTO metrics").
+		WithArgs("name", "gauge", 1.5).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "type", "value"}).AddRow("name", "gauge", 1.5))
+	mock.ExpectExec("INSERT INTO metric_samples").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.CheckViolation,
+			Message: "no partition of relation \"metric_samples\" found for row"})
+
+	reg := telemetry.NewRegistry()
+	st := &PGStorage{db: sqlxDB, reg: reg}
+
+	err = st.SetVal(context.Background(), "name", models.Metric{Type: "gauge", Val: 1.5})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), reg.Counter(telemetry.ServerPrefix+"repo_history_errors_total", nil).Value())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestPGStorage_GetVal(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -390,3 +482,93 @@ func TestPGStorage_ReadAllInvalidCounterValueErr(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestPGStorage_WriteRollups(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyConverter{}))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO metric_rollups").
+		WithArgs(int64(60), []string{"hits"}, []string{"counter"}, []time.Time{start}, []int64{2},
+			[]float64{0}, []float64{0}, []float64{0}, []float64{5}, []float64{3}, []float64{0.05}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	st := &PGStorage{db: sqlx.NewDb(db, "sqlmock")}
+
+	err = st.WriteRollups(context.Background(), time.Minute, []models.Rollup{
+		{Name: "hits", Type: "counter", Start: start, Count: 2, Last: 5, Increase: 3, Rate: 0.05},
+	})
+	assert.NoError(t, err)
+
+	// Empty batch is not written
+	assert.NoError(t, st.WriteRollups(context.Background(), time.Minute, nil))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStorage_Samples(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	mock.ExpectQuery("SELECT NAME, TYPE, VALUE, TS FROM metric_samples").
+		WithArgs(from, to, "load").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.ConnectionFailure})
+	mock.ExpectQuery("SELECT NAME, TYPE, VALUE, TS FROM metric_samples").
+		WithArgs(from, to, "load").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "type", "value", "ts"}).
+			AddRow("load", "gauge", 1.5, from.Add(time.Minute)))
+
+	st := &PGStorage{db: sqlx.NewDb(db, "sqlmock")}
+
+	samples, err := st.Samples(context.Background(), "load", from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Sample{{Name: "load", Type: "gauge", Value: 1.5, Time: from.Add(time.Minute)}}, samples)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStorage_LastRollup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT max\(START\) FROM metric_rollups`).
+		WithArgs(int64(3600)).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(start))
+	mock.ExpectQuery(`SELECT max\(START\) FROM metric_rollups`).
+		WithArgs(int64(60)).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+
+	st := &PGStorage{db: sqlx.NewDb(db, "sqlmock")}
+
+	last, err := st.LastRollup(context.Background(), time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, start, last)
+
+	// There are no rollups
+	last, err = st.LastRollup(context.Background(), time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, last.IsZero())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -2,10 +2,16 @@ package repo
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/leonf08/metrics-yp.git/internal/models"
 )
 
+// ErrTypeMismatch is returned if a batch updates a metric both as a gauge and as a counter.
+var ErrTypeMismatch = errors.New("metric is updated with different types")
+
 // Repository is an interface for metrics storage.
 //
 //go:generate mockery --name Repository --output ../mocks --filename repo_mock.go
@@ -15,3 +21,37 @@ type Repository interface {
 	SetVal(context.Context, string, models.Metric) error
 	GetVal(context.Context, string) (models.Metric, error)
 }
+
+// History is a storage of the history of metrics and its rollups.
+// Rollups are kept per resolution, the resolution is the length of their intervals.
+type History interface {
+	// Samples returns samples stored in [from, to) ordered by time.
+	// Samples of all metrics are returned if the name is empty.
+	Samples(ctx context.Context, name string, from, to time.Time) ([]models.Sample, error)
+
+	// WriteRollups stores rollups replacing the ones of the same metric and interval.
+	WriteRollups(ctx context.Context, resolution time.Duration, rollups []models.Rollup) error
+
+	// Rollups returns rollups of the metric starting in [from, to) ordered by start.
+	// Rollups of all metrics are returned if the name is empty.
+	Rollups(ctx context.Context, name string, resolution time.Duration, from, to time.Time) ([]models.Rollup, error)
+
+	// LastRollup returns the start of the latest rollup, zero time if there are none.
+	LastRollup(ctx context.Context, resolution time.Duration) (time.Time, error)
+
+	// DeleteRollups deletes rollups starting before the time.
+	DeleteRollups(ctx context.Context, resolution time.Duration, before time.Time) error
+}
+
+// checkTypes checks that every metric of the batch is updated with a single type.
+func checkTypes(batch []models.MetricDB) error {
+	types := make(map[string]string, len(batch))
+	for _, m := range batch {
+		if t, ok := types[m.Name]; ok && t != m.Type {
+			return fmt.Errorf("metric %s: %w", m.Name, ErrTypeMismatch)
+		}
+		types[m.Name] = m.Type
+	}
+
+	return nil
+}
@@ -0,0 +1,290 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// samplesTable is the range-partitioned table of the history of metrics
+	samplesTable = "metric_samples"
+
+	// partitionsAhead is the number of partitions created ahead of the current one,
+	// so samples are written even if maintenance fails for a while
+	partitionsAhead = 2
+
+	// partitionDateLayout is the layout of bounds in names of partitions
+	partitionDateLayout = "20060102"
+
+	// maintenanceLockID is the key of the advisory lock which serializes maintenance
+	// of several servers sharing the database
+	maintenanceLockID = 7364102
+)
+
+// Partition is the time range covered by a partition of the samples table.
+type Partition int
+
+const (
+	PartitionDay Partition = iota
+	PartitionWeek
+)
+
+// ParsePartition parses the range of partitions, empty range is a day.
+func ParsePartition(s string) (Partition, error) {
+	switch s {
+	case "", "day":
+		return PartitionDay, nil
+	case "week":
+		return PartitionWeek, nil
+	default:
+		return 0, fmt.Errorf("invalid partition range: %s", s)
+	}
+}
+
+// start returns the start of the partition containing t. Weeks start on Monday, times are in UTC.
+func (p Partition) start(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if p == PartitionWeek {
+		day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+
+	return day
+}
+
+// next returns the start of the partition following the one containing t.
+func (p Partition) next(t time.Time) time.Time {
+	if p == PartitionWeek {
+		return p.start(t).AddDate(0, 0, 7)
+	}
+
+	return p.start(t).AddDate(0, 0, 1)
+}
+
+// RetentionConfig holds settings of partitioning and retention of the history of metrics.
+type RetentionConfig struct {
+	// Partition is the time range of a partition, day or week
+	Partition string
+
+	// Retention is the age after which partitions are dropped, zero keeps the history forever
+	Retention time.Duration
+
+	// Interval is the period of maintenance
+	Interval time.Duration
+}
+
+// partitionRange is a partition of the samples table covering [from, to).
+type partitionRange struct {
+	name     string
+	from, to time.Time
+}
+
+func newPartitionRange(from, to time.Time) partitionRange {
+	return partitionRange{
+		name: fmt.Sprintf("%s_%s_%s", samplesTable, from.Format(partitionDateLayout), to.Format(partitionDateLayout)),
+		from: from,
+		to:   to,
+	}
+}
+
+// parsePartitionRange parses the name of a partition created by maintenance.
+func parsePartitionRange(name string) (partitionRange, bool) {
+	bounds, ok := strings.CutPrefix(name, samplesTable+"_")
+	if !ok {
+		return partitionRange{}, false
+	}
+
+	fromStr, toStr, ok := strings.Cut(bounds, "_")
+	if !ok {
+		return partitionRange{}, false
+	}
+
+	from, err := time.Parse(partitionDateLayout, fromStr)
+	if err != nil {
+		return partitionRange{}, false
+	}
+
+	to, err := time.Parse(partitionDateLayout, toStr)
+	if err != nil || !to.After(from) {
+		return partitionRange{}, false
+	}
+
+	return partitionRange{name: name, from: from, to: to}, true
+}
+
+// plan returns partitions which must be created to cover the current partition and the ones ahead,
+// and the existing partitions older than the retention. Partitions are created after the last existing one,
+// so ranges don't overlap if the range of partitions is changed.
+func plan(existing []partitionRange, p Partition, retention time.Duration, now time.Time) (create, drop []partitionRange) {
+	from := p.start(now)
+	for _, r := range existing {
+		if r.to.After(from) {
+			from = r.to
+		}
+	}
+
+	until := p.start(now)
+	for i := 0; i <= partitionsAhead; i++ {
+		until = p.next(until)
+	}
+
+	for from.Before(until) {
+		to := p.next(from)
+		create = append(create, newPartitionRange(from, to))
+		from = to
+	}
+
+	if retention > 0 {
+		expired := now.Add(-retention)
+		for _, r := range existing {
+			if !r.to.After(expired) {
+				drop = append(drop, r)
+			}
+		}
+	}
+
+	return create, drop
+}
+
+// Maintenance keeps partitions of the history of metrics: it creates partitions ahead of time
+// and drops partitions older than the retention.
+type Maintenance struct {
+	st        *PGStorage
+	cfg       RetentionConfig
+	partition Partition
+	log       zerolog.Logger
+	reg       *telemetry.Registry
+}
+
+// NewMaintenance creates maintenance of the history of metrics in the database.
+// Its activity is exposed in the registry if it is not nil.
+func NewMaintenance(st *PGStorage, cfg RetentionConfig, log zerolog.Logger, reg *telemetry.Registry) (*Maintenance, error) {
+	p, err := ParsePartition(cfg.Partition)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Maintenance{
+		st:        st,
+		cfg:       cfg,
+		partition: p,
+		log:       log,
+		reg:       reg,
+	}, nil
+}
+
+// Run maintains partitions every interval until the context is done. Errors are logged,
+// the next run tries again.
+func (m *Maintenance) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Maintain(ctx, time.Now()); err != nil {
+				m.log.Error().Err(err).Msg("repo - Maintenance - Maintain")
+			}
+		}
+	}
+}
+
+// Maintain creates missing partitions and drops the expired ones at the time now.
+func (m *Maintenance) Maintain(ctx context.Context, now time.Time) error {
+	const prefix = telemetry.ServerPrefix + "db_partitions_"
+
+	err := m.maintain(ctx, now)
+	if m.reg != nil {
+		if err != nil {
+			m.reg.Counter(prefix+"maintenance_errors_total", nil).Inc()
+		} else {
+			m.reg.Gauge(prefix+"maintenance_last_success_seconds", nil).Set(float64(now.Unix()))
+		}
+	}
+
+	return err
+}
+
+func (m *Maintenance) maintain(ctx context.Context, now time.Time) error {
+	const prefix = telemetry.ServerPrefix + "db_partitions_"
+
+	tx, err := m.st.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, maintenanceLockID); err != nil {
+		return err
+	}
+
+	existing, err := partitions(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	create, drop := plan(existing, m.partition, m.cfg.Retention, now)
+
+	for _, r := range create {
+		query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+			r.name, samplesTable, r.from.Format(time.RFC3339), r.to.Format(time.RFC3339))
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("create partition %s: %w", r.name, err)
+		}
+	}
+
+	for _, r := range drop {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, r.name)); err != nil {
+			return fmt.Errorf("drop partition %s: %w", r.name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, r := range create {
+		m.log.Info().Str("partition", r.name).Msg("repo - Maintenance - Partition created")
+	}
+	for _, r := range drop {
+		m.log.Info().Str("partition", r.name).Msg("repo - Maintenance - Partition dropped")
+	}
+
+	if m.reg != nil {
+		m.reg.Counter(prefix+"created_total", nil).Add(int64(len(create)))
+		m.reg.Counter(prefix+"dropped_total", nil).Add(int64(len(drop)))
+		m.reg.Gauge(prefix+"count", nil).Set(float64(len(existing) + len(create) - len(drop)))
+	}
+
+	return nil
+}
+
+// partitions returns the partitions of the samples table created by maintenance.
+func partitions(ctx context.Context, tx *sqlx.Tx) ([]partitionRange, error) {
+	const queryStr = `
+		SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass`
+
+	var names []string
+	if err := tx.SelectContext(ctx, &names, queryStr, samplesTable); err != nil {
+		return nil, err
+	}
+
+	existing := make([]partitionRange, 0, len(names))
+	for _, name := range names {
+		if r, ok := parsePartitionRange(name); ok {
+			existing = append(existing, r)
+		}
+	}
+
+	return existing, nil
+}
@@ -0,0 +1,205 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func names(ranges []partitionRange) []string {
+	var s []string
+	for _, r := range ranges {
+		s = append(s, r.name)
+	}
+
+	return s
+}
+
+func TestPartition(t *testing.T) {
+	// Wednesday
+	now := time.Date(2026, 10, 21, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		partition Partition
+		wantStart time.Time
+		wantNext  time.Time
+	}{
+		{
+			name:      "day",
+			partition: PartitionDay,
+			wantStart: time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC),
+			wantNext:  time.Date(2026, 10, 22, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "week starts on Monday",
+			partition: PartitionWeek,
+			wantStart: time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+			wantNext:  time.Date(2026, 10, 26, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStart, tt.partition.start(now))
+			assert.Equal(t, tt.wantNext, tt.partition.next(now))
+		})
+	}
+}
+
+func TestParsePartitionRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		arg    string
+		wantOK bool
+	}{
+		{name: "partition of maintenance", arg: "metric_samples_20261019_20261020", wantOK: true},
+		{name: "other table", arg: "metric_samples_default"},
+		{name: "invalid bound", arg: "metric_samples_20261019_tomorrow"},
+		{name: "empty range", arg: "metric_samples_20261019_20261019"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := parsePartitionRange(tt.arg)
+			assert.Equal(t, tt.wantOK, ok)
+			if ok {
+				assert.Equal(t, tt.arg, newPartitionRange(r.from, r.to).name)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2026, 10, 21, 15, 30, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name       string
+		existing   []partitionRange
+		partition  Partition
+		retention  time.Duration
+		wantCreate []string
+		wantDrop   []string
+	}{
+		{
+			name:      "no partitions",
+			partition: PartitionDay,
+			wantCreate: []string{
+				"metric_samples_20261021_20261022",
+				"metric_samples_20261022_20261023",
+				"metric_samples_20261023_20261024",
+			},
+		},
+		{
+			name: "partitions ahead exist",
+			existing: []partitionRange{
+				newPartitionRange(day(21), day(22)),
+				newPartitionRange(day(22), day(23)),
+			},
+			partition:  PartitionDay,
+			wantCreate: []string{"metric_samples_20261023_20261024"},
+		},
+		{
+			name: "expired partitions are dropped",
+			existing: []partitionRange{
+				newPartitionRange(day(18), day(19)),
+				newPartitionRange(day(19), day(20)),
+				newPartitionRange(day(20), day(21)),
+				newPartitionRange(day(21), day(22)),
+				newPartitionRange(day(22), day(23)),
+				newPartitionRange(day(23), day(24)),
+			},
+			partition: PartitionDay,
+			retention: 48 * time.Hour,
+			wantDrop:  []string{"metric_samples_20261018_20261019"},
+		},
+		{
+			name: "change of the range continues after the last partition",
+			existing: []partitionRange{
+				newPartitionRange(day(21), day(22)),
+			},
+			partition: PartitionWeek,
+			wantCreate: []string{
+				"metric_samples_20261022_20261026",
+				"metric_samples_20261026_20261102",
+				"metric_samples_20261102_20261109",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			create, drop := plan(tt.existing, tt.partition, tt.retention, now)
+
+			assert.Equal(t, tt.wantCreate, names(create))
+			assert.Equal(t, tt.wantDrop, names(drop))
+		})
+	}
+}
+
+func TestMaintenance_Maintain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2026, 10, 21, 15, 30, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT c.relname FROM pg_inherits").
+		WithArgs(samplesTable).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("metric_samples_20261001_20261002").
+			AddRow("metric_samples_20261021_20261022").
+			AddRow("metric_samples_20261022_20261023"))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS metric_samples_20261023_20261024 PARTITION OF metric_samples ` +
+		`FOR VALUES FROM \('2026-10-23T00:00:00Z'\) TO \('2026-10-24T00:00:00Z'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS metric_samples_20261001_20261002").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	reg := telemetry.NewRegistry()
+	st := &PGStorage{db: sqlx.NewDb(db, "sqlmock")}
+
+	m, err := NewMaintenance(st, RetentionConfig{Partition: "day", Retention: 7 * 24 * time.Hour}, zerolog.Nop(), reg)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Maintain(context.Background(), now))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, int64(1), reg.Counter(telemetry.ServerPrefix+"db_partitions_created_total", nil).Value())
+	assert.Equal(t, int64(1), reg.Counter(telemetry.ServerPrefix+"db_partitions_dropped_total", nil).Value())
+	assert.Equal(t, float64(3), reg.Gauge(telemetry.ServerPrefix+"db_partitions_count", nil).Value())
+}
+
+func TestMaintenance_MaintainErr(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+
+	reg := telemetry.NewRegistry()
+	st := &PGStorage{db: sqlx.NewDb(db, "sqlmock")}
+
+	m, err := NewMaintenance(st, RetentionConfig{}, zerolog.Nop(), reg)
+	require.NoError(t, err)
+
+	assert.Error(t, m.Maintain(context.Background(), time.Now()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, int64(1), reg.Counter(telemetry.ServerPrefix+"db_partitions_maintenance_errors_total", nil).Value())
+}
+
+func TestNewMaintenance_InvalidPartition(t *testing.T) {
+	_, err := NewMaintenance(&PGStorage{}, RetentionConfig{Partition: "month"}, zerolog.Nop(), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,406 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/config/duration"
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+)
+
+// maxRollupBuckets limits the number of intervals of a resolution computed by one run,
+// so the first run after a long downtime doesn't read the whole history at once.
+const maxRollupBuckets = 1440
+
+// Resolution is a resolution of rollups and the time they are kept.
+type Resolution struct {
+	// Step is the length of intervals of rollups
+	Step time.Duration
+
+	// Retention is the age after which rollups are deleted, zero keeps them forever
+	Retention time.Duration
+}
+
+func (r Resolution) String() string {
+	if r.Retention == 0 {
+		return r.Step.String()
+	}
+
+	return r.Step.String() + ":" + r.Retention.String()
+}
+
+// ParseResolutions parses a comma-separated list of resolutions, each one is a step
+// optionally followed by a colon and the retention, e.g. "1m:7d,1h,1d".
+// Besides durations, steps and retentions may be numbers of days like 7d.
+// Resolutions are returned ordered from the finest one.
+func ParseResolutions(s string) ([]Resolution, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var res []Resolution
+	seen := make(map[time.Duration]bool)
+	for _, item := range strings.Split(s, ",") {
+		stepStr, retentionStr, hasRetention := strings.Cut(strings.TrimSpace(item), ":")
+
+		step, err := parseDays(stepStr)
+		if err != nil {
+			return nil, fmt.Errorf("resolution %q: %w", item, err)
+		}
+		if step < time.Second || step%time.Second != 0 {
+			return nil, fmt.Errorf("resolution %q: step must be a whole number of seconds", item)
+		}
+		if seen[step] {
+			return nil, fmt.Errorf("resolution %q: duplicate step", item)
+		}
+		seen[step] = true
+
+		r := Resolution{Step: step}
+		if hasRetention {
+			if r.Retention, err = parseDays(retentionStr); err != nil {
+				return nil, fmt.Errorf("resolution %q: %w", item, err)
+			}
+		}
+
+		res = append(res, r)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Step < res[j].Step })
+
+	return res, nil
+}
+
+// parseDays parses a number of days like 7d or a duration.
+func parseDays(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days: %s", s)
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return duration.Parse(s)
+}
+
+// RawRetention returns the time samples are kept for to serve short ranges of raw samples
+// and to compute rollups of the longest interval of resolutions computed from samples.
+func RawRetention(resolutions []Resolution) time.Duration {
+	var longest time.Duration
+	for _, r := range resolutions {
+		if _, ok := rollupSource(resolutions, r); !ok {
+			longest = max(longest, r.Step)
+		}
+	}
+
+	return maxRawRange + longest
+}
+
+// rollupSource returns the resolution rollups at the resolution res are computed from:
+// the coarsest finer resolution whose step divides the step of res and whose rollups are kept
+// for the step of res at least. False is returned if rollups are computed from samples.
+func rollupSource(resolutions []Resolution, res Resolution) (Resolution, bool) {
+	var (
+		src Resolution
+		ok  bool
+	)
+	for _, r := range resolutions {
+		if r.Step >= res.Step || res.Step%r.Step != 0 || (r.Retention > 0 && r.Retention < res.Step) {
+			continue
+		}
+		if !ok || r.Step > src.Step {
+			src, ok = r, true
+		}
+	}
+
+	return src, ok
+}
+
+// Rollups computes rollups of completed intervals of every resolution and deletes rollups
+// older than their retention. Rollups are computed from rollups of a finer resolution
+// if there is one to compute them from, otherwise from samples of the history.
+type Rollups struct {
+	history     repo.History
+	resolutions []Resolution
+	reg         *telemetry.Registry
+
+	// done are ends of intervals already computed, intervals without samples
+	// don't leave rollups to continue from
+	done map[time.Duration]time.Time
+}
+
+// NewRollups creates rollups of the history at the resolutions.
+// Its activity is exposed in the registry if it is not nil.
+func NewRollups(h repo.History, resolutions []Resolution, reg *telemetry.Registry) *Rollups {
+	// Finer rollups are computed first, as coarser ones are computed from them
+	sorted := append([]Resolution(nil), resolutions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Step < sorted[j].Step })
+
+	return &Rollups{
+		history:     h,
+		resolutions: sorted,
+		reg:         reg,
+		done:        make(map[time.Duration]time.Time),
+	}
+}
+
+// Run computes rollups every interval until the context is done.
+// Errors are passed to onErr, the next run tries again.
+func (r *Rollups) Run(ctx context.Context, interval time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Compute(ctx, time.Now()); err != nil {
+				onErr(err)
+			}
+		}
+	}
+}
+
+// Compute computes rollups of intervals completed by the time now.
+func (r *Rollups) Compute(ctx context.Context, now time.Time) error {
+	var errs []error
+	for _, res := range r.resolutions {
+		n, err := r.compute(ctx, res, now)
+		if r.reg != nil {
+			labels := map[string]string{"resolution": res.Step.String()}
+			r.reg.Counter(telemetry.ServerPrefix+"rollups_written_total", labels).Add(int64(n))
+			if err != nil {
+				r.reg.Counter(telemetry.ServerPrefix+"rollup_errors_total", labels).Inc()
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolution %s: %w", res.Step, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Rollups) compute(ctx context.Context, res Resolution, now time.Time) (int, error) {
+	end := now.Truncate(res.Step)
+
+	last, err := r.history.LastRollup(ctx, res.Step)
+	if err != nil {
+		return 0, err
+	}
+
+	start := end.Add(-maxRollupBuckets * res.Step)
+	if next := last.Add(res.Step); !last.IsZero() && next.After(start) {
+		start = next
+	}
+	if done := r.done[res.Step]; done.After(start) {
+		start = done
+	}
+
+	var written int
+	if start.Before(end) {
+		var rollups []models.Rollup
+		if src, ok := rollupSource(r.resolutions, res); ok {
+			finer, err := r.history.Rollups(ctx, "", src.Step, start, end)
+			if err != nil {
+				return 0, err
+			}
+
+			rollups = AggregateRollups(finer, res.Step, start, end)
+		} else {
+			// The sample before the first interval is the base of increases of counters
+			samples, err := r.history.Samples(ctx, "", start.Add(-res.Step), end)
+			if err != nil {
+				return 0, err
+			}
+
+			rollups = Aggregate(samples, res.Step, start, end)
+		}
+
+		if err := r.history.WriteRollups(ctx, res.Step, rollups); err != nil {
+			return 0, err
+		}
+
+		written = len(rollups)
+		r.done[res.Step] = end
+	}
+
+	if res.Retention > 0 {
+		if err := r.history.DeleteRollups(ctx, res.Step, now.Add(-res.Retention)); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Aggregate computes rollups of intervals of the step in [start, end) from samples ordered by time.
+// Samples before the start are the base of increases of counters. The increase of a counter
+// is the sum of differences of its consecutive samples, a decrease is a reset of the counter
+// and its value after the reset is the increase.
+func Aggregate(samples []models.Sample, step time.Duration, start, end time.Time) []models.Rollup {
+	type state struct {
+		prev    float64
+		hasPrev bool
+		current *models.Rollup
+		sum     float64
+	}
+
+	var (
+		rollups []models.Rollup
+		names   []string
+	)
+	states := make(map[string]*state)
+
+	flush := func(s *state) {
+		if s.current == nil {
+			return
+		}
+
+		if s.current.Type == "counter" {
+			s.current.Rate = s.current.Increase / step.Seconds()
+		} else {
+			s.current.Avg = s.sum / float64(s.current.Count)
+		}
+
+		rollups = append(rollups, *s.current)
+		s.current, s.sum = nil, 0
+	}
+
+	for _, m := range samples {
+		s, ok := states[m.Name]
+		if !ok {
+			s = &state{}
+			states[m.Name] = s
+			names = append(names, m.Name)
+		}
+
+		if m.Time.Before(start) || !m.Time.Before(end) {
+			s.prev, s.hasPrev = m.Value, true
+			continue
+		}
+
+		bucket := m.Time.Truncate(step)
+		if s.current != nil && !s.current.Start.Equal(bucket) {
+			flush(s)
+		}
+		if s.current == nil {
+			s.current = &models.Rollup{Name: m.Name, Type: m.Type, Start: bucket}
+			if m.Type != "counter" {
+				s.current.Min, s.current.Max = m.Value, m.Value
+			}
+		}
+
+		c := s.current
+		c.Count++
+		c.Last = m.Value
+		if m.Type == "counter" {
+			switch {
+			case !s.hasPrev:
+				// The first known sample is the base of the increase
+			case m.Value >= s.prev:
+				c.Increase += m.Value - s.prev
+			default:
+				c.Increase += m.Value
+			}
+		} else {
+			c.Min = min(c.Min, m.Value)
+			c.Max = max(c.Max, m.Value)
+			s.sum += m.Value
+		}
+
+		s.prev, s.hasPrev = m.Value, true
+	}
+
+	for _, name := range names {
+		flush(states[name])
+	}
+
+	// Rollups of a metric are flushed in order, the stable sort keeps it
+	sort.SliceStable(rollups, func(i, j int) bool { return rollups[i].Name < rollups[j].Name })
+
+	return rollups
+}
+
+// AggregateRollups computes rollups of intervals of the step in [start, end) from finer rollups
+// ordered by start. Increases and counts are summed, the average is weighted by counts.
+func AggregateRollups(finer []models.Rollup, step time.Duration, start, end time.Time) []models.Rollup {
+	type state struct {
+		current *models.Rollup
+		sum     float64
+	}
+
+	var (
+		rollups []models.Rollup
+		names   []string
+	)
+	states := make(map[string]*state)
+
+	flush := func(s *state) {
+		if s.current == nil {
+			return
+		}
+
+		if s.current.Type == "counter" {
+			s.current.Rate = s.current.Increase / step.Seconds()
+		} else if s.current.Count > 0 {
+			s.current.Avg = s.sum / float64(s.current.Count)
+		}
+
+		rollups = append(rollups, *s.current)
+		s.current, s.sum = nil, 0
+	}
+
+	for _, f := range finer {
+		if f.Start.Before(start) || !f.Start.Before(end) {
+			continue
+		}
+
+		s, ok := states[f.Name]
+		if !ok {
+			s = &state{}
+			states[f.Name] = s
+			names = append(names, f.Name)
+		}
+
+		bucket := f.Start.Truncate(step)
+		if s.current != nil && !s.current.Start.Equal(bucket) {
+			flush(s)
+		}
+		if s.current == nil {
+			s.current = &models.Rollup{Name: f.Name, Type: f.Type, Start: bucket}
+			if f.Type != "counter" {
+				s.current.Min, s.current.Max = f.Min, f.Max
+			}
+		}
+
+		c := s.current
+		c.Count += f.Count
+		c.Last = f.Last
+		if f.Type == "counter" {
+			c.Increase += f.Increase
+		} else {
+			c.Min = min(c.Min, f.Min)
+			c.Max = max(c.Max, f.Max)
+			s.sum += f.Avg * float64(f.Count)
+		}
+	}
+
+	for _, name := range names {
+		flush(states[name])
+	}
+
+	// Rollups of a metric are flushed in order, the stable sort keeps it
+	sort.SliceStable(rollups, func(i, j int) bool { return rollups[i].Name < rollups[j].Name })
+
+	return rollups
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/leonf08/metrics-yp.git/internal/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolutions(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		want    []Resolution
+		wantErr bool
+	}{
+		{
+			name: "empty",
+			arg:  "",
+		},
+		{
+			name: "resolutions are ordered",
+			arg:  "1d, 1m:7d,1h:720h",
+			want: []Resolution{
+				{Step: time.Minute, Retention: 7 * 24 * time.Hour},
+				{Step: time.Hour, Retention: 720 * time.Hour},
+				{Step: 24 * time.Hour},
+			},
+		},
+		{
+			name:    "duplicate step",
+			arg:     "1h,60m",
+			wantErr: true,
+		},
+		{
+			name:    "step shorter than a second",
+			arg:     "500ms",
+			wantErr: true,
+		},
+		{
+			name:    "invalid retention",
+			arg:     "1m:week",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseResolutions(tt.arg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+
+	samples := []models.Sample{
+		{Name: "hits", Type: "counter", Value: 10, Time: at(-30)},
+		{Name: "load", Type: "gauge", Value: 4, Time: at(0)},
+		{Name: "hits", Type: "counter", Value: 15, Time: at(10)},
+		{Name: "load", Type: "gauge", Value: 2, Time: at(20)},
+		{Name: "hits", Type: "counter", Value: 25, Time: at(30)},
+		{Name: "load", Type: "gauge", Value: 6, Time: at(70)},
+		// The counter is reset
+		{Name: "hits", Type: "counter", Value: 3, Time: at(80)},
+	}
+
+	got := Aggregate(samples, time.Minute, start, at(120))
+
+	want := []models.Rollup{
+		{Name: "hits", Type: "counter", Start: at(0), Count: 2, Last: 25, Increase: 15, Rate: 0.25},
+		{Name: "hits", Type: "counter", Start: at(60), Count: 1, Last: 3, Increase: 3, Rate: 0.05},
+		{Name: "load", Type: "gauge", Start: at(0), Count: 2, Min: 2, Max: 4, Avg: 3, Last: 2},
+		{Name: "load", Type: "gauge", Start: at(60), Count: 1, Min: 6, Max: 6, Avg: 6, Last: 6},
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestRollups_Compute(t *testing.T) {
+	ctx := context.Background()
+	st := repo.NewStorageWithHistory(time.Hour)
+	require.NoError(t, st.SetVal(ctx, "load", models.Metric{Type: "gauge", Val: 1.5}))
+	require.NoError(t, st.SetVal(ctx, "load", models.Metric{Type: "gauge", Val: 2.5}))
+
+	reg := telemetry.NewRegistry()
+	r := NewRollups(st, []Resolution{{Step: time.Minute, Retention: time.Hour}}, reg)
+
+	now := time.Now().Add(2 * time.Minute)
+	require.NoError(t, r.Compute(ctx, now))
+
+	// Samples are in one interval unless they straddle a minute
+	rollups, err := st.Rollups(ctx, "load", time.Minute, now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	require.NotEmpty(t, rollups)
+	assert.Equal(t, 2.5, rollups[len(rollups)-1].Last)
+
+	written := reg.Counter(telemetry.ServerPrefix+"rollups_written_total", map[string]string{"resolution": "1m0s"})
+	assert.Equal(t, int64(len(rollups)), written.Value())
+
+	// Intervals are computed once
+	require.NoError(t, r.Compute(ctx, now))
+	assert.Equal(t, int64(len(rollups)), written.Value())
+
+	// Rollups are deleted after the retention
+	require.NoError(t, r.Compute(ctx, now.Add(2*time.Hour)))
+	rollups, err = st.Rollups(ctx, "load", time.Minute, now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	assert.Empty(t, rollups)
+}
+
+func TestRawRetention(t *testing.T) {
+	assert.Equal(t, maxRawRange, RawRetention(nil))
+	// Days are computed from minutes
+	assert.Equal(t, maxRawRange+time.Minute, RawRetention([]Resolution{{Step: time.Minute}, {Step: 24 * time.Hour}}))
+	// Minutes are not kept for a day
+	assert.Equal(t, maxRawRange+24*time.Hour,
+		RawRetention([]Resolution{{Step: time.Minute, Retention: time.Hour}, {Step: 24 * time.Hour}}))
+	// Minutes are not made of intervals of 7 seconds
+	assert.Equal(t, maxRawRange+time.Minute, RawRetention([]Resolution{{Step: 7 * time.Second}, {Step: time.Minute}}))
+}
+
+func TestAggregateRollups(t *testing.T) {
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return start.Add(time.Duration(min) * time.Minute) }
+
+	finer := []models.Rollup{
+		{Name: "hits", Type: "counter", Start: at(-1), Count: 1, Last: 10, Increase: 10},
+		{Name: "hits", Type: "counter", Start: at(0), Count: 2, Last: 25, Increase: 15},
+		{Name: "load", Type: "gauge", Start: at(0), Count: 1, Min: 4, Max: 4, Avg: 4, Last: 4},
+		{Name: "hits", Type: "counter", Start: at(1), Count: 1, Last: 3, Increase: 3},
+		{Name: "load", Type: "gauge", Start: at(1), Count: 3, Min: 1, Max: 6, Avg: 2, Last: 1},
+		{Name: "load", Type: "gauge", Start: at(5), Count: 1, Min: 7, Max: 7, Avg: 7, Last: 7},
+	}
+
+	got := AggregateRollups(finer, 5*time.Minute, start, at(10))
+
+	want := []models.Rollup{
+		{Name: "hits", Type: "counter", Start: at(0), Count: 3, Last: 3, Increase: 18, Rate: 0.06},
+		{Name: "load", Type: "gauge", Start: at(0), Count: 4, Min: 1, Max: 6, Avg: 2.5, Last: 1},
+		{Name: "load", Type: "gauge", Start: at(5), Count: 1, Min: 7, Max: 7, Avg: 7, Last: 7},
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestRollups_ComputeFromFiner(t *testing.T) {
+	ctx := context.Background()
+	st := repo.NewStorageWithHistory(time.Hour)
+
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, st.WriteRollups(ctx, time.Minute, []models.Rollup{
+		{Name: "load", Type: "gauge", Start: start, Count: 1, Min: 2, Max: 2, Avg: 2, Last: 2},
+		{Name: "load", Type: "gauge", Start: start.Add(time.Minute), Count: 1, Min: 4, Max: 4, Avg: 4, Last: 4},
+	}))
+
+	// Samples are not kept for the hour, the hour is computed from minutes
+	r := NewRollups(st, []Resolution{{Step: time.Hour}, {Step: time.Minute}}, nil)
+	require.NoError(t, r.Compute(ctx, start.Add(time.Hour)))
+
+	rollups, err := st.Rollups(ctx, "load", time.Hour, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []models.Rollup{
+		{Name: "load", Type: "gauge", Start: start, Count: 2, Min: 2, Max: 4, Avg: 3, Last: 4},
+	}, rollups)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+)
+
+var _ FileStore = (*ScheduledFileStore)(nil)
+
+// ScheduledFileStore decides when metrics are saved to the wrapped file store.
+// If the interval is zero, metrics are saved on every change, otherwise
+// they are saved periodically by Run. The interval can be changed at any time.
+type ScheduledFileStore struct {
+	FileStore
+	interval atomic.Int64
+	reset    chan struct{}
+}
+
+// NewScheduledFileStore creates a new scheduled file store with the interval.
+func NewScheduledFileStore(fs FileStore, interval time.Duration) *ScheduledFileStore {
+	s := &ScheduledFileStore{
+		FileStore: fs,
+		reset:     make(chan struct{}, 1),
+	}
+	s.interval.Store(int64(interval))
+
+	return s
+}
+
+// Save saves metrics if they are saved on every change. Otherwise it does nothing,
+// metrics are saved by Run.
+func (s *ScheduledFileStore) Save(r repo.Repository) error {
+	if s.interval.Load() > 0 {
+		return nil
+	}
+
+	return s.FileStore.Save(r)
+}
+
+// SetInterval changes the interval. The period of Run starts anew.
+func (s *ScheduledFileStore) SetInterval(interval time.Duration) {
+	if s.interval.Swap(int64(interval)) == int64(interval) {
+		return
+	}
+
+	select {
+	case s.reset <- struct{}{}:
+	default:
+	}
+}
+
+// Run saves metrics of the repository every interval until the context is done.
+// Errors of saving are passed to onErr.
+func (s *ScheduledFileStore) Run(ctx context.Context, r repo.Repository, onErr func(error)) {
+	for {
+		var (
+			tick  <-chan time.Time
+			timer *time.Timer
+		)
+		if interval := s.interval.Load(); interval > 0 {
+			timer = time.NewTimer(time.Duration(interval))
+			tick = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.reset:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-tick:
+			if err := s.FileStore.Save(r); err != nil {
+				onErr(err)
+			}
+		}
+	}
+}
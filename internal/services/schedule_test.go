@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/services/mocks"
+	"github.com/leonf08/metrics-yp.git/internal/services/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestScheduledFileStore_Save(t *testing.T) {
+	fs := mocks.NewFileStore(t)
+	fs.On("Save", mock.Anything).Return(nil).Once()
+
+	s := NewScheduledFileStore(fs, 10*time.Second)
+	r := repo.NewStorage()
+
+	// Metrics are saved periodically, Save does nothing
+	assert.NoError(t, s.Save(r))
+
+	// Metrics are saved on every change
+	s.SetInterval(0)
+	assert.NoError(t, s.Save(r))
+}
+
+func TestScheduledFileStore_Run(t *testing.T) {
+	fs := mocks.NewFileStore(t)
+	saved := make(chan struct{}, 1)
+	fs.On("Save", mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		select {
+		case saved <- struct{}{}:
+		default:
+		}
+	})
+
+	s := NewScheduledFileStore(fs, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, repo.NewStorage(), func(err error) { t.Error(err) })
+
+	// Nothing is saved periodically until the interval is set
+	select {
+	case <-saved:
+		t.Fatal("unexpected save")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.SetInterval(100 * time.Millisecond)
+	select {
+	case <-saved:
+	case <-time.After(3 * time.Second):
+		t.Fatal("metrics are not saved")
+	}
+}
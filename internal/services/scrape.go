@@ -0,0 +1,207 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+)
+
+const (
+	scrapeTimeout = 5 * time.Second
+	targetLabel   = "target"
+)
+
+var _ Collector = (*ScrapeCollector)(nil)
+
+// ScrapeCollector collects metrics from HTTP targets exposing Prometheus text format.
+type ScrapeCollector struct {
+	client   *http.Client
+	targets  []string
+	counters *cumulativeCounters
+}
+
+// cumulativeCounters converts cumulative values of counters to increments since the previous
+// values. The first value of a counter is its baseline, the increment is zero. A decrease
+// of the value is a reset of the counter, the value is the increment then.
+type cumulativeCounters struct {
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+func newCumulativeCounters() *cumulativeCounters {
+	return &cumulativeCounters{last: make(map[string]int64)}
+}
+
+// increment returns the increment of the counter with the name since its previous value.
+func (c *cumulativeCounters) increment(name string, value int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last, ok := c.last[name]
+	c.last[name] = value
+
+	switch {
+	case !ok:
+		return 0
+	case value >= last:
+		return value - last
+	default:
+		return value
+	}
+}
+
+// NewScrapeCollector creates a new scrape collector for the given targets.
+// Target is a URL of the metrics endpoint, e.g. http://localhost:9090/metrics.
+func NewScrapeCollector(targets []string) *ScrapeCollector {
+	return &ScrapeCollector{
+		client:   &http.Client{Timeout: scrapeTimeout},
+		targets:  targets,
+		counters: newCumulativeCounters(),
+	}
+}
+
+// Collect scrapes all targets. Counters are converted to counter metrics with increments
+// since the previous scrape, the first scrape of a counter is its baseline.
+// All other samples are converted to gauges. Every metric gets a target label
+// with the host of the target. If some targets fail, metrics of the other
+// targets are still returned along with the error.
+func (s *ScrapeCollector) Collect(ctx context.Context) (map[string]models.Metric, error) {
+	metrics := make(map[string]models.Metric)
+
+	var errs []error
+	for _, t := range s.targets {
+		if err := s.scrape(ctx, t, metrics); err != nil {
+			errs = append(errs, fmt.Errorf("scrape %s: %w", t, err))
+		}
+	}
+
+	return metrics, errors.Join(errs...)
+}
+
+func (s *ScrapeCollector) scrape(ctx context.Context, target string, metrics map[string]models.Metric) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	scraped := make(map[string]models.Metric)
+	err = parsePrometheusText(resp.Body, u.Host, scraped)
+
+	for k, v := range scraped {
+		if v.Type == "counter" {
+			v.Val = s.counters.increment(k, v.Val.(int64))
+		}
+		metrics[k] = v
+	}
+
+	return err
+}
+
+// parsePrometheusText parses metrics in Prometheus text exposition format
+// and adds them to the metrics map with the target label. Counters are cumulative values.
+func parsePrometheusText(r io.Reader, target string, metrics map[string]models.Metric) error {
+	types := make(map[string]string)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		name, labels, val, err := parseSample(line)
+		if err != nil {
+			return fmt.Errorf("invalid sample %q: %w", line, err)
+		}
+
+		// Non-finite values can't be reported to the server
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			continue
+		}
+
+		if v, ok := labels[targetLabel]; ok {
+			labels["exported_"+targetLabel] = v
+		}
+		labels[targetLabel] = target
+
+		id := models.SeriesName(name, labels)
+		if types[name] == "counter" {
+			metrics[id] = models.Metric{Type: "counter", Val: int64(val)}
+		} else {
+			metrics[id] = models.Metric{Type: "gauge", Val: val}
+		}
+	}
+
+	return sc.Err()
+}
+
+// parseSample parses a sample line: name{labels} value [timestamp].
+func parseSample(line string) (string, map[string]string, float64, error) {
+	var (
+		name   string
+		labels map[string]string
+		rest   string
+		err    error
+	)
+
+	if i := strings.IndexAny(line, "{ \t"); i < 0 {
+		return "", nil, 0, errors.New("missing value")
+	} else if line[i] == '{' {
+		name = line[:i]
+		labels, rest, err = models.ParseLabels(line[i:])
+		if err != nil {
+			return "", nil, 0, err
+		}
+	} else {
+		name = line[:i]
+		labels = make(map[string]string)
+		rest = line[i:]
+	}
+
+	fields := strings.Fields(rest)
+	if name == "" || len(fields) == 0 {
+		return "", nil, 0, errors.New("missing value")
+	}
+
+	val, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	return name, labels, val, nil
+}
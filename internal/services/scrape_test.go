@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const promText = `# HELP http_requests_total Total number of requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",method="get"} 1027 1395066363000
+http_requests_total{code="400",method="post"} 3
+# TYPE go_goroutines gauge
+go_goroutines 12
+# TYPE rpc_duration_seconds summary
+rpc_duration_seconds{quantile="0.5"} 0.05
+rpc_duration_seconds_count 7
+# TYPE temperature gauge
+temperature NaN
+`
+
+func TestScrapeCollector_Collect(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write([]byte(promText))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		targets []string
+		want    map[string]models.Metric
+		wantErr bool
+	}{
+		{
+			name:    "first scrape is the baseline of counters",
+			targets: []string{ts.URL + "/metrics"},
+			want: map[string]models.Metric{
+				`http_requests_total{code="200",method="get",target="` + u.Host + `"}`:  {Type: "counter", Val: int64(0)},
+				`http_requests_total{code="400",method="post",target="` + u.Host + `"}`: {Type: "counter", Val: int64(0)},
+				`go_goroutines{target="` + u.Host + `"}`:                                {Type: "gauge", Val: float64(12)},
+				`rpc_duration_seconds{quantile="0.5",target="` + u.Host + `"}`:          {Type: "gauge", Val: 0.05},
+				`rpc_duration_seconds_count{target="` + u.Host + `"}`:                   {Type: "gauge", Val: float64(7)},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "scrape valid and invalid targets",
+			targets: []string{ts.URL + "/invalid", ts.URL + "/metrics"},
+			want: map[string]models.Metric{
+				`http_requests_total{code="200",method="get",target="` + u.Host + `"}`:  {Type: "counter", Val: int64(0)},
+				`http_requests_total{code="400",method="post",target="` + u.Host + `"}`: {Type: "counter", Val: int64(0)},
+				`go_goroutines{target="` + u.Host + `"}`:                                {Type: "gauge", Val: float64(12)},
+				`rpc_duration_seconds{quantile="0.5",target="` + u.Host + `"}`:          {Type: "gauge", Val: 0.05},
+				`rpc_duration_seconds_count{target="` + u.Host + `"}`:                   {Type: "gauge", Val: float64(7)},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScrapeCollector(tt.targets)
+
+			got, err := s.Collect(context.Background())
+
+			assert.Equal(t, tt.wantErr, err != nil, "Collect() error = %v", err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestScrapeCollector_CounterIncrements(t *testing.T) {
+	var requests atomic.Int64
+	values := []string{"10", "15", "15", "4"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := values[requests.Add(1)-1]
+		_, _ = w.Write([]byte("# TYPE hits_total counter\nhits_total " + v + "\n"))
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	s := NewScrapeCollector([]string{ts.URL})
+	id := `hits_total{target="` + u.Host + `"}`
+
+	// The value after the reset is the increment
+	for _, want := range []int64{0, 5, 0, 4} {
+		got, err := s.Collect(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, models.Metric{Type: "counter", Val: want}, got[id])
+	}
+}
+
+func Test_parsePrometheusText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    map[string]models.Metric
+		wantErr bool
+	}{
+		{
+			name: "existing target label is renamed",
+			text: "up{target=\"db\"} 1\n",
+			want: map[string]models.Metric{
+				`up{exported_target="db",target="host"}`: {Type: "gauge", Val: float64(1)},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "missing value",
+			text:    "up\n",
+			want:    map[string]models.Metric{},
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			text:    "up{a=\"b\"} one\n",
+			want:    map[string]models.Metric{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]models.Metric)
+			err := parsePrometheusText(strings.NewReader(tt.text), "host", got)
+
+			assert.Equal(t, tt.wantErr, err != nil, "parsePrometheusText() error = %v", err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
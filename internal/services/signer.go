@@ -2,9 +2,37 @@ package services
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of signed requests. The hash of requests with the timestamp and the nonce
+// covers them along with the body, the hash of legacy requests covers the body only.
+const (
+	HeaderHash      = "HashSHA256"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+
+	// HeaderKeyID selects the key the request is verified with, the default key
+	// without an ID is used if it is empty
+	HeaderKeyID = "KeyID"
+)
+
+var (
+	// ErrInvalidHash is returned if the hash of the request doesn't match its data.
+	ErrInvalidHash = errors.New("invalid hash")
+
+	// ErrUnknownKey is returned if there is no key with the requested ID.
+	ErrUnknownKey = errors.New("unknown key")
+
+	// ErrExpiredKey is returned if the key with the requested ID is expired.
+	ErrExpiredKey = errors.New("expired key")
 )
 
 var _ http.ResponseWriter = (*HashSigner)(nil)
@@ -12,7 +40,64 @@ var _ http.ResponseWriter = (*HashSigner)(nil)
 // HashSigner service is used to hash calculation and verification.
 type HashSigner struct {
 	http.ResponseWriter
-	key string
+	key  string
+	keys map[string]SigningKey
+}
+
+// SigningKey is a named key of the signer. Keys are rotated by adding a new key
+// and removing the old one after a grace period, while both keys are accepted.
+type SigningKey struct {
+	// ID is sent by clients in the KeyID header, the key with empty ID is the default one
+	ID string
+
+	// Key is the shared secret
+	Key string
+
+	// Expires is the time the key is not accepted after, zero time means the key doesn't expire
+	Expires time.Time
+}
+
+// NewHashSignerWithKeys creates instance of the signer verifying requests
+// with the key selected by its ID. Keys with empty secrets are skipped,
+// it returns nil if there are no keys.
+func NewHashSignerWithKeys(keys ...SigningKey) *HashSigner {
+	ring := make(map[string]SigningKey, len(keys))
+	for _, k := range keys {
+		if k.Key != "" {
+			ring[k.ID] = k
+		}
+	}
+
+	if len(ring) == 0 {
+		return nil
+	}
+
+	return &HashSigner{
+		keys: ring,
+	}
+}
+
+// ForKey returns the signer with the key selected by its ID at the time.
+// The signer created with a single key has the default key only.
+func (h *HashSigner) ForKey(id string, now time.Time) (*HashSigner, error) {
+	if h.keys == nil {
+		if id != "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+		}
+
+		return &HashSigner{key: h.key}, nil
+	}
+
+	k, ok := h.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	if !k.Expires.IsZero() && now.After(k.Expires) {
+		return nil, fmt.Errorf("%w: %s", ErrExpiredKey, id)
+	}
+
+	return &HashSigner{key: k.Key}, nil
 }
 
 // NewHashSigner creates instance of the signer. It returns nil if key is empty.
@@ -34,7 +119,7 @@ func (h *HashSigner) Write(b []byte) (int, error) {
 		return 0, err
 	}
 
-	h.Header().Set("HashSHA256", hex.EncodeToString(hash))
+	h.Header().Set(HeaderHash, hex.EncodeToString(hash))
 	return h.ResponseWriter.Write(b)
 }
 
@@ -49,8 +134,104 @@ func (h *HashSigner) CalcHash(src []byte) ([]byte, error) {
 	return hash.Sum(nil), nil
 }
 
+// Sign calculates the hash of the data together with the timestamp and the nonce.
+// The timestamp is passed as the number of seconds since the epoch.
+func (h *HashSigner) Sign(src []byte, timestamp, nonce string) ([]byte, error) {
+	hash := hmac.New(sha256.New, []byte(h.key))
+	for _, b := range [][]byte{[]byte(timestamp), {'\n'}, []byte(nonce), {'\n'}, src} {
+		if _, err := hash.Write(b); err != nil {
+			return nil, err
+		}
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// SignatureHeaders returns headers of the request with the data signed at the time.
+func (h *HashSigner) SignatureHeaders(src []byte, now time.Time) (map[string]string, error) {
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	hash, err := h.Sign(src, timestamp, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		HeaderHash:      hex.EncodeToString(hash),
+		HeaderTimestamp: timestamp,
+		HeaderNonce:     nonce,
+	}, nil
+}
+
+// RequestPayload returns the data the signature of an HTTP request covers. Requests with a body
+// are signed over the body. Data of requests without a body travel in the URL, so their signature
+// covers the method and the request target, the path with the query.
+func RequestPayload(method, target string, body []byte) []byte {
+	if len(body) > 0 {
+		return body
+	}
+
+	return []byte(method + " " + target + "\n")
+}
+
+// NewNonce returns a random nonce.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
 // CheckHash checks if the hash is equal to the hash of the data.
 // It returns true if hashes are equal and false otherwise.
 func (h *HashSigner) CheckHash(h1, h2 []byte) bool {
 	return hmac.Equal(h1, h2)
 }
+
+// Signature is the signature of a request as it is received in headers or metadata.
+type Signature struct {
+	Hash      string
+	Timestamp string
+	Nonce     string
+	KeyID     string
+}
+
+// Verify verifies the signature of the data with the key selected by its ID.
+// The timestamp and the nonce are checked by the guard if it is not nil, the guard is checked
+// after the hash, so nonces of forged requests are not remembered.
+// It returns the signer with the selected key, responses are signed with it.
+func (h *HashSigner) Verify(src []byte, sig Signature, g *ReplayGuard, now time.Time) (*HashSigner, error) {
+	ks, err := h.ForKey(sig.KeyID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var want []byte
+	if sig.Timestamp == "" && sig.Nonce == "" {
+		want, err = ks.CalcHash(src)
+	} else {
+		want, err = ks.Sign(src, sig.Timestamp, sig.Nonce)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	got, err := hex.DecodeString(sig.Hash)
+	if err != nil || !ks.CheckHash(want, got) {
+		return nil, ErrInvalidHash
+	}
+
+	if g != nil {
+		if err := g.Check(sig.Timestamp, sig.Nonce); err != nil {
+			return nil, err
+		}
+	}
+
+	return ks, nil
+}
@@ -3,8 +3,10 @@ package services
 import (
 	"encoding/hex"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHashSigner_CalcHash(t *testing.T) {
@@ -108,3 +110,67 @@ func TestNewHashSigner(t *testing.T) {
 		})
 	}
 }
+
+func TestHashSigner_SignatureHeaders(t *testing.T) {
+	h := NewHashSigner("test")
+	now := time.Unix(1700000000, 0)
+
+	headers, err := h.SignatureHeaders([]byte("body"), now)
+	require.NoError(t, err)
+	assert.Equal(t, "1700000000", headers[HeaderTimestamp])
+	assert.Len(t, headers[HeaderNonce], 32)
+
+	want, err := h.Sign([]byte("body"), headers[HeaderTimestamp], headers[HeaderNonce])
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(want), headers[HeaderHash])
+
+	// The signature depends on the timestamp and the nonce, not only on the body
+	legacy, err := h.CalcHash([]byte("body"))
+	require.NoError(t, err)
+	assert.NotEqual(t, hex.EncodeToString(legacy), headers[HeaderHash])
+
+	other, err := h.SignatureHeaders([]byte("body"), now)
+	require.NoError(t, err)
+	assert.NotEqual(t, headers[HeaderNonce], other[HeaderNonce])
+}
+
+func TestHashSigner_ForKey(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	keyed := NewHashSignerWithKeys(
+		SigningKey{Key: "default"},
+		SigningKey{ID: "v1", Key: "old", Expires: now.Add(time.Hour)},
+		SigningKey{ID: "v2", Key: "new"},
+		SigningKey{ID: "v0", Key: "expired", Expires: now.Add(-time.Hour)},
+	)
+
+	tests := []struct {
+		name    string
+		signer  *HashSigner
+		id      string
+		wantKey string
+		wantErr error
+	}{
+		{name: "default key", signer: keyed, wantKey: "default"},
+		{name: "old key in grace period", signer: keyed, id: "v1", wantKey: "old"},
+		{name: "new key", signer: keyed, id: "v2", wantKey: "new"},
+		{name: "expired key", signer: keyed, id: "v0", wantErr: ErrExpiredKey},
+		{name: "unknown key", signer: keyed, id: "v3", wantErr: ErrUnknownKey},
+		{name: "single key", signer: NewHashSigner("single"), wantKey: "single"},
+		{name: "single key with id", signer: NewHashSigner("single"), id: "v1", wantErr: ErrUnknownKey},
+		{name: "no default key", signer: NewHashSignerWithKeys(SigningKey{ID: "v1", Key: "k"}), wantErr: ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.ForKey(tt.id, now)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKey, got.key)
+		})
+	}
+
+	assert.Nil(t, NewHashSignerWithKeys(SigningKey{ID: "v1"}))
+}
@@ -0,0 +1,37 @@
+package telemetry
+
+// ClientMetrics are self-metrics of a client reporting metrics to a single output.
+// All of them are labeled with the transport and the name of the output.
+type ClientMetrics struct {
+	// Requests counts requests sent to the server
+	Requests *Counter
+
+	// Failed counts requests which failed after all retries or were rejected by the server
+	Failed *Counter
+
+	// Bytes counts bytes of request payloads
+	Bytes *Counter
+
+	// Latency is the duration of the last report in seconds
+	Latency *Gauge
+
+	// Queue is the number of requests of the current report waiting to be sent
+	Queue *Gauge
+
+	// Dropped counts metrics which were not delivered to the server
+	Dropped *Counter
+}
+
+// NewClientMetrics creates metrics of the client in the registry.
+func NewClientMetrics(reg *Registry, transport, output string) *ClientMetrics {
+	labels := map[string]string{"transport": transport, "output": output}
+
+	return &ClientMetrics{
+		Requests: reg.Counter(AgentPrefix+"requests_total", labels),
+		Failed:   reg.Counter(AgentPrefix+"requests_failed_total", labels),
+		Bytes:    reg.Counter(AgentPrefix+"sent_bytes_total", labels),
+		Latency:  reg.Gauge(AgentPrefix+"report_duration_seconds", labels),
+		Queue:    reg.Gauge(AgentPrefix+"queue_depth", labels),
+		Dropped:  reg.Counter(AgentPrefix+"dropped_metrics_total", labels),
+	}
+}
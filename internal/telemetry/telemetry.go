@@ -0,0 +1,383 @@
+// Package telemetry provides self-metrics of the agent and the server,
+// i.e. metrics describing the operation of the application itself.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+)
+
+const (
+	// AgentPrefix is the prefix reserved for names of self-metrics of the agent.
+	AgentPrefix = "agent_"
+
+	// ServerPrefix is the prefix of names of self-metrics of the server.
+	ServerPrefix = "server_"
+)
+
+// DefaultBuckets are upper bounds of histogram buckets suitable for latencies in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type (
+	// Registry holds self-metrics. Metrics are identified by name and labels
+	// and created on first use.
+	Registry struct {
+		mu         sync.RWMutex
+		counters   map[string]*Counter
+		gauges     map[string]*Gauge
+		histograms map[string]*Histogram
+		hooks      []func()
+	}
+
+	// Counter is a monotonically increasing metric.
+	Counter struct {
+		v atomic.Int64
+	}
+
+	// Gauge is a metric that can go up and down.
+	Gauge struct {
+		bits atomic.Uint64
+	}
+
+	// Histogram counts observed values in buckets. It is exposed as a set
+	// of series: cumulative bucket counters labeled with the upper bound le,
+	// the sum and the count of observations.
+	Histogram struct {
+		name    string
+		labels  map[string]string
+		buckets []float64
+		counts  []atomic.Int64
+		count   atomic.Int64
+		sum     Gauge
+	}
+)
+
+// NewRegistry creates a new registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*Counter),
+		gauges:     make(map[string]*Gauge),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// Counter returns the counter with the given name and labels.
+func (r *Registry) Counter(name string, labels map[string]string) *Counter {
+	id := models.SeriesName(name, labels)
+
+	r.mu.RLock()
+	c, ok := r.counters[id]
+	r.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok = r.counters[id]; !ok {
+		c = &Counter{}
+		r.counters[id] = c
+	}
+
+	return c
+}
+
+// Gauge returns the gauge with the given name and labels.
+func (r *Registry) Gauge(name string, labels map[string]string) *Gauge {
+	id := models.SeriesName(name, labels)
+
+	r.mu.RLock()
+	g, ok := r.gauges[id]
+	r.mu.RUnlock()
+	if ok {
+		return g
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if g, ok = r.gauges[id]; !ok {
+		g = &Gauge{}
+		r.gauges[id] = g
+	}
+
+	return g
+}
+
+// Histogram returns the histogram with the given name and labels. Buckets are
+// upper bounds in ascending order, DefaultBuckets are used if they are empty.
+// Buckets of an existing histogram are not changed.
+func (r *Registry) Histogram(name string, labels map[string]string, buckets []float64) *Histogram {
+	id := models.SeriesName(name, labels)
+
+	r.mu.RLock()
+	h, ok := r.histograms[id]
+	r.mu.RUnlock()
+	if ok {
+		return h
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if h, ok = r.histograms[id]; !ok {
+		if len(buckets) == 0 {
+			buckets = DefaultBuckets
+		}
+
+		h = &Histogram{
+			name:    name,
+			labels:  labels,
+			buckets: buckets,
+			counts:  make([]atomic.Int64, len(buckets)),
+		}
+		r.histograms[id] = h
+	}
+
+	return h
+}
+
+// OnCollect adds the function called before values of metrics are read, so metrics
+// mirroring state owned by other components, e.g. statistics of a pool, are up to date.
+func (r *Registry) OnCollect(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, f)
+}
+
+// runHooks calls the functions added with OnCollect.
+func (r *Registry) runHooks() {
+	r.mu.RLock()
+	hooks := r.hooks
+	r.mu.RUnlock()
+
+	for _, f := range hooks {
+		f()
+	}
+}
+
+// Collect returns current values of all metrics. It implements services.Collector,
+// so self-metrics of the agent are reported along with the other metrics.
+// Histograms are returned as bucket and count counters and a sum gauge.
+func (r *Registry) Collect(_ context.Context) (map[string]models.Metric, error) {
+	r.runHooks()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	metrics := make(map[string]models.Metric, len(r.counters)+len(r.gauges))
+	for k, c := range r.counters {
+		metrics[k] = models.Metric{Type: "counter", Val: c.Value()}
+	}
+	for k, g := range r.gauges {
+		metrics[k] = models.Metric{Type: "gauge", Val: g.Value()}
+	}
+	for _, h := range r.histograms {
+		for _, s := range h.series() {
+			metrics[s.id] = s.metric
+		}
+	}
+
+	return metrics, nil
+}
+
+// WritePrometheus writes current values of all metrics in Prometheus text format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	type family struct {
+		typ     string
+		samples []sample
+	}
+
+	r.runHooks()
+
+	families := make(map[string]*family)
+	add := func(name, typ string, samples ...sample) {
+		f, ok := families[name]
+		if !ok {
+			f = &family{typ: typ}
+			families[name] = f
+		}
+		f.samples = append(f.samples, samples...)
+	}
+
+	r.mu.RLock()
+	for _, id := range sortedKeys(r.counters) {
+		add(baseName(id), "counter", sample{id, models.Metric{Type: "counter", Val: r.counters[id].Value()}})
+	}
+	for _, id := range sortedKeys(r.gauges) {
+		add(baseName(id), "gauge", sample{id, models.Metric{Type: "gauge", Val: r.gauges[id].Value()}})
+	}
+	for _, id := range sortedKeys(r.histograms) {
+		h := r.histograms[id]
+		add(h.name, "histogram", h.series()...)
+	}
+	r.mu.RUnlock()
+
+	for _, name := range sortedKeys(families) {
+		f := families[name]
+		if _, err := fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ); err != nil {
+			return err
+		}
+
+		for _, s := range f.samples {
+			var val string
+			switch v := s.metric.Val.(type) {
+			case int64:
+				val = strconv.FormatInt(v, 10)
+			case float64:
+				val = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+
+			if _, err := fmt.Fprintf(w, "%s %s\n", s.id, val); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Handler returns the handler exposing metrics in Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := r.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func baseName(id string) string {
+	name, _, err := models.ParseSeriesName(id)
+	if err != nil {
+		return id
+	}
+
+	return name
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increments the counter by n.
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add adds v to the gauge.
+func (g *Gauge) Add(v float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// Observe adds the value to the histogram.
+func (h *Histogram) Observe(v float64) {
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i].Add(1)
+			break
+		}
+	}
+
+	h.count.Add(1)
+	h.sum.Add(v)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() int64 {
+	return h.count.Load()
+}
+
+// Sum returns the sum of observed values.
+func (h *Histogram) Sum() float64 {
+	return h.sum.Value()
+}
+
+type sample struct {
+	id     string
+	metric models.Metric
+}
+
+// series returns series of the histogram in the order of buckets.
+func (h *Histogram) series() []sample {
+	series := make([]sample, 0, len(h.buckets)+3)
+
+	var cumulative int64
+	for i, b := range h.buckets {
+		cumulative += h.counts[i].Load()
+		series = append(series, sample{
+			id:     models.SeriesName(h.name+"_bucket", h.withLabel("le", strconv.FormatFloat(b, 'g', -1, 64))),
+			metric: models.Metric{Type: "counter", Val: cumulative},
+		})
+	}
+
+	count := h.Count()
+	series = append(series,
+		sample{
+			id:     models.SeriesName(h.name+"_bucket", h.withLabel("le", "+Inf")),
+			metric: models.Metric{Type: "counter", Val: count},
+		},
+		sample{
+			id:     models.SeriesName(h.name+"_sum", h.labels),
+			metric: models.Metric{Type: "gauge", Val: h.Sum()},
+		},
+		sample{
+			id:     models.SeriesName(h.name+"_count", h.labels),
+			metric: models.Metric{Type: "counter", Val: count},
+		},
+	)
+
+	return series
+}
+
+func (h *Histogram) withLabel(k, v string) map[string]string {
+	labels := make(map[string]string, len(h.labels)+1)
+	for lk, lv := range h.labels {
+		labels[lk] = lv
+	}
+	labels[k] = v
+
+	return labels
+}
@@ -0,0 +1,86 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/leonf08/metrics-yp.git/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Collect(t *testing.T) {
+	reg := NewRegistry()
+
+	c := reg.Counter("agent_requests_total", map[string]string{"output": "http"})
+	c.Inc()
+	c.Add(2)
+	assert.Same(t, c, reg.Counter("agent_requests_total", map[string]string{"output": "http"}))
+
+	g := reg.Gauge("agent_queue_depth", nil)
+	g.Set(1.5)
+	g.Add(-0.5)
+
+	got, err := reg.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]models.Metric{
+		`agent_requests_total{output="http"}`: {Type: "counter", Val: int64(3)},
+		"agent_queue_depth":                   {Type: "gauge", Val: float64(1)},
+	}, got)
+}
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("agent_requests_total", map[string]string{"output": "b"}).Add(2)
+	reg.Counter("agent_requests_total", map[string]string{"output": "a"}).Inc()
+	reg.Gauge("agent_queue_depth", nil).Set(0.5)
+
+	var b strings.Builder
+	require.NoError(t, reg.WritePrometheus(&b))
+	assert.Equal(t, `# TYPE agent_queue_depth gauge
+agent_queue_depth 0.5
+# TYPE agent_requests_total counter
+agent_requests_total{output="a"} 1
+agent_requests_total{output="b"} 2
+`, b.String())
+}
+
+func TestHistogram_Observe(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.Histogram("server_request_duration_seconds", map[string]string{"route": "/"}, []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	assert.Equal(t, int64(3), h.Count())
+	assert.InDelta(t, 2.55, h.Sum(), 1e-9)
+
+	var b strings.Builder
+	require.NoError(t, reg.WritePrometheus(&b))
+	assert.Equal(t, `# TYPE server_request_duration_seconds histogram
+server_request_duration_seconds_bucket{le="0.1",route="/"} 1
+server_request_duration_seconds_bucket{le="1",route="/"} 2
+server_request_duration_seconds_bucket{le="+Inf",route="/"} 3
+server_request_duration_seconds_sum{route="/"} 2.55
+server_request_duration_seconds_count{route="/"} 3
+`, b.String())
+}
+
+func TestRegistry_OnCollect(t *testing.T) {
+	reg := NewRegistry()
+
+	var n float64
+	reg.OnCollect(func() {
+		n++
+		reg.Gauge("server_db_pool_total_conns", nil).Set(n)
+	})
+
+	got, err := reg.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, models.Metric{Type: "gauge", Val: float64(1)}, got["server_db_pool_total_conns"])
+
+	var b strings.Builder
+	require.NoError(t, reg.WritePrometheus(&b))
+	assert.Contains(t, b.String(), "server_db_pool_total_conns 2\n")
+}
@@ -0,0 +1,185 @@
+// Package tlsutil builds TLS configurations of servers and clients.
+// Certificates are reloaded from their files when the files change,
+// so certificates can be renewed without a restart.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader loads a certificate with its key and loads them again
+// when any of the files is modified.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertReloader creates a new reloader and loads the certificate.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate returns the certificate for tls.Config of a server.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate()
+}
+
+// GetClientCertificate returns the certificate for tls.Config of a client.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate()
+}
+
+// certificate returns the loaded certificate, the certificate is loaded again
+// if the files are modified after it was loaded. If loading fails, the previous
+// certificate is kept, so a partially written file doesn't break handshakes.
+func (r *CertReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.lastModified()
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+
+		return nil, err
+	}
+
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+
+		return nil, err
+	}
+
+	r.cert, r.modTime = &cert, modTime
+
+	return r.cert, nil
+}
+
+func (r *CertReloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+
+	return last, nil
+}
+
+// ServerConfig returns the configuration of a server with the certificate and the key.
+// If the client CA bundle is set, clients must present certificates signed by one of its CAs.
+func ServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+
+	if clientCAFile != "" {
+		pool, err := LoadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// ClientConfig returns the configuration of a client. Server certificates are verified
+// with the CA bundle if it is set and with the system roots otherwise. The client certificate
+// is presented to the server if it is set. The server name overrides the name of the host
+// the certificate is verified for.
+func ClientConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		r, err := NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.GetClientCertificate = r.GetClientCertificate
+	}
+
+	return cfg, nil
+}
+
+// LoadCertPool loads PEM encoded certificates from the file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates in %s", file)
+	}
+
+	return pool, nil
+}
+
+// CheckKeyPair checks that the certificate and the key are both set
+// and can be loaded or are both empty.
+func CheckKeyPair(certFile, keyFile string) error {
+	switch {
+	case certFile == "" && keyFile == "":
+		return nil
+	case certFile == "" || keyFile == "":
+		return errors.New("certificate and key must be set together")
+	}
+
+	_, err := tls.LoadX509KeyPair(certFile, keyFile)
+	return err
+}